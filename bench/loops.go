@@ -0,0 +1,93 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package bench
+
+import (
+	"context"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// The steps below are shared by the Runner and the send command, so both
+// count and recycle the messages the same way.
+
+// NewMessage returns a pooled message with a copy of payload, for the
+// address of the URL when address is empty
+func NewMessage(payload []byte, settled bool, address string) *transport.Message {
+	msg := transport.NewMessage()
+	msg.Body = append(msg.Body, payload...)
+	msg.Settled = settled
+	msg.Address = address
+	return msg
+}
+
+// Send transfers msg on t and counts it, with its size, as sent by thread
+// of st or as failed. It releases msg unless it was sent unsettled, which
+// leaves it to the ack loop.
+func Send(ctx context.Context, t transport.Transport, msg *transport.Message, st *stats.Stats, thread int) error {
+	settled, size := msg.Settled, len(msg.Body)
+	err := t.Send(ctx, msg)
+	if err != nil {
+		st.Failed()
+	} else {
+		st.Sent(thread)
+		st.Size(thread, size)
+	}
+	if settled || err != nil {
+		msg.Release()
+	}
+	return err
+}
+
+// AckLoop processes the outcomes of unsettled messages from acks until ctx
+// is done. observe, if not nil, sees every outcome first; the
+// acknowledgements are then counted on st and the messages released.
+func AckLoop(ctx context.Context, acks <-chan transport.Outcome, st *stats.Stats, observe func(transport.Outcome)) {
+	for {
+		select {
+		case out := <-acks:
+			if observe != nil {
+				observe(out)
+			}
+			if out.Error == nil {
+				st.Acked()
+			}
+			out.Message.Release()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WaitAcks polls the outcomes, the acknowledgements counted on st plus the
+// errors counted by failed, until they reach expected, giving up after
+// timeout
+func WaitAcks(st *stats.Stats, failed func() int64, expected int64, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for st.Snapshot().Acked+failed() < expected {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
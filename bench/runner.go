@@ -0,0 +1,352 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package bench runs load phases in process, so Go test suites can drive
+// the bench and assert on its results without the command line:
+//
+//	r, err := bench.New(bench.Config{URL: "amqp://qdr:5672/collectd/telemetry", Hosts: 100, Intervals: 10})
+//	if err != nil { ... }
+//	if err := r.Start(ctx); err != nil { ... }
+//	res := r.Wait()
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+	"github.com/infrawatch/telemetry-bench/stats"
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// Config is the load phase a Runner sends. The zero values of the fields
+// default the way the options of the send command do.
+type Config struct {
+	// URL of the endpoint, any scheme with a registered transport
+	URL string
+	// Transport is handed to the transport, its URL is set from URL
+	Transport transport.Config
+
+	Hosts           int
+	HostOffset      int
+	HostPrefix      string
+	Plugins         int
+	Types           int
+	TypeInstances   int
+	PluginInstances int
+	// Variance is the percentage of the hosts with one plugin instance
+	// more or fewer, from 0 to 100
+	Variance       float64
+	Uptime         bool
+	ValueGenerator string
+	Naming         generator.Naming
+	Ranges         map[string]generator.ValueRange
+	Format         generator.Format
+
+	// Interval is the generation interval, a second by default
+	Interval time.Duration
+	// Intervals is how many intervals to send, 0 to send until Stop
+	Intervals int
+	// Threads is the number of send threads
+	Threads int
+	// Ack sends the messages unsettled and counts their acknowledgements
+	Ack bool
+}
+
+// Results are the counts of a load phase
+type Results struct {
+	Series    int
+	Intervals int64
+	Generated int64
+	Sent      int64
+	Failed    int64
+	Acked     int64
+	// Rejected counts the unsettled messages with an error outcome
+	Rejected int64
+	Elapsed  time.Duration
+	// Rate is the messages sent per second
+	Rate float64
+	// Errors are the transport errors, if the transport counts them
+	Errors transport.ErrorCounts
+}
+
+// Runner sends the load phase of a Config
+type Runner struct {
+	cfg   Config
+	hosts []generator.Host
+	st    *stats.Stats
+	t     transport.Transport
+
+	stop     context.CancelFunc
+	done     chan struct{}
+	lock     sync.Mutex
+	started  bool
+	rejected int64
+	results  Results
+}
+
+// New generates the hosts of cfg, ready to Start
+func New(cfg Config) (*Runner, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("bench: no URL")
+	}
+	if cfg.Hosts == 0 {
+		cfg.Hosts = 1
+	}
+	for _, n := range []*int{&cfg.Plugins, &cfg.Types, &cfg.TypeInstances, &cfg.PluginInstances, &cfg.Threads} {
+		if *n == 0 {
+			*n = 1
+		}
+	}
+	if cfg.ValueGenerator == "" {
+		cfg.ValueGenerator = "random"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Variance < 0 || cfg.Variance > 100 {
+		return nil, fmt.Errorf("bench: Variance %v isn't between 0 and 100", cfg.Variance)
+	}
+	if cfg.Transport.AckBuffer == 0 {
+		cfg.Transport.AckBuffer = 100
+	}
+	intervalSec := int(cfg.Interval / time.Second)
+	if intervalSec < 1 {
+		intervalSec = 1
+	}
+
+	hosts, err := generator.GenerateHosts(generator.Topology{
+		HostPrefix:      cfg.HostPrefix,
+		Hosts:           cfg.Hosts,
+		HostOffset:      cfg.HostOffset,
+		Plugins:         cfg.Plugins,
+		Types:           cfg.Types,
+		TypeInstances:   cfg.TypeInstances,
+		PluginInstances: cfg.PluginInstances,
+		IntervalSec:     intervalSec,
+		Variance:        cfg.Variance,
+		Uptime:          cfg.Uptime,
+		ValueGenerator:  cfg.ValueGenerator,
+		Naming:          cfg.Naming,
+		Ranges:          cfg.Ranges,
+		Format:          cfg.Format,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := &Runner{cfg: cfg, hosts: hosts, done: make(chan struct{})}
+	for _, h := range hosts {
+		for p := range h.Plugins {
+			r.results.Series += h.Plugins[p].Series()
+		}
+	}
+	return r, nil
+}
+
+// Start connects and starts sending in the background. The phase ends once
+// its intervals are sent, on Stop, or when ctx is done. A Runner runs its
+// phase once: Start fails if it succeeded before.
+func (r *Runner) Start(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.started {
+		return errors.New("bench: Runner already started")
+	}
+	t, err := transport.New(r.cfg.URL, r.cfg.Transport)
+	if err != nil {
+		return err
+	}
+	if err := t.Connect(ctx); err != nil {
+		return err
+	}
+	r.started = true
+	r.t = t
+	r.st = stats.New(r.cfg.Threads)
+
+	ctx, r.stop = context.WithCancel(ctx)
+	queue := make(chan *transport.Message, 200)
+	go r.generate(ctx, queue)
+
+	var senders sync.WaitGroup
+	for thread := 0; thread < r.cfg.Threads; thread++ {
+		senders.Add(1)
+		go func(thread int) {
+			defer senders.Done()
+			r.send(ctx, thread, queue)
+		}(thread)
+	}
+	ackCtx, stopAck := context.WithCancel(context.Background())
+	acks := make(chan struct{})
+	go func() {
+		defer close(acks)
+		AckLoop(ackCtx, t.Acks(), r.st, r.observe)
+	}()
+
+	go func() {
+		senders.Wait()
+		// the last dispositions are still on their way
+		if r.cfg.Ack {
+			WaitAcks(r.st, r.rejectedCount, r.st.Snapshot().Sent, 10*time.Second)
+		}
+		stopAck()
+		<-acks
+		r.finish()
+		close(r.done)
+	}()
+	return nil
+}
+
+// generate queues the messages of every interval on its schedule, and
+// closes queue when done
+func (r *Runner) generate(ctx context.Context, queue chan<- *transport.Message) {
+	defer close(queue)
+	start := time.Now()
+	for i := 0; r.cfg.Intervals == 0 || i < r.cfg.Intervals; i++ {
+		r.st.StartInterval()
+		for h := range r.hosts {
+			for p := range r.hosts[h].Plugins {
+				plugin := &r.hosts[h].Plugins[p]
+				if !plugin.Due(i) {
+					continue
+				}
+				ok := true
+				plugin.EachMetricMessage(func(payload []byte) bool {
+					msg := NewMessage(payload, !r.cfg.Ack, "")
+					select {
+					case queue <- msg:
+						r.st.Generated(1)
+					case <-ctx.Done():
+						msg.Release()
+						ok = false
+					}
+					return ok
+				})
+				if !ok {
+					return
+				}
+			}
+		}
+		if i+1 == r.cfg.Intervals {
+			return
+		}
+		next := start.Add(time.Duration(i+1) * r.cfg.Interval)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send transfers the queued messages until the queue is closed and empty,
+// or ctx is done
+func (r *Runner) send(ctx context.Context, thread int, queue <-chan *transport.Message) {
+	for msg := range queue {
+		if ctx.Err() != nil {
+			msg.Release()
+			continue
+		}
+		msg.Created = time.Now()
+		Send(ctx, r.t, msg, r.st, thread)
+	}
+}
+
+// observe counts the unsettled messages with an error outcome
+func (r *Runner) observe(out transport.Outcome) {
+	if out.Error != nil {
+		r.lock.Lock()
+		r.rejected++
+		r.lock.Unlock()
+	}
+}
+
+// rejectedCount returns the number of unsettled messages with an error
+// outcome
+func (r *Runner) rejectedCount() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rejected
+}
+
+// finish closes the connection and records the results
+func (r *Runner) finish() {
+	res := r.current()
+	if c, ok := r.t.(transport.ErrorCounter); ok {
+		res.Errors = c.Errors()
+	}
+	r.t.Close()
+	r.lock.Lock()
+	r.results = res
+	r.lock.Unlock()
+}
+
+// current returns the results so far
+func (r *Runner) current() Results {
+	snap := r.st.Snapshot()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := r.results
+	res.Intervals = snap.Intervals
+	res.Generated = snap.Generated
+	res.Sent = snap.Sent
+	res.Failed = snap.Failed
+	res.Acked = snap.Acked
+	res.Rejected = r.rejected
+	res.Elapsed = snap.Elapsed
+	if snap.Elapsed > 0 {
+		res.Rate = float64(snap.Sent) / snap.Elapsed.Seconds()
+	}
+	return res
+}
+
+// Stop ends the phase early and waits for it to finish
+func (r *Runner) Stop() Results {
+	if r.stop != nil {
+		r.stop()
+	}
+	return r.Wait()
+}
+
+// Wait blocks until the phase is over and returns its results
+func (r *Runner) Wait() Results {
+	if r.stop == nil {
+		return r.results
+	}
+	<-r.done
+	return r.Results()
+}
+
+// Results returns the results so far while the phase runs, and the final
+// ones once it's over
+func (r *Runner) Results() Results {
+	if r.st == nil {
+		return r.results
+	}
+	select {
+	case <-r.done:
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.results
+	default:
+		return r.current()
+	}
+}
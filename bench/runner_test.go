@@ -0,0 +1,82 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestRunner sends a few intervals to null:// and checks every message
+// generated is sent, and acknowledged when unsettled
+func TestRunner(t *testing.T) {
+	for _, ack := range []bool{false, true} {
+		r, err := New(Config{URL: "null://", Hosts: 3, Plugins: 2, Variance: 50, Intervals: 3, Interval: 10 * time.Millisecond, Threads: 2, Ack: ack})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		res := r.Wait()
+		if res.Series == 0 || res.Intervals != 3 {
+			t.Fatalf("ack %v: %d series, %d intervals", ack, res.Series, res.Intervals)
+		}
+		if res.Sent != res.Generated || res.Sent < int64(res.Series) || res.Failed != 0 {
+			t.Errorf("ack %v: %d generated, %d sent, %d failed", ack, res.Generated, res.Sent, res.Failed)
+		}
+		acked := int64(0)
+		if ack {
+			acked = res.Sent
+		}
+		if res.Acked != acked || res.Rejected != 0 {
+			t.Errorf("ack %v: %d sent, %d acked, %d rejected", ack, res.Sent, res.Acked, res.Rejected)
+		}
+	}
+}
+
+// TestVariance checks Variance is taken as a percentage
+func TestVariance(t *testing.T) {
+	if _, err := New(Config{URL: "null://", Hosts: 10, Variance: 20}); err != nil {
+		t.Errorf("Variance 20: %v", err)
+	}
+	if _, err := New(Config{URL: "null://", Variance: 120}); err == nil {
+		t.Error("Variance 120 accepted")
+	}
+}
+
+// TestStartTwice checks a Runner refuses to start its phase again
+func TestStartTwice(t *testing.T) {
+	r, err := New(Config{URL: "null://", Intervals: 1, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(context.Background()); err == nil {
+		t.Error("second Start succeeded")
+	}
+	r.Wait()
+	if err := r.Start(context.Background()); err == nil {
+		t.Error("Start after Wait succeeded")
+	}
+}
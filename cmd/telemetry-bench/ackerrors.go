@@ -0,0 +1,133 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+	"pack.ag/amqp"
+)
+
+// ackErrors counts the unsettled messages that came back with an error
+// instead of an acknowledgement, by kind, rather than ending the run on the
+// first: a broker failover rejecting a handful of deliveries shouldn't
+// throw away an overnight test. The first few errors of every kind are
+// logged with their message. Any goroutine may observe.
+type ackErrors struct {
+	samples *int
+	fatal   *bool
+
+	sync.Mutex
+	total  int64
+	counts map[string]int64
+}
+
+func addAckErrorFlags(fs *flag.FlagSet) *ackErrors {
+	return &ackErrors{
+		samples: fs.Int("ackerrorsamples", 5, "With -ack, log the first ACKERRORSAMPLES failed acknowledgements of each kind (the rest are counted only)"),
+		fatal:   fs.Bool("ackerrorfatal", false, "With -ack, stop the run at the first failed acknowledgement"),
+		counts:  map[string]int64{},
+	}
+}
+
+// observe counts the error of out, logging it if it's among the first of
+// its kind
+func (a *ackErrors) observe(out transport.Outcome) {
+	if *a.fatal {
+		log.Fatalf("acknowledgement %s error: %v", out.Message.Body, out.Error)
+	}
+	kind := ackErrorKind(out.Error)
+	a.Lock()
+	a.total++
+	a.counts[kind]++
+	n := a.counts[kind]
+	a.Unlock()
+	if n <= int64(*a.samples) {
+		log.Printf("Acknowledgement error (%s, %d of %d logged): %v, message %.200s", kind, n, *a.samples, out.Error, out.Message.Body)
+	}
+}
+
+// ackErrorKind names the kind of an acknowledgement error, the error
+// condition of rejections
+func ackErrorKind(err error) string {
+	switch e := err.(type) {
+	case *amqp.Error:
+		return "rejected " + string(e.Condition)
+	case *amqp.DetachError:
+		return "link detached"
+	}
+	switch err {
+	case amqp.ErrLinkClosed:
+		return "link detached"
+	case amqp.ErrSessionClosed, amqp.ErrConnClosed:
+		return "connection closed"
+	}
+	return "other"
+}
+
+// count returns the number of failed acknowledgements
+func (a *ackErrors) count() int64 {
+	a.Lock()
+	defer a.Unlock()
+	return a.total
+}
+
+// kinds returns the number of failed acknowledgements by kind
+func (a *ackErrors) kinds() map[string]int64 {
+	a.Lock()
+	defer a.Unlock()
+	kinds := make(map[string]int64, len(a.counts))
+	for kind, n := range a.counts {
+		kinds[kind] = n
+	}
+	return kinds
+}
+
+// report returns the failed acknowledgements by kind, most frequent first,
+// e.g. 12 (9 rejected amqp:resource-limit-exceeded, 3 link detached)
+func (a *ackErrors) report() string {
+	kinds := a.kinds()
+	names := make([]string, 0, len(kinds))
+	var total int64
+	for kind, n := range kinds {
+		names = append(names, kind)
+		total += n
+	}
+	if total == 0 {
+		return "none"
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if kinds[names[i]] != kinds[names[j]] {
+			return kinds[names[i]] > kinds[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, kind := range names {
+		parts[i] = fmt.Sprintf("%d %s", kinds[kind], kind)
+	}
+	return fmt.Sprintf("%d (%s)", total, strings.Join(parts, ", "))
+}
@@ -0,0 +1,132 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+// statusBeacon publishes the status of the run to an AMQP control address
+// every few seconds, so one dashboard on the bus under test can follow
+// many bench instances
+type statusBeacon struct {
+	address  *string
+	interval *int
+	instance *string
+}
+
+func addBeaconFlags(fs *flag.FlagSet) *statusBeacon {
+	return &statusBeacon{
+		address:  fs.String("beaconaddress", "", "Publish the status of the run to this AMQP address on the router of the first URL (empty to disable)"),
+		interval: fs.Int("beaconinterval", 5, "Seconds between the -beaconaddress status messages"),
+		instance: fs.String("beaconinstance", "", "Name of this bench in the status messages (default the host name and process ID)"),
+	}
+}
+
+// beaconMessage is a status message, the /status report of an instance
+type beaconMessage struct {
+	Instance string `json:"instance"`
+	Command  string `json:"command"`
+	Time     int64  `json:"time"`
+	statusReport
+}
+
+// start publishes the status of command on the router of rawurl until
+// ctx is done. The returned function publishes the final status and stops.
+func (b *statusBeacon) start(ctx context.Context, command, rawurl string) func() {
+	if *b.address == "" {
+		return func() {}
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if u.Scheme != "amqp" && u.Scheme != "amqps" {
+		log.Printf("Status beacon needs an amqp URL, disabled for %s", rawurl)
+		return func() {}
+	}
+	instance := *b.instance
+	if instance == "" {
+		host, _ := os.Hostname()
+		instance = fmt.Sprintf("%s/%d", host, os.Getpid())
+	}
+
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		log.Fatal("Dialing AMQP server for the status beacon:", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatal("Creating AMQP status beacon session:", err)
+	}
+	sender, err := session.NewSender(amqp.LinkTargetAddress(*b.address))
+	if err != nil {
+		log.Fatal("Creating status beacon link:", err)
+	}
+
+	publish := func(ctx context.Context) error {
+		body, err := json.Marshal(beaconMessage{Instance: instance, Command: command, Time: time.Now().Unix(), statusReport: status.report()})
+		if err != nil {
+			return err
+		}
+		msg := amqp.NewMessage(body)
+		msg.Properties = &amqp.MessageProperties{ContentType: "application/json"}
+		msg.SendSettled = true
+		return sender.Send(ctx, msg)
+	}
+
+	beaconCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Duration(*b.interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			if err := publish(beaconCtx); err != nil && beaconCtx.Err() == nil {
+				log.Printf("Publishing the status beacon: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-beaconCtx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		stop()
+		<-done
+		// the final status goes out even after an interrupt
+		last, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := publish(last); err != nil {
+			log.Printf("Publishing the final status beacon: %v", err)
+		}
+		client.Close()
+	}
+}
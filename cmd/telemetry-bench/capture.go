@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// capture writes the payloads received to a file, one JSON line each with
+// the times it was created and received at, for offline diffing. On the
+// send side it writes a sample of the payloads sent the same way.
+type capture struct {
+	sync.Mutex
+	f *os.File
+	w *bufio.Writer
+}
+
+// captured is a line of the capture file
+type captured struct {
+	// Received and Created are in nanoseconds since the epoch, Created is
+	// the AMQP creation-time and left out when the message had none
+	Received int64 `json:"received"`
+	Created  int64 `json:"created,omitempty"`
+	// Run is the run ID of the sender, if it had one
+	Run     string `json:"run,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// sentCapture is a line of the capture file of send
+type sentCapture struct {
+	// Seq numbers the messages of the run from 1 in the order of their
+	// sends, across the threads, and Sent is in nanoseconds since the epoch
+	Seq     int64  `json:"seq"`
+	Sent    int64  `json:"sent"`
+	Run     string `json:"run,omitempty"`
+	Address string `json:"address,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// openCapture creates the capture file name, truncating an existing one
+func openCapture(name string) *capture {
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatal("Creating the capture file:", err)
+	}
+	return &capture{f: f, w: bufio.NewWriterSize(f, 1<<20)}
+}
+
+// write adds a line for payload, safe for concurrent use by the receivers
+func (c *capture) write(received, created time.Time, run string, payload []byte) {
+	line := captured{Received: received.UnixNano(), Run: run, Payload: string(payload)}
+	if !created.IsZero() {
+		line.Created = created.UnixNano()
+	}
+	c.writeLine(line)
+}
+
+// writeSent adds a line for the payload of message seq sent at sent
+func (c *capture) writeSent(seq int64, sent time.Time, run, address string, payload []byte) {
+	c.writeLine(sentCapture{Seq: seq, Sent: sent.UnixNano(), Run: run, Address: address, Payload: string(payload)})
+}
+
+func (c *capture) writeLine(line interface{}) {
+	b, err := json.Marshal(line)
+	if err != nil {
+		log.Fatal("Encoding the capture:", err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.w.Write(b)
+	if err := c.w.WriteByte('\n'); err != nil {
+		log.Fatal("Writing the capture:", err)
+	}
+}
+
+// close flushes and closes the file
+func (c *capture) close() {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.w.Flush(); err != nil {
+		log.Fatal("Writing the capture:", err)
+	}
+	if err := c.f.Close(); err != nil {
+		log.Fatal("Closing the capture file:", err)
+	}
+}
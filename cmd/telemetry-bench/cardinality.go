@@ -0,0 +1,89 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// cardinality counts the unique series identities of the collectd metrics
+// received, an independent check of the cardinality generated upstream and
+// of any series explosion on the way
+type cardinality struct {
+	sync.Mutex
+	seen map[string]struct{}
+	// last is the count of the last report
+	last int
+	// unparsed counts the payloads that aren't collectd metrics
+	unparsed int64
+}
+
+// identity is the part of a collectd value list naming its series
+type identity struct {
+	Host           string `json:"host"`
+	Plugin         string `json:"plugin"`
+	PluginInstance string `json:"plugin_instance"`
+	Type           string `json:"type"`
+	TypeInstance   string `json:"type_instance"`
+}
+
+func newCardinality() *cardinality {
+	return &cardinality{seen: map[string]struct{}{}}
+}
+
+// add records the series of the value lists of body
+func (c *cardinality) add(body []byte) {
+	var lists []identity
+	err := json.Unmarshal(body, &lists)
+
+	c.Lock()
+	defer c.Unlock()
+	if err != nil || len(lists) == 0 {
+		c.unparsed++
+		return
+	}
+	for _, vl := range lists {
+		key := strings.Join([]string{vl.Host, vl.Plugin, vl.PluginInstance, vl.Type, vl.TypeInstance}, "\x00")
+		c.seen[key] = struct{}{}
+	}
+}
+
+// count returns the number of unique series so far
+func (c *cardinality) count() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.seen)
+}
+
+// report returns the series count and its growth since the last report
+func (c *cardinality) report() string {
+	c.Lock()
+	defer c.Unlock()
+	n := len(c.seen)
+	s := fmt.Sprintf("%d series (+%d)", n, n-c.last)
+	c.last = n
+	if c.unparsed > 0 {
+		s += fmt.Sprintf(", %d not collectd", c.unparsed)
+	}
+	return s
+}
@@ -0,0 +1,98 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// chaos deliberately drops the connection, either on a timer or after a
+// number of messages, and measures how long it takes to recover
+type chaos struct {
+	interval  time.Duration
+	messages  int64
+	sinceDrop int64
+	trigger   chan struct{}
+	drops     int64
+	downtime  time.Duration
+}
+
+// randomize returns a value uniformly distributed in [n/2, 3n/2) so drops
+// from many bench instances don't line up
+func randomize(n int64) int64 {
+	return n/2 + rand.Int63n(n)
+}
+
+// sent is called by the send threads for every message, and triggers a drop
+// once the message threshold is crossed
+func (ch *chaos) sent(threshold *int64) {
+	if ch.messages <= 0 {
+		return
+	}
+	if atomic.AddInt64(&ch.sinceDrop, 1) == atomic.LoadInt64(threshold) {
+		select {
+		case ch.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run drops connections until ctx is cancelled
+func (ch *chaos) run(ctx context.Context, transports []transport.Transport, threshold *int64) {
+	var timer <-chan time.Time
+	for {
+		if ch.interval > 0 {
+			timer = time.After(time.Duration(randomize(int64(ch.interval))))
+		}
+		if ch.messages > 0 {
+			atomic.StoreInt64(threshold, randomize(ch.messages))
+		}
+
+		select {
+		case <-timer:
+		case <-ch.trigger:
+		case <-ctx.Done():
+			return
+		}
+
+		dropped := time.Now()
+		t := transports[rand.Intn(len(transports))]
+		t.Close()
+		for {
+			err := t.Connect(ctx)
+			if err == nil {
+				break
+			}
+			log.Println("Reconnecting:", err)
+			if !sleep(ctx, 100*time.Millisecond) {
+				return
+			}
+		}
+		ch.drops++
+		ch.downtime += time.Now().Sub(dropped)
+		atomic.StoreInt64(&ch.sinceDrop, 0)
+	}
+}
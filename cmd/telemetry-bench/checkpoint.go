@@ -0,0 +1,171 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// checkpoint persists the progress of a long run so that a crashed or
+// rescheduled bench picks up where it stopped rather than starting over
+type checkpoint struct {
+	path  *string
+	every *int
+
+	state checkpointState
+	saved time.Time
+}
+
+// checkpointState is the progress written to the checkpoint file
+type checkpointState struct {
+	// Seed seeds the random draws of the first leg, later legs continue
+	// from a seed derived from it and the interval they resume at
+	Seed int64 `json:"seed"`
+	// Legs is the number of runs the totals cover
+	Legs int `json:"legs"`
+	// Intervals is the number of intervals completed
+	Intervals  int64     `json:"intervals"`
+	Elapsed    float64   `json:"elapsed_seconds"`
+	Generated  int64     `json:"generated"`
+	ThreadSent []int64   `json:"thread_sent"`
+	Failed     int64     `json:"failed"`
+	Acked      int64     `json:"acked"`
+	Saved      time.Time `json:"saved"`
+}
+
+func addCheckpointFlags(fs *flag.FlagSet) *checkpoint {
+	return &checkpoint{
+		path:  fs.String("checkpoint", "", "File to save the progress of the run to, and to resume it from if it exists"),
+		every: fs.Int("checkpointinterval", 60, "Seconds between -checkpoint saves"),
+	}
+}
+
+func (c *checkpoint) enabled() bool {
+	return *c.path != ""
+}
+
+// load reads the checkpoint file if there is one and returns the seed for
+// the random draws of this leg
+func (c *checkpoint) load() (int64, error) {
+	c.state = checkpointState{Seed: time.Now().UnixNano()}
+	if !c.enabled() {
+		return c.state.Seed, nil
+	}
+	data, err := ioutil.ReadFile(*c.path)
+	if os.IsNotExist(err) {
+		return c.state.Seed, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(data, &c.state); err != nil {
+		return 0, fmt.Errorf("%s: %v", *c.path, err)
+	}
+	return c.state.Seed ^ c.state.Intervals, nil
+}
+
+// resumed returns the number of intervals completed by earlier legs
+func (c *checkpoint) resumed() int {
+	return int(c.state.Intervals)
+}
+
+// resume carries the totals of the earlier legs over into st
+func (c *checkpoint) resume(st *stats.Stats) {
+	if c.state.Legs == 0 {
+		return
+	}
+	st.Resume(stats.Snapshot{
+		Elapsed:    time.Duration(c.state.Elapsed * float64(time.Second)),
+		Generated:  c.state.Generated,
+		ThreadSent: c.state.ThreadSent,
+		Failed:     c.state.Failed,
+		Acked:      c.state.Acked,
+		Intervals:  c.state.Intervals,
+	})
+}
+
+// tick saves the checkpoint if -checkpointinterval has passed since the
+// last save
+func (c *checkpoint) tick(st *stats.Stats, completed int64) {
+	if !c.enabled() || time.Since(c.saved) < time.Duration(*c.every)*time.Second {
+		return
+	}
+	c.save(st, completed)
+}
+
+// save writes the progress so far with the number of intervals completed,
+// through a temporary file so that a crash while saving leaves the previous
+// checkpoint intact
+func (c *checkpoint) save(st *stats.Stats, completed int64) {
+	if !c.enabled() {
+		return
+	}
+	snap := st.Snapshot()
+	state := c.state
+	state.Legs++
+	state.Intervals = completed
+	state.Elapsed = snap.Elapsed.Seconds()
+	state.Generated = snap.Generated
+	state.ThreadSent = snap.ThreadSent
+	state.Failed = snap.Failed
+	state.Acked = snap.Acked
+	state.Saved = time.Now()
+	c.saved = state.Saved
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(*c.path+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(*c.path+".tmp", *c.path)
+	}
+	if err != nil {
+		fmt.Printf("Saving checkpoint: %v\n", err)
+	}
+}
+
+// finish removes the checkpoint of a run that went all the way, so the
+// next run starts afresh
+func (c *checkpoint) finish() {
+	if c.enabled() {
+		os.Remove(*c.path)
+	}
+}
+
+// report describes the earlier legs a resumed run covers
+func (c *checkpoint) report() string {
+	return fmt.Sprintf("resumed at interval %d after %d earlier run(s), %.0fs and %d messages sent before",
+		c.state.Intervals, c.state.Legs, c.state.Elapsed, sumCounts(c.state.ThreadSent))
+}
+
+// sumCounts adds up counts
+func sumCounts(counts []int64) int64 {
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
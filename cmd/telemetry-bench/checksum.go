@@ -0,0 +1,107 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+// The application properties carrying the payload checksum
+const (
+	crcProperty  = "telemetry_bench_crc32c"
+	hmacProperty = "telemetry_bench_hmac"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum signs every payload on the send side and checks the signatures
+// on the receive side, so corruption anywhere in the path shows up. Without
+// a key the checksum is a CRC-32C; with one it's an HMAC-SHA256, which also
+// catches payloads rewritten on purpose.
+type checksum struct {
+	on  *bool
+	key *string
+
+	checked, corrupt, missing int64
+}
+
+func addChecksumFlags(fs *flag.FlagSet) *checksum {
+	return &checksum{
+		on:  fs.Bool("checksum", false, "Sign every payload with a CRC-32C application property, verified by receive -checksum"),
+		key: fs.String("checksumkey", "", "Sign the payloads with an HMAC-SHA256 of this key instead of a CRC (implies -checksum)"),
+	}
+}
+
+func (c *checksum) enabled() bool {
+	return *c.on || *c.key != ""
+}
+
+// sign adds the checksum of body to props, which may be nil, and returns
+// them
+func (c *checksum) sign(props map[string]interface{}, body []byte) map[string]interface{} {
+	if props == nil {
+		props = make(map[string]interface{}, 2)
+	}
+	if *c.key != "" {
+		props[hmacProperty] = c.mac(body)
+	} else {
+		props[crcProperty] = crc32.Checksum(body, castagnoli)
+	}
+	return props
+}
+
+func (c *checksum) mac(body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(*c.key))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// verify checks body against the checksum in props and counts the outcome.
+// It returns false for a corrupt or unsigned message.
+func (c *checksum) verify(props map[string]interface{}, body []byte) bool {
+	var ok, signed bool
+	if *c.key != "" {
+		var sum []byte
+		sum, signed = props[hmacProperty].([]byte)
+		ok = signed && hmac.Equal(sum, c.mac(body))
+	} else {
+		var sum uint32
+		sum, signed = props[crcProperty].(uint32)
+		ok = signed && sum == crc32.Checksum(body, castagnoli)
+	}
+	atomic.AddInt64(&c.checked, 1)
+	switch {
+	case !signed:
+		atomic.AddInt64(&c.missing, 1)
+	case !ok:
+		atomic.AddInt64(&c.corrupt, 1)
+	}
+	return ok
+}
+
+func (c *checksum) report() string {
+	return fmt.Sprintf("%d checksums checked, %d corrupt, %d unsigned",
+		atomic.LoadInt64(&c.checked), atomic.LoadInt64(&c.corrupt), atomic.LoadInt64(&c.missing))
+}
@@ -0,0 +1,215 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+// clockEcho answers the clock echo requests of receivers on another machine
+// with the current time of this one
+type clockEcho struct {
+	enable  *bool
+	address *string
+}
+
+func addEchoFlags(fs *flag.FlagSet) *clockEcho {
+	return &clockEcho{
+		enable:  fs.Bool("clockecho", false, "Answer the clock echo requests of receivers calibrating their clock offset"),
+		address: fs.String("echoaddress", "telemetry-bench/echo", "Address the clock echo requests are sent to and answered from"),
+	}
+}
+
+// serve consumes the echo requests on the router of rawurl and answers each
+// with the time it was received, until ctx is done
+func (e *clockEcho) serve(ctx context.Context, rawurl string) {
+	if !*e.enable {
+		return
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if u.Scheme != "amqp" && u.Scheme != "amqps" {
+		log.Printf("Clock echo needs an amqp URL, disabled for %s", rawurl)
+		return
+	}
+
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		log.Fatal("Dialing AMQP server for the clock echo:", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatal("Creating AMQP clock echo session:", err)
+	}
+	requests, err := session.NewReceiver(amqp.LinkSourceAddress(*e.address), amqp.LinkCredit(10))
+	if err != nil {
+		log.Fatal("Creating clock echo link:", err)
+	}
+	go func() {
+		defer client.Close()
+		serveEcho(ctx, session, requests, func(*amqp.Message) [][]byte {
+			return [][]byte{strconv.AppendInt(nil, time.Now().UnixNano(), 10)}
+		})
+	}()
+}
+
+// clockCalibration estimates the offset of the clock of the senders from
+// this one with echo requests to a send -clockecho, so the latencies from
+// their timestamps can be corrected. Each calibration takes the sample with
+// the shortest round trip, assuming it was answered half way through.
+type clockCalibration struct {
+	interval *int
+	address  *string
+	// offset is the sender clock minus this one and rtt the round trip of
+	// its sample, in nanoseconds, both 0 until the first calibration
+	offset int64
+	rtt    int64
+}
+
+func addCalibrationFlags(fs *flag.FlagSet) *clockCalibration {
+	return &clockCalibration{
+		interval: fs.Int("calibrate", 0, "Seconds between calibrations of the sender clock offset with the echo requests of a send -clockecho (0 to disable)"),
+		address:  fs.String("echoaddress", "telemetry-bench/echo", "Address the clock echo requests are sent to"),
+	}
+}
+
+func (c *clockCalibration) enabled() bool {
+	return *c.interval > 0
+}
+
+// calibrated reports whether the offset has been estimated yet
+func (c *clockCalibration) calibrated() bool {
+	return atomic.LoadInt64(&c.rtt) > 0
+}
+
+// correct returns the latency d measured from a sender timestamp on this
+// clock
+func (c *clockCalibration) correct(d time.Duration) time.Duration {
+	return d + time.Duration(atomic.LoadInt64(&c.offset))
+}
+
+func (c *clockCalibration) report() string {
+	return fmt.Sprintf("clock offset %v (rtt %v)", time.Duration(atomic.LoadInt64(&c.offset)), time.Duration(atomic.LoadInt64(&c.rtt)))
+}
+
+// start connects to endPointURL and calibrates every interval until ctx is
+// done. Calibrations without any answer keep the previous offset.
+func (c *clockCalibration) start(ctx context.Context, endPointURL string) {
+	if !c.enabled() {
+		return
+	}
+	client, err := amqp.Dial(endPointURL)
+	if err != nil {
+		log.Fatal("Dialing AMQP server for the clock calibration:", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatal("Creating AMQP clock calibration session:", err)
+	}
+	requests, err := session.NewSender(amqp.LinkTargetAddress(*c.address))
+	if err != nil {
+		log.Fatal("Creating clock echo request link:", err)
+	}
+	replies, err := session.NewReceiver(amqp.LinkAddressDynamic(), amqp.LinkCredit(10))
+	if err != nil {
+		log.Fatal("Creating clock echo reply link:", err)
+	}
+
+	go func() {
+		defer client.Close()
+		interval := time.Duration(*c.interval) * time.Second
+		seq := uint64(0)
+		for {
+			start := time.Now()
+			var best, offset time.Duration
+			for k := 0; k < 8; k++ {
+				seq++
+				sent := time.Now()
+				req := &amqp.Message{
+					Properties: &amqp.MessageProperties{
+						MessageID: seq,
+						ReplyTo:   replies.Address(),
+					},
+					Data: [][]byte{[]byte(strconv.FormatUint(seq, 10))},
+				}
+				req.SendSettled = true
+				if err := requests.Send(ctx, req); err != nil {
+					return
+				}
+				remote, ok := c.answer(ctx, replies, seq)
+				received := time.Now()
+				if ctx.Err() != nil {
+					return
+				}
+				if !ok {
+					continue
+				}
+				rtt := received.Sub(sent)
+				if best == 0 || rtt < best {
+					best = rtt
+					offset = remote.Sub(sent.Add(rtt / 2))
+				}
+			}
+			if best > 0 {
+				atomic.StoreInt64(&c.offset, int64(offset))
+				atomic.StoreInt64(&c.rtt, int64(best))
+			} else {
+				log.Printf("No answer to the clock echo requests on %s", *c.address)
+			}
+			if !sleep(ctx, time.Until(start.Add(interval))) {
+				return
+			}
+		}
+	}()
+}
+
+// answer waits up to a second for the echo of request seq and returns the
+// remote time it holds
+func (c *clockCalibration) answer(ctx context.Context, replies *amqp.Receiver, seq uint64) (time.Time, bool) {
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	for {
+		resp, err := replies.Receive(waitCtx)
+		if err != nil {
+			return time.Time{}, false
+		}
+		resp.Accept()
+		if resp.Properties == nil || resp.Properties.CorrelationID != seq {
+			// a late answer to an earlier request
+			continue
+		}
+		ns, err := strconv.ParseInt(string(resp.GetData()), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, ns), true
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// readSummary reads the JSON summary of a run from a file, its last line
+// starting with {, so the whole output of a -summary-json run will do
+func readSummary(name string) (map[string]interface{}, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); strings.HasPrefix(line, "{") {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == "" {
+		return nil, fmt.Errorf("no JSON summary in %s", name)
+	}
+	var summary map[string]interface{}
+	if err := json.Unmarshal([]byte(last), &summary); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return summary, nil
+}
+
+// flattenSummary returns the numbers of a summary by their dotted path,
+// such as ack_latency_seconds.p99, with the loss percentage derived from
+// the counts as -slo loss does
+func flattenSummary(summary map[string]interface{}) map[string]float64 {
+	values := map[string]float64{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case float64:
+			values[prefix] = v
+		case map[string]interface{}:
+			for k, child := range v {
+				walk(strings.TrimPrefix(prefix+"."+k, "."), child)
+			}
+		}
+	}
+	walk("", summary)
+
+	ack, ok := summary["ack"].(bool)
+	if !ok {
+		// summaries from before the ack field: a run that acked anything
+		// required acks
+		ack = values["acked"] > 0
+	}
+	if loss, ok := lossPercent(int64(values["sent"]), int64(values["failed"]), int64(values["acked"]), ack); ok {
+		values["loss_percent"] = loss
+	}
+	return values
+}
+
+// keyMetric reports whether a metric is compared without -all
+func keyMetric(name string) bool {
+	return name == "rate" || name == "loss_percent" || strings.Contains(name, "latency") ||
+		strings.HasPrefix(name, "round_trip") || strings.HasPrefix(name, "connect_seconds.")
+}
+
+// higherIsBetter reports whether a metric improves as it grows
+func higherIsBetter(name string) bool {
+	return name == "rate" || name == "sent" || name == "acked" || name == "received" || strings.HasSuffix(name, "_rate")
+}
+
+// lowerIsBetter reports whether a metric improves as it shrinks
+func lowerIsBetter(name string) bool {
+	return name == "loss_percent" || name == "failed" || name == "rejections" || name == "overruns" ||
+		strings.Contains(name, "latency") || strings.Contains(name, "_seconds.") || strings.HasSuffix(name, "blocked_seconds") ||
+		strings.HasPrefix(name, "round_trip") || strings.HasSuffix(name, "errors") || strings.HasSuffix(name, "detaches")
+}
+
+// metricDiff is a metric of both runs
+type metricDiff struct {
+	Metric string  `json:"metric"`
+	A      float64 `json:"a"`
+	B      float64 `json:"b"`
+	Delta  float64 `json:"delta"`
+	// Change is the delta in percent of A, absent when A is 0
+	Change *float64 `json:"change_percent,omitempty"`
+	// Verdict is better or worse beyond the threshold, same within it,
+	// changed when the metric has no preferred direction
+	Verdict string `json:"verdict"`
+}
+
+// diffMetric compares a metric, significant beyond threshold percent
+func diffMetric(name string, a, b, threshold float64) metricDiff {
+	d := metricDiff{Metric: name, A: a, B: b, Delta: b - a, Verdict: "same"}
+	significant := a != b
+	if a != 0 {
+		change := (b - a) / math.Abs(a) * 100
+		d.Change = &change
+		significant = math.Abs(change) >= threshold
+	}
+	if !significant {
+		return d
+	}
+	improved := b > a
+	switch {
+	case higherIsBetter(name):
+	case lowerIsBetter(name):
+		improved = !improved
+	default:
+		d.Verdict = "changed"
+		return d
+	}
+	d.Verdict = "worse"
+	if improved {
+		d.Verdict = "better"
+	}
+	return d
+}
+
+func runCompare(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	threshold := fs.Float64("threshold", 5, "Percentage of change below which a metric counts as the same")
+	all := fs.Bool("all", false, "Compare every number of the summaries, not only the rate, loss and latencies")
+	regress := fs.Bool("regress", false, "Exit with status 1 if any metric got worse beyond the threshold")
+	summary := addSummaryFlags(fs)
+
+	cmd.parse(fs, args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Two results files are needed")
+		fs.Usage()
+		exit(1)
+	}
+	var runs [2]map[string]interface{}
+	var values [2]map[string]float64
+	for i, name := range fs.Args() {
+		var err error
+		if runs[i], err = readSummary(name); err != nil {
+			log.Fatal("Reading results:", err)
+		}
+		values[i] = flattenSummary(runs[i])
+		fmt.Printf("%c: %s", 'a'+i, name)
+		if id, ok := runs[i]["run_id"].(string); ok {
+			fmt.Printf(", run %s", id)
+		}
+		if labels, ok := runs[i]["labels"].(map[string]interface{}); ok {
+			l := runLabels{}
+			for k, v := range labels {
+				l[k] = fmt.Sprint(v)
+			}
+			fmt.Printf(", %s", l)
+		}
+		fmt.Println()
+	}
+
+	var names []string
+	for name := range values[0] {
+		if _, ok := values[1][name]; ok && (*all || keyMetric(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-36s %14s %14s %14s %9s\n", "metric", "a", "b", "delta", "change")
+	diffs := make([]metricDiff, len(names))
+	worse := 0
+	for i, name := range names {
+		d := diffMetric(name, values[0][name], values[1][name], *threshold)
+		diffs[i] = d
+		change := "-"
+		if d.Change != nil {
+			change = fmt.Sprintf("%+.1f%%", *d.Change)
+		}
+		fmt.Printf("%-36s %14.6g %14.6g %+14.6g %9s  %s\n", name, d.A, d.B, d.Delta, change, d.Verdict)
+		if d.Verdict == "worse" {
+			worse++
+		}
+	}
+	fmt.Printf("%d metrics compared, %d worse beyond %g%%\n", len(diffs), worse, *threshold)
+
+	summary.set("threshold_percent", *threshold)
+	summary.set("diff", diffs)
+	summary.set("worse", worse)
+	failed := *regress && worse > 0
+	if failed {
+		summary.set("exit_status", 1)
+	}
+	summary.print(cmd.name, false)
+	if failed {
+		exit(1)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestFlattenSummary checks the numbers are keyed by their dotted path and
+// the loss counts the unacked messages only of the runs that ack
+func TestFlattenSummary(t *testing.T) {
+	for _, tc := range []struct {
+		name, summary string
+		loss          float64
+		measured      bool
+	}{
+		{"unacked", `{"sent": 90, "failed": 10, "acked": 0, "ack": false}`, 10, true},
+		// a run with acks losing every message is at 100%, not just its
+		// failed sends
+		{"nothing acked", `{"sent": 90, "failed": 10, "acked": 0, "ack": true}`, 100, true},
+		{"acked", `{"sent": 90, "failed": 10, "acked": 80, "ack": true}`, 20, true},
+		{"no ack field", `{"sent": 100, "failed": 0, "acked": 75}`, 25, true},
+		{"nothing sent", `{"sent": 0, "failed": 0, "acked": 0, "ack": true}`, 0, false},
+	} {
+		var summary map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.summary), &summary); err != nil {
+			t.Fatal(err)
+		}
+		loss, ok := flattenSummary(summary)["loss_percent"]
+		if ok != tc.measured || loss != tc.loss {
+			t.Errorf("%s: loss %v (%v), want %v (%v)", tc.name, loss, ok, tc.loss, tc.measured)
+		}
+	}
+
+	var summary map[string]interface{}
+	json.Unmarshal([]byte(`{"run_id": "r", "rate": 1000, "ack_latency_seconds": {"p50": 0.01, "p99": 0.2}, "slos": [{"measured": 1}]}`), &summary)
+	values := flattenSummary(summary)
+	if len(values) != 3 || values["rate"] != 1000 || values["ack_latency_seconds.p50"] != 0.01 || values["ack_latency_seconds.p99"] != 0.2 {
+		t.Errorf("flattened %v", values)
+	}
+}
+
+// TestDiffMetric checks the verdicts follow the direction of the metrics
+// and the threshold
+func TestDiffMetric(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		a, b    float64
+		verdict string
+		change  float64
+	}{
+		{"rate", 1000, 1100, "better", 10},
+		{"rate", 1000, 900, "worse", -10},
+		{"rate", 1000, 1040, "same", 4},
+		{"ack_latency_seconds.p99", 0.1, 0.2, "worse", 100},
+		{"ack_latency_seconds.p99", 0.2, 0.1, "better", -50},
+		{"loss_percent", -1, 1, "worse", 200},
+		{"hosts", 10, 20, "changed", 100},
+	} {
+		d := diffMetric(tc.name, tc.a, tc.b, 5)
+		if d.Verdict != tc.verdict || d.Change == nil || *d.Change != tc.change || d.Delta != tc.b-tc.a {
+			t.Errorf("%s %v -> %v: %+v, want %s %v%%", tc.name, tc.a, tc.b, d, tc.verdict, tc.change)
+		}
+	}
+
+	// from 0 any change is significant, in no percentage
+	if d := diffMetric("failed", 0, 3, 5); d.Verdict != "worse" || d.Change != nil {
+		t.Errorf("failed 0 -> 3: %+v", d)
+	}
+	if d := diffMetric("failed", 0, 0, 5); d.Verdict != "same" || d.Change != nil {
+		t.Errorf("failed 0 -> 0: %+v", d)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to the upper-cased flag name to form the
+// environment variable that sets it, e.g. TELEMETRY_BENCH_HOSTS for -hosts
+const envPrefix = "TELEMETRY_BENCH_"
+
+// urlOption is the config file key and environment variable suffix that
+// provide the URL arguments when none are given on the command line
+const urlOption = "url"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// flagGiven reports whether the flag was set on the command line, in the
+// environment, in the config file or by the -preset
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// parse parses the command line, then fills in every flag that wasn't given
+// on it from the environment or the config file. Precedence is command line,
+// then TELEMETRY_BENCH_* environment variables, then the config file, then
+// the -preset, then the flag defaults. The output goes to the -logfile from
+// then on.
+func (c *command) parse(fs *flag.FlagSet, args []string) {
+	configFile := fs.String("config", os.Getenv(envName("config")), "JSON config file with flag names as keys (also "+envName("config")+")")
+	presetName := fs.String("preset", "", "Apply the option values and addresses of known deployments, comma separated: "+strings.Join(presetNames(), ", "))
+	profileName := fs.String("profile", "", "Apply the topology and interval of a representative cloud: "+strings.Join(profileNames(), ", "))
+	logs := addLogFlags(fs)
+	fs.Parse(args)
+
+	set := map[string]bool{"config": true}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	values := map[string][]string{}
+	if *configFile != "" {
+		var err error
+		values, err = readConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Reading config file %s: %v\n", *configFile, err)
+			os.Exit(1)
+		}
+	}
+	for name := range values {
+		if name != urlOption && fs.Lookup(name) == nil {
+			fmt.Fprintf(os.Stderr, "Config file %s: option %s is not supported by %s\n", *configFile, name, c.name)
+			os.Exit(1)
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			values[f.Name] = []string{v}
+		}
+	})
+	if v, ok := os.LookupEnv(envName(urlOption)); ok {
+		values[urlOption] = strings.Fields(v)
+	}
+
+	for name, vs := range values {
+		if name == urlOption {
+			c.configURLs = vs
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		for _, v := range vs {
+			if err := fs.Set(name, v); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid value %q for option %s: %v\n", v, name, err)
+				os.Exit(1)
+			}
+		}
+		set[name] = true
+	}
+
+	if *presetName != "" {
+		c.preset = lookupPreset(*presetName)
+		applyOptions(fs, c.preset.options, set)
+	}
+	if *profileName != "" {
+		applyOptions(fs, lookupProfile(*profileName).options, set)
+	}
+	logs.start()
+}
+
+// applyOptions sets the options that aren't set yet, and marks them set
+func applyOptions(fs *flag.FlagSet, options map[string]string, set map[string]bool) {
+	for name, v := range options {
+		// options a command doesn't have are simply left out
+		if set[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid value %q for option %s: %v\n", v, name, err)
+			os.Exit(1)
+		}
+		set[name] = true
+	}
+}
+
+// readConfig reads a JSON object of flag names to values. Arrays set a flag
+// several times, which only makes sense for repeatable flags and url.
+func readConfig(fileName string) (map[string][]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var raw map[string]interface{}
+	dec := json.NewDecoder(f)
+	dec.UseNumber() // keep integers intact instead of formatting them as floats
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := map[string][]string{}
+	for name, v := range raw {
+		if list, ok := v.([]interface{}); ok {
+			for _, item := range list {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+			continue
+		}
+		values[name] = []string{fmt.Sprint(v)}
+	}
+	return values, nil
+}
@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// dialOptions connect to a given address while presenting the host names
+// the router behind it expects, e.g. an OpenShift route or a load balancer
+type dialOptions struct {
+	address     *string
+	serverName  *string
+	virtualHost *string
+}
+
+func addDialFlags(fs *flag.FlagSet) *dialOptions {
+	return &dialOptions{
+		address:     fs.String("connectaddr", "", "host:port to connect to instead of the host of the URL, e.g. the IP of a load balancer"),
+		serverName:  fs.String("sni", "", "TLS server name to present with amqps instead of the host of the URL"),
+		virtualHost: fs.String("vhost", "", "AMQP hostname (virtual host) to open the connection to instead of the host of the URL"),
+	}
+}
+
+// config returns cfg with the dial options set
+func (d *dialOptions) config(cfg transport.Config) transport.Config {
+	cfg.DialAddress = *d.address
+	cfg.ServerName = *d.serverName
+	cfg.VirtualHost = *d.virtualHost
+	return cfg
+}
+
+// connectTimings gathers the connection setup timings of the transports
+// that keep them
+func connectTimings(transports []transport.Transport) []transport.ConnectTiming {
+	var timings []transport.ConnectTiming
+	for _, t := range transports {
+		if c, ok := t.(transport.ConnectTimer); ok {
+			timings = append(timings, c.ConnectTimings()...)
+		}
+	}
+	return timings
+}
+
+// connectPhases are the phases of a connection setup by name
+var connectPhases = []struct {
+	name string
+	of   func(transport.ConnectTiming) time.Duration
+}{
+	{"dns", func(c transport.ConnectTiming) time.Duration { return c.DNS }},
+	{"tcp", func(c transport.ConnectTiming) time.Duration { return c.TCP }},
+	{"tls", func(c transport.ConnectTiming) time.Duration { return c.TLS }},
+	{"open", func(c transport.ConnectTiming) time.Duration { return c.Open }},
+}
+
+// connectQuantiles returns the p50 and max of every phase of the setups,
+// leaving TLS out if none used it
+func connectQuantiles(timings []transport.ConnectTiming) map[string][2]time.Duration {
+	quantiles := map[string][2]time.Duration{}
+	for _, phase := range connectPhases {
+		d := make([]time.Duration, len(timings))
+		for i, c := range timings {
+			d[i] = phase.of(c)
+		}
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		if phase.name == "tls" && d[len(d)-1] == 0 {
+			continue
+		}
+		quantiles[phase.name] = [2]time.Duration{d[len(d)/2], d[len(d)-1]}
+	}
+	return quantiles
+}
+
+// describeConnects returns the p50 and max of the phases of the setups
+func describeConnects(timings []transport.ConnectTiming) string {
+	quantiles := connectQuantiles(timings)
+	parts := []string{fmt.Sprintf("%d connects", len(timings))}
+	for _, phase := range connectPhases {
+		if q, ok := quantiles[phase.name]; ok {
+			parts = append(parts, fmt.Sprintf("%s p50 %v, max %v", phase.name, q[0], q[1]))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// summarizeConnects returns the quantiles of the phases in seconds
+func summarizeConnects(timings []transport.ConnectTiming) map[string]interface{} {
+	phases := map[string]interface{}{}
+	for name, q := range connectQuantiles(timings) {
+		phases[name] = map[string]interface{}{"p50": q[0].Seconds(), "max": q[1].Seconds()}
+	}
+	return phases
+}
@@ -0,0 +1,95 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cpuLimits holds the options constraining how much client CPU a run may
+// use, so results from different lab machines can be compared
+type cpuLimits struct {
+	maxProcs *int
+	cpus     *string
+}
+
+func addCPUFlags(fs *flag.FlagSet) *cpuLimits {
+	return &cpuLimits{
+		maxProcs: fs.Int("gomaxprocs", 0, "Set GOMAXPROCS (0 leaves the Go default, or the number of -cpus when pinned)"),
+		cpus:     fs.String("cpus", "", "Pin the process to a CPU set, e.g. 0-3,6 (linux only)"),
+	}
+}
+
+// apply pins the process and sets GOMAXPROCS as requested
+func (c *cpuLimits) apply() {
+	procs := *c.maxProcs
+	if *c.cpus != "" {
+		cpus, err := parseCPUList(*c.cpus)
+		if err != nil {
+			log.Fatal("Parsing -cpus:", err)
+		}
+		if err := setAffinity(cpus); err != nil {
+			log.Fatal("Setting CPU affinity:", err)
+		}
+		if procs == 0 {
+			procs = len(cpus)
+		}
+	}
+	if procs > 0 {
+		runtime.GOMAXPROCS(procs)
+	}
+}
+
+// parseCPUList parses a comma separated list of CPUs and CPU ranges in the
+// format used by taskset and /sys/devices/system/cpu
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		bounds := strings.SplitN(field, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU %q", field)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid CPU range %q", field)
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("invalid CPU range %q", field)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	return cpus, nil
+}
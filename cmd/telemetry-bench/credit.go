@@ -0,0 +1,87 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// creditStalls tells the broker flow controlling the run apart from the
+// generation holding it back. A settled send only blocks while the link has
+// no credit, so the ones taking longer than a threshold count as blocked
+// waiting for it. Intervals that blocked the send threads more than half
+// the time are starved.
+type creditStalls struct {
+	threshold *int
+	// blocked is the total nanoseconds of the blocked sends, first in the
+	// struct to keep the counters 64 bit aligned for atomic
+	blocked int64
+	longest int64
+	stalls  int64
+	// the intervals reported so far, only touched by the report
+	prev               int64
+	intervals, starved int64
+}
+
+func addCreditFlags(fs *flag.FlagSet) *creditStalls {
+	return &creditStalls{
+		threshold: fs.Int("stallthreshold", 1000, "Microseconds after which a settled send counts as blocked waiting for link credit"),
+	}
+}
+
+// observe accounts for a settled send that took d
+func (c *creditStalls) observe(d time.Duration) {
+	if d < time.Duration(*c.threshold)*time.Microsecond {
+		return
+	}
+	atomic.AddInt64(&c.blocked, int64(d))
+	atomic.AddInt64(&c.stalls, 1)
+	for {
+		longest := atomic.LoadInt64(&c.longest)
+		if int64(d) <= longest || atomic.CompareAndSwapInt64(&c.longest, longest, int64(d)) {
+			break
+		}
+	}
+}
+
+// report returns the share of the time the threads were blocked since the
+// last report, elapsed ago
+func (c *creditStalls) report(elapsed time.Duration, threads int) string {
+	blocked := atomic.LoadInt64(&c.blocked)
+	share := float64(blocked-c.prev) / float64(elapsed) / float64(threads)
+	c.prev = blocked
+	c.intervals++
+	if share > 0.5 {
+		c.starved++
+	}
+	return fmt.Sprintf("credit blocked %.0f%%", 100*share)
+}
+
+func (c *creditStalls) String() string {
+	stalls := atomic.LoadInt64(&c.stalls)
+	if stalls == 0 {
+		return "no blocked sends"
+	}
+	return fmt.Sprintf("blocked %v in %d sends, longest %v, starved %d of %d intervals",
+		time.Duration(atomic.LoadInt64(&c.blocked)), stalls, time.Duration(atomic.LoadInt64(&c.longest)), c.starved, c.intervals)
+}
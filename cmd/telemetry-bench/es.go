@@ -0,0 +1,101 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// esCheck counts the events of a run indexed in ElasticSearch, to measure
+// the loss and lag of the event pipeline behind the router
+type esCheck struct {
+	url   string
+	index string
+	wait  time.Duration
+}
+
+// esCount returns the number of documents in the index with a startsAt
+// timestamp at or after since
+func (c *esCheck) esCount(ctx context.Context, since time.Time) (int64, error) {
+	query := fmt.Sprintf(`{"query": {"range": {"startsAt": {"gte": %q}}}}`, since.UTC().Format(time.RFC3339Nano))
+	req, err := http.NewRequest("POST", strings.TrimRight(c.url, "/")+"/"+c.index+"/_count", bytes.NewBufferString(query))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ElasticSearch returned %s", resp.Status)
+	}
+
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// run polls the event count until all expected events are indexed or the
+// wait expires, and reports the loss and the lag from the end of the run
+func (c *esCheck) run(ctx context.Context, since time.Time, expected int64) {
+	fmt.Printf("Waiting up to %v for %d events in ElasticSearch %s/%s\n", c.wait, expected, c.url, c.index)
+	finished := time.Now()
+	deadline := time.After(c.wait)
+
+	var count int64
+	var lastErr error
+	for {
+		n, err := c.esCount(ctx, since)
+		if err != nil {
+			lastErr = err
+		} else {
+			count, lastErr = n, nil
+		}
+		if count >= expected {
+			fmt.Printf("ElasticSearch: all %d events indexed, indexing lag %v\n", count, time.Now().Sub(finished))
+			return
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-deadline:
+			if lastErr != nil {
+				fmt.Printf("ElasticSearch: querying event count: %v\n", lastErr)
+			}
+			loss := float64(expected-count) / float64(expected) * 100
+			fmt.Printf("ElasticSearch: %d of %d events indexed after %v, missing %d (%.3f%% loss)\n", count, expected, c.wait, expected-count, loss)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+)
+
+// flapping makes a share of the hosts alternate between sending and silent
+// periods, each host at its own phase, so downstream up/down alerting and
+// series staleness get exercised at scale
+type flapping struct {
+	percent *float64
+	period  *int
+	duty    *float64
+
+	// phases holds the offset into the flap cycle of every flapping host.
+	// It's only written before the run starts.
+	phases  map[*generator.Host]time.Duration
+	start   time.Time
+	skipped int64
+}
+
+func addFlapFlags(fs *flag.FlagSet) *flapping {
+	return &flapping{
+		percent: fs.Float64("flap", 0, "Percentage of the hosts that alternate between sending and silent periods"),
+		period:  fs.Int("flapperiod", 60, "Seconds of one send and silent cycle of a flapping host"),
+		duty:    fs.Float64("flapduty", 50, "Percentage of the -flapperiod a flapping host sends for"),
+	}
+}
+
+// check fails on percentages outside 0 to 100
+func (f *flapping) check() {
+	if *f.percent < 0 || *f.percent > 100 {
+		log.Fatalf("-flap %v isn't between 0 and 100", *f.percent)
+	}
+	if *f.duty < 0 || *f.duty > 100 {
+		log.Fatalf("-flapduty %v isn't between 0 and 100", *f.duty)
+	}
+}
+
+// pick chooses the flapping hosts and their phases and starts their cycles
+// at start
+func (f *flapping) pick(hosts []generator.Host, start time.Time) {
+	f.start = start
+	n := int(*f.percent * float64(len(hosts)) / 100)
+	if n <= 0 || *f.period <= 0 {
+		return
+	}
+	period := time.Duration(*f.period) * time.Second
+	f.phases = make(map[*generator.Host]time.Duration, n)
+	for _, i := range rand.Perm(len(hosts))[:n] {
+		f.phases[&hosts[i]] = time.Duration(rand.Int63n(int64(period)))
+	}
+}
+
+// silent reports whether h is in a silent period at now, counting the
+// skipped host intervals
+func (f *flapping) silent(h *generator.Host, now time.Time) bool {
+	phase, ok := f.phases[h]
+	if !ok {
+		return false
+	}
+	period := time.Duration(*f.period) * time.Second
+	at := (now.Sub(f.start) + phase) % period
+	if float64(at) < float64(period)**f.duty/100 {
+		return false
+	}
+	atomic.AddInt64(&f.skipped, 1)
+	return true
+}
+
+func (f *flapping) report() string {
+	return fmt.Sprintf("%d hosts flapping, %d host intervals silent", len(f.phases), atomic.LoadInt64(&f.skipped))
+}
@@ -0,0 +1,48 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+)
+
+// loadGenPlugins opens the comma separated Go plugins of list. Each plugin
+// registers its value generators with generator.RegisterValue and its
+// message types with generator.RegisterMessageType from its init, so they
+// can be picked with -valuegen and -messagetype like the built-in ones.
+func loadGenPlugins(list string) {
+	if list == "" {
+		return
+	}
+	for _, path := range strings.Split(list, ",") {
+		if err := openPlugin(strings.TrimSpace(path)); err != nil {
+			log.Fatal("Loading generator plugin:", err)
+		}
+	}
+	for name, r := range generator.MessageTypes() {
+		if _, dup := renderers[name]; dup {
+			log.Fatalf("Loading generator plugin: message type %s is built in", name)
+		}
+		renderers[name] = r
+	}
+}
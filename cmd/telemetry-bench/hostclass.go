@@ -0,0 +1,257 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+)
+
+// topology holds the options the simulated hosts are built from, which every
+// -hostclasses class can set its own values of
+type topology struct {
+	hosts          int
+	hostPrefix     string
+	naming         generator.Naming
+	dictionary     string
+	plugins        int
+	types          int
+	typeInstances  int
+	instances      int
+	uptime         bool
+	valueGenerator string
+	valueRanges    string
+	// variance is the percentage of hosts with an instance more or fewer
+	// of each plugin
+	variance float64
+}
+
+func addTopologyFlags(fs *flag.FlagSet) *topology {
+	t := &topology{hosts: 1, naming: generator.DefaultNaming, plugins: 1, types: 1, typeInstances: 1, instances: 1, valueGenerator: "random"}
+	t.register(fs)
+	return t
+}
+
+// register defines the topology options on fs, with the current values as
+// the defaults
+func (t *topology) register(fs *flag.FlagSet) {
+	fs.IntVar(&t.hosts, "hosts", t.hosts, "Number of hosts to simulate")
+	fs.StringVar(&t.hostPrefix, "hostprefix", t.hostPrefix, "Host prefix added to the generated hostname000")
+	fs.IntVar(&t.plugins, "plugins", t.plugins, "Plugins per per host")
+	fs.IntVar(&t.types, "types", t.types, "Number of types per plugins")
+	fs.IntVar(&t.instances, "instances", t.instances, "Plugins instances per plugin")
+	fs.IntVar(&t.typeInstances, "typeinstances", t.typeInstances, "Plugins type instances per plugin")
+	fs.Float64Var(&t.variance, "variance", t.variance, "Percentage of the hosts with one plugin instance more or fewer of each plugin, e.g. an extra NIC or disk")
+	fs.StringVar(&t.naming.Host, "hostname", t.naming.Host, "Template of the host names after -hostprefix, given the host number")
+	fs.StringVar(&t.naming.Plugin, "pluginname", t.naming.Plugin, "Template of the plugin names, given the plugin number")
+	fs.StringVar(&t.naming.Type, "typename", t.naming.Type, "Template of the type names, given the type number")
+	fs.StringVar(&t.naming.TypeInstance, "typeinstancename", t.naming.TypeInstance, "Template of the type instance names, given the type instance number")
+	fs.StringVar(&t.naming.PluginInstance, "plugininstancename", t.naming.PluginInstance, "Template of the plugin instance names, given the plugin instance number")
+	fs.StringVar(&t.dictionary, "dictionary", t.dictionary, fmt.Sprintf("Pick the plugin, type and instance names from a built-in dictionary %v or a JSON file", generator.Dictionaries()))
+	fs.BoolVar(&t.uptime, "uptimeenable", t.uptime, "Generate simulated uptime plugin data for each host")
+	fs.StringVar(&t.valueGenerator, "valuegen", t.valueGenerator, fmt.Sprintf("Value generator for plugin data sources %v, or expr: and an expression of t and phase", generator.Values()))
+	fs.StringVar(&t.valueRanges, "valuerange", t.valueRanges, "Bound the plugin values, e.g. cpu=0:100:2,*=20:90:0.5 for min:max:step by plugin name")
+}
+
+// generate builds the hosts of the topology, numbered from offset
+func (t *topology) generate(offset, intervalSec int, format generator.Format) ([]generator.Host, error) {
+	if t.variance < 0 || t.variance > 100 {
+		return nil, fmt.Errorf("-variance %v isn't between 0 and 100", t.variance)
+	}
+	ranges, err := parseValueRanges(t.valueRanges)
+	if err != nil {
+		return nil, fmt.Errorf("parsing -valuerange: %v", err)
+	}
+	naming := t.naming
+	if t.dictionary != "" {
+		naming.Dictionary, err = generator.LoadDictionary(t.dictionary)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return generator.GenerateHosts(generator.Topology{
+		HostPrefix:      t.hostPrefix,
+		Hosts:           t.hosts,
+		HostOffset:      offset,
+		Plugins:         t.plugins,
+		Types:           t.types,
+		TypeInstances:   t.typeInstances,
+		PluginInstances: t.instances,
+		IntervalSec:     intervalSec,
+		Variance:        t.variance,
+		Uptime:          t.uptime,
+		ValueGenerator:  t.valueGenerator,
+		Naming:          naming,
+		Ranges:          ranges,
+		Format:          format,
+	})
+}
+
+// hostClass is a kind of host with a topology of its own, such as the
+// computes, controllers or storage nodes of a cloud
+type hostClass struct {
+	name string
+	topology
+	// series is the number of series of the class's hosts once generated
+	series int
+}
+
+// parseHostClasses parses a -hostclasses value on top of the base topology:
+// either a list of preset=hosts, such as osp=800,ceph=12, or the path of a
+// JSON file of class names to their option values, which may include a
+// preset of their own:
+//
+//	{"controller": {"hosts": 3, "hostname": "overcloud-controller-%d", "plugins": 30},
+//	 "storage": {"preset": "ceph", "hosts": 12}}
+//
+// A class's options go before its preset's, which go before the base's.
+func parseHostClasses(spec string, base topology) ([]hostClass, error) {
+	type classOptions struct {
+		name    string
+		options map[string]string
+	}
+	var specs []classOptions
+	if strings.Contains(spec, "=") {
+		for _, field := range strings.Split(spec, ",") {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("%q is not preset=hosts", field)
+			}
+			specs = append(specs, classOptions{kv[0], map[string]string{"preset": kv[0], "hosts": kv[1]}})
+		}
+	} else {
+		data, err := ioutil.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		var raw map[string]map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber() // keep 1000000 hosts from becoming 1e+06
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", spec, err)
+		}
+		for name, values := range raw {
+			options := map[string]string{}
+			for k, v := range values {
+				options[k] = fmt.Sprint(v)
+			}
+			specs = append(specs, classOptions{name, options})
+		}
+		sort.Slice(specs, func(i, j int) bool { return specs[i].name < specs[j].name })
+	}
+
+	classes := make([]hostClass, 0, len(specs))
+	templates := map[string]string{}
+	for _, s := range specs {
+		c := hostClass{name: s.name, topology: base}
+		options := s.options
+		if name, ok := options["preset"]; ok {
+			p, ok := presets[name]
+			if !ok {
+				return nil, fmt.Errorf("class %s: unknown preset %q, options: %s", s.name, name, strings.Join(presetNames(), ", "))
+			}
+			options = map[string]string{}
+			for k, v := range p.options {
+				options[k] = v
+			}
+			for k, v := range s.options {
+				if k != "preset" {
+					options[k] = v
+				}
+			}
+		}
+		if err := c.set(options); err != nil {
+			return nil, fmt.Errorf("class %s: %v", s.name, err)
+		}
+		// every class is numbered from the -host-offset
+		template := c.hostPrefix + c.naming.Host
+		if other, ok := templates[template]; ok {
+			return nil, fmt.Errorf("classes %s and %s both name their hosts %s, set a hostname of their own", other, s.name, template)
+		}
+		templates[template] = s.name
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// set applies option values to the class's topology
+func (c *hostClass) set(options map[string]string) error {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	c.register(fs)
+	for name, v := range options {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s is not a host class option", name)
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("invalid value %q for option %s: %v", v, name, err)
+		}
+	}
+	if _, err := strconv.Atoi(options["hosts"]); err != nil {
+		return fmt.Errorf("needs a number of hosts")
+	}
+	return nil
+}
+
+// describeClasses returns the hosts and series of every class, for the
+// topology report
+func describeClasses(classes []hostClass) string {
+	parts := make([]string, len(classes))
+	for i, c := range classes {
+		parts[i] = fmt.Sprintf("%s %d hosts %d series", c.name, c.hosts, c.series)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// generateClasses builds the hosts of every class, the classes interleaved
+// in proportion to their size so that the generator shards mix them alike
+func generateClasses(classes []hostClass, offset, intervalSec int, format generator.Format) ([]generator.Host, error) {
+	type keyed struct {
+		key  float64
+		host generator.Host
+	}
+	var all []keyed
+	for i := range classes {
+		c := &classes[i]
+		hosts, err := c.generate(offset, intervalSec, format)
+		if err != nil {
+			return nil, fmt.Errorf("class %s: %v", c.name, err)
+		}
+		for k := range hosts {
+			all = append(all, keyed{(float64(k) + 0.5) / float64(len(hosts)), hosts[k]})
+			for j := range hosts[k].Plugins {
+				c.series += hosts[k].Plugins[j].Series()
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].key < all[j].key })
+	hosts := make([]generator.Host, len(all))
+	for i := range all {
+		hosts[i] = all[i].host
+	}
+	return hosts, nil
+}
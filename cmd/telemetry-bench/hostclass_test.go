@@ -0,0 +1,117 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// baseTopology returns the topology of the send flags, with 5 plugins
+func baseTopology(t *testing.T) topology {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	base := addTopologyFlags(fs)
+	if err := fs.Parse([]string{"-plugins", "5", "-types", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	return *base
+}
+
+// writeClasses writes a -hostclasses JSON file
+func writeClasses(t *testing.T, body string) string {
+	dir, err := ioutil.TempDir("", "hostclass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "classes.json")
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestHostClassPresets checks the preset=hosts form takes the names of the
+// presets and the rest of the base topology
+func TestHostClassPresets(t *testing.T) {
+	classes, err := parseHostClasses("osp=800,ceph=12", baseTopology(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 2 {
+		t.Fatalf("%d classes", len(classes))
+	}
+	osp, ceph := classes[0], classes[1]
+	if osp.name != "osp" || osp.hosts != 800 || osp.naming.Host != "overcloud-compute-%d.localdomain" || osp.plugins != 5 {
+		t.Errorf("osp class %+v", osp)
+	}
+	if ceph.name != "ceph" || ceph.hosts != 12 || ceph.instances != 12 || ceph.types != 3 || ceph.plugins != 5 {
+		t.Errorf("ceph class %+v", ceph)
+	}
+}
+
+// TestHostClassJSON checks the options of a class go before its preset's,
+// which go before the base's, and numbers keep their digits
+func TestHostClassJSON(t *testing.T) {
+	path := writeClasses(t, `{
+		"compute": {"hosts": 1000000, "hostname": "compute-%d", "variance": 2.5},
+		"storage": {"preset": "ceph", "hosts": 12, "instances": 4, "uptimeenable": true}
+	}`)
+	classes, err := parseHostClasses(path, baseTopology(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 2 {
+		t.Fatalf("%d classes", len(classes))
+	}
+	compute, storage := classes[0], classes[1]
+	if compute.name != "compute" || compute.hosts != 1000000 || compute.naming.Host != "compute-%d" || compute.variance != 2.5 || compute.types != 2 {
+		t.Errorf("compute class %+v", compute)
+	}
+	if storage.name != "storage" || storage.hosts != 12 || storage.instances != 4 || storage.types != 3 || !storage.uptime ||
+		storage.naming.Host != "overcloud-cephstorage-%d.localdomain" {
+		t.Errorf("storage class %+v", storage)
+	}
+}
+
+// TestHostClassErrors checks classes that would name their hosts alike,
+// unknown options and presets and missing host counts are rejected
+func TestHostClassErrors(t *testing.T) {
+	for _, tc := range []struct {
+		spec, want string
+	}{
+		{"osp=1,osp=2", "both name their hosts"},
+		{writeClasses(t, `{"a": {"hosts": 1}, "b": {"hosts": 2}}`), "both name their hosts"},
+		{writeClasses(t, `{"a": {"hosts": 1, "plugin": 3}}`), "plugin is not a host class option"},
+		{writeClasses(t, `{"a": {"plugins": 3}}`), "needs a number of hosts"},
+		{"nosuch=3", `unknown preset "nosuch"`},
+		{"osp", "no such file"},
+		{"osp=", `invalid value "" for option hosts`},
+	} {
+		_, err := parseHostClasses(tc.spec, baseTopology(t))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.spec, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runLabels are the -label key=value pairs describing a run, such as the
+// build number or the broker version, stored with its results to compare
+// runs over time. The option may be given several times.
+type runLabels map[string]string
+
+func (l runLabels) String() string {
+	keys := l.keys()
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + l[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set adds the key=value pairs of a comma separated list
+func (l runLabels) Set(list string) error {
+	for _, pair := range strings.Split(list, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || !validLabelName(kv[0]) {
+			return fmt.Errorf("expecting key=value with a key of letters, digits and underscores, not %q", pair)
+		}
+		l[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// keys returns the label names in order
+func (l runLabels) keys() []string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// prometheus returns the labels as Prometheus label pairs followed by a
+// comma, empty without labels
+func (l runLabels) prometheus() string {
+	var b strings.Builder
+	for _, k := range l.keys() {
+		b.WriteString(k + "=" + strconv.Quote(l[k]) + ",")
+	}
+	return b.String()
+}
+
+// validLabelName reports whether name is a valid Prometheus label name
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+// sentProperty is the application property marking in-band latency
+// samples, holding the send time in nanoseconds since the epoch
+const sentProperty = "telemetry_bench_sent"
+
+// latencies collects latency samples between reports
+type latencies struct {
+	sync.Mutex
+	samples []time.Duration
+	total   int64
+	lost    int64
+	// last holds the p50, p99 and max of the last report with samples
+	last     [3]time.Duration
+	reported bool
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.Lock()
+	l.samples = append(l.samples, d)
+	l.total++
+	l.Unlock()
+}
+
+// count returns the number of samples so far
+func (l *latencies) count() int64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.total
+}
+
+// report returns the percentiles of the samples since the last report and
+// starts a new period
+func (l *latencies) report() string {
+	l.Lock()
+	samples := l.samples
+	l.samples = nil
+	lost := l.lost
+	l.Unlock()
+
+	if len(samples) == 0 {
+		return fmt.Sprintf("no samples, %d lost", lost)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	at := func(q float64) time.Duration {
+		return samples[int(q*float64(len(samples)-1))]
+	}
+	last := [3]time.Duration{at(0.5), at(0.99), samples[len(samples)-1]}
+	l.Lock()
+	l.last = last
+	l.reported = true
+	l.Unlock()
+	return fmt.Sprintf("p50 %v, p99 %v, max %v, %d lost", last[0], last[1], last[2], lost)
+}
+
+// quantiles returns the p50, p99 and max of the last report, false before
+// any report had samples
+func (l *latencies) quantiles() ([3]time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+	return l.last, l.reported
+}
+
+// latencyOf returns the one way latency of msg if it is a marked sample
+func latencyOf(msg *amqp.Message, now time.Time) (time.Duration, bool) {
+	sent, ok := msg.ApplicationProperties[sentProperty].(int64)
+	if !ok {
+		return 0, false
+	}
+	return now.Sub(time.Unix(0, sent)), true
+}
+
+// deliveryLatencyOf returns the time from the AMQP creation-time of msg to
+// now, which only makes sense with the clocks of both ends in sync
+func deliveryLatencyOf(msg *amqp.Message, now time.Time) (time.Duration, bool) {
+	if msg.Properties == nil || msg.Properties.CreationTime.IsZero() {
+		return 0, false
+	}
+	return now.Sub(msg.Properties.CreationTime), true
+}
+
+// loopback consumes the address of rawurl next to the send threads and
+// records the latency of the marked messages. On an anycast address it
+// competes with the real consumers for the messages, so it only sees a
+// share of the samples.
+func (l *latencies) loopback(ctx context.Context, rawurl string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		log.Fatal("Dialing AMQP server for the latency loopback:", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatal("Creating AMQP loopback session:", err)
+	}
+	receiver, err := session.NewReceiver(amqp.LinkSourceAddress(u.Path), amqp.LinkCredit(256))
+	if err != nil {
+		log.Fatal("Creating loopback receiver link:", err)
+	}
+
+	go func() {
+		defer client.Close()
+		for {
+			msg, err := receiver.Receive(ctx)
+			if err != nil {
+				return
+			}
+			if d, ok := latencyOf(msg, time.Now()); ok {
+				l.add(d)
+			}
+			msg.Accept()
+		}
+	}()
+}
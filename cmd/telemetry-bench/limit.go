@@ -0,0 +1,206 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/bench"
+	"github.com/infrawatch/telemetry-bench/generator"
+	"github.com/infrawatch/telemetry-bench/stats"
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+func runLimit(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	duration := fs.Int("duration", 10, "Seconds to send for")
+	requireAck := fs.Bool("ack", false, "Require messages to be ack'd ")
+	constant := fs.Bool("constant", false, "Render one message up front and resend it unchanged, leaving out the generation cost")
+	profile := addProfilingFlags(fs)
+	cpu := addCPUFlags(fs)
+	summary := addSummaryFlags(fs)
+	dial := addDialFlags(fs)
+	ackErrs := addAckErrorFlags(fs)
+
+	cmd.parse(fs, args)
+	urls := cmd.urls(fs, 1)
+
+	cpu.apply()
+	defer profile.start()()
+	getMessagesLimit(urls[0], dial.config(transport.Config{AckBuffer: 100}), time.Duration(*duration)*time.Second, *requireAck, *constant, ackErrs, summary)
+}
+
+// getMessagesLimit sends the same single-series plugin as fast as possible
+// for duration and reports how many messages made it out. It returns once
+// the send and ack routines have finished and the connection is closed.
+// With constant the payload is rendered once and the same message is sent
+// every time, so only the transport and the broker are measured.
+func getMessagesLimit(urls string, cfg transport.Config, duration time.Duration, requireAck, constant bool, ackErrs *ackErrors, summary *jsonSummary) {
+	hosts, err := generator.GenerateHosts(generator.Topology{
+		HostPrefix: "test", Hosts: 1, Plugins: 1, Types: 1, TypeInstances: 1, PluginInstances: 1, IntervalSec: 10, Naming: generator.DefaultNaming,
+	})
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	dummyPlugin := &hosts[0].Plugins[0]
+
+	t, err := transport.New(urls, cfg)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	err = t.Connect(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	defer t.Close()
+
+	var waitb sync.WaitGroup
+	var waitAck sync.WaitGroup
+	startTime := time.Now()
+
+	runCtx, stop := context.WithTimeout(ctx, duration)
+	defer stop()
+	ackCtx, stopAck := context.WithCancel(ctx)
+	defer stopAck()
+
+	// routine for sending mesg
+	waitb.Add(1)
+	st := stats.New(1)
+	status.track(st)
+	status.setPhase(phaseRunning)
+	go func() {
+		defer waitb.Done()
+		if constant {
+			sendConstant(ctx, runCtx, t, dummyPlugin, !requireAck, st)
+			return
+		}
+		for {
+			done := false
+			dummyPlugin.EachMetricMessage(func(metric []byte) bool {
+				bench.Send(ctx, t, bench.NewMessage(metric, !requireAck, ""), st, 0)
+
+				select {
+				case <-runCtx.Done():
+					done = true
+				default:
+				}
+				return !done
+			})
+			if done {
+				return
+			}
+		}
+	}()
+
+	// routine for waiting ack....
+	waitAck.Add(1)
+	go func() {
+		defer waitAck.Done()
+		if !constant {
+			bench.AckLoop(ackCtx, t.Acks(), st, func(out transport.Outcome) {
+				if out.Error != nil {
+					ackErrs.observe(out)
+				}
+			})
+			return
+		}
+		// the constant message is shared by all the sends, never released
+		for {
+			select {
+			case out := <-t.Acks():
+				if out.Error != nil {
+					ackErrs.observe(out)
+				} else {
+					st.Acked()
+				}
+			case <-ackCtx.Done():
+				return
+			}
+		}
+	}()
+	fmt.Printf("sending AMQP in %v...", duration)
+	<-runCtx.Done()
+	status.setPhase(phaseDraining)
+	waitb.Wait()
+
+	fmt.Printf("Done!\n")
+	finishedTime := time.Now()
+	elapsed := finishedTime.Sub(startTime)
+	sent := st.Snapshot().Sent
+
+	// Drain the outstanding acks before tearing the connection down
+	if requireAck && ctx.Err() == nil && !bench.WaitAcks(st, ackErrs.count, sent, 10*time.Second) {
+		log.Printf("Timed out waiting for acknowledgements")
+	}
+	stopAck()
+	waitAck.Wait()
+	status.setPhase(phaseDone)
+
+	snap := st.Snapshot()
+	fmt.Printf("Total: %d sent, %d failed, %d ack'd (duration:%v, mesg/sec: %v)\n", sent, snap.Failed, snap.Acked, elapsed, float64(sent)/elapsed.Seconds())
+	if requireAck {
+		fmt.Printf("Ack errors: %s\n", ackErrs.report())
+		summary.set("ack_errors", ackErrs.count())
+		summary.set("ack_error_kinds", ackErrs.kinds())
+	}
+
+	summary.counters(snap, snap.Sent)
+	summary.set("ack", requireAck)
+	if sizes := st.Sizes(); sizes.Count > 0 {
+		summary.set("bytes", sizes.Bytes)
+		summary.set("message_size", map[string]interface{}{
+			"min": sizes.Min, "mean": sizes.Mean(), "p50": sizes.P50, "p90": sizes.P90, "p99": sizes.P99, "max": sizes.Max,
+		})
+	}
+	// the rate of the sending alone, as in the text output
+	summary.set("elapsed_seconds", elapsed.Seconds())
+	summary.set("rate", float64(sent)/elapsed.Seconds())
+	summary.set("constant", constant)
+	summary.print("limit", ctx.Err() != nil)
+}
+
+// sendConstant sends one rendering of plugin until runCtx is done, without
+// generating, copying or pooling anything per message. The sends use ctx,
+// so the one in flight when the duration is up still completes. They are
+// counted as bench.Send does, but the message is never released.
+func sendConstant(ctx, runCtx context.Context, t transport.Transport, plugin *generator.Plugin, settled bool, st *stats.Stats) {
+	msg := &transport.Message{Settled: settled}
+	plugin.EachMetricMessage(func(metric []byte) bool {
+		msg.Body = append([]byte(nil), metric...)
+		return false
+	})
+	for runCtx.Err() == nil {
+		if err := t.Send(ctx, msg); err != nil {
+			st.Failed()
+		} else {
+			st.Sent(0)
+			st.Size(0, len(msg.Body))
+		}
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logOptions are the options of every command writing its output to a
+// rotated file rather than the terminal, for soak runs of weeks
+type logOptions struct {
+	path     *string
+	maxSize  *int
+	maxAge   *int
+	keep     *int
+	compress *bool
+}
+
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	return &logOptions{
+		path:     fs.String("logfile", "", "Write the output and the log to this file instead of stdout and stderr, rotating it"),
+		maxSize:  fs.Int("logmaxsize", 100, "Rotate the -logfile once it reaches this many megabytes (0 for no limit)"),
+		maxAge:   fs.Int("logmaxage", 0, "Rotate the -logfile after this many hours (0 for no limit)"),
+		keep:     fs.Int("logkeep", 10, "Number of rotated -logfile files kept, the oldest are removed (0 to keep all)"),
+		compress: fs.Bool("logcompress", false, "Gzip the rotated -logfile files"),
+	}
+}
+
+// output is the rotated log file the output goes to, nil without -logfile
+var output *rotatingFile
+
+// start sends the output of the process and the log to the -logfile. The
+// output reaches the file through a pipe, closeOutput flushes it.
+func (o *logOptions) start() {
+	if *o.path == "" {
+		return
+	}
+	f := &rotatingFile{
+		path:     *o.path,
+		maxSize:  int64(*o.maxSize) << 20,
+		maxAge:   time.Duration(*o.maxAge) * time.Hour,
+		keep:     *o.keep,
+		compress: *o.compress,
+	}
+	if err := f.open(); err != nil {
+		fmt.Fprintf(os.Stderr, "Opening log file %s: %v\n", f.path, err)
+		os.Exit(1)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Redirecting the output to %s: %v\n", f.path, err)
+		os.Exit(1)
+	}
+	f.pipe = w
+	f.copied = make(chan struct{})
+	go func() {
+		defer close(f.copied)
+		// whole lines, so no line is split by a rotation
+		lines := bufio.NewReader(r)
+		for {
+			line, err := lines.ReadBytes('\n')
+			if len(line) > 0 {
+				f.Write(line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	os.Stdout, os.Stderr = w, w
+	log.SetOutput(f)
+	output = f
+}
+
+// closeOutput flushes the output to the -logfile, if any, and closes it
+func closeOutput() {
+	if output == nil {
+		return
+	}
+	output.pipe.Close()
+	<-output.copied
+	output.close()
+}
+
+// exit exits with code once the output is flushed
+func exit(code int) {
+	closeOutput()
+	os.Exit(code)
+}
+
+// rotatingFile is a file renamed with the time of its rotation once it
+// grows too big or too old, and replaced with a fresh one
+type rotatingFile struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	keep     int
+	compress bool
+
+	f      *os.File
+	size   int64
+	opened time.Time
+	// pipe is the write end of the output, copied until it's closed
+	pipe   *os.File
+	copied chan struct{}
+	// compressing counts the rotated files being compressed
+	compressing sync.WaitGroup
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize || r.maxAge > 0 && time.Since(r.opened) >= r.maxAge) {
+		if err := r.rotate(); err != nil {
+			// keep writing to the current file rather than lose output
+			fmt.Fprintf(r.f, "Rotating log file: %v\n", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and opens a fresh one
+func (r *rotatingFile) rotate() error {
+	rotated := r.path + "." + time.Now().UTC().Format("20060102T150405.000Z")
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	r.f.Close()
+	if err := r.open(); err != nil {
+		return err
+	}
+	if r.compress {
+		r.compressing.Add(1)
+		go func() {
+			defer r.compressing.Done()
+			if err := gzipFile(rotated); err != nil {
+				log.Printf("Compressing %s: %v", rotated, err)
+			}
+			r.prune()
+		}()
+		return nil
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond keep
+func (r *rotatingFile) prune() {
+	if r.keep <= 0 {
+		return
+	}
+	dir, base := filepath.Split(r.path)
+	if dir == "" {
+		dir = "."
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, f := range files {
+		// the files being compressed are counted once
+		if strings.HasPrefix(f.Name(), base+".") && !strings.HasSuffix(f.Name(), ".tmp") {
+			name := strings.TrimSuffix(f.Name(), ".gz")
+			if len(rotated) > 0 && rotated[len(rotated)-1] == name {
+				continue
+			}
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > r.keep {
+		os.Remove(filepath.Join(dir, rotated[0]))
+		os.Remove(filepath.Join(dir, rotated[0]+".gz"))
+		rotated = rotated[1:]
+	}
+}
+
+// gzipFile replaces name with name.gz
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(name + ".gz.tmp")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(name+".gz.tmp", name+".gz")
+	}
+	if err != nil {
+		os.Remove(name + ".gz.tmp")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// close closes the file once the rotated files are compressed
+func (r *rotatingFile) close() {
+	r.compressing.Wait()
+	r.Lock()
+	defer r.Unlock()
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "net/http/pprof"
+)
+
+// command is a telemetry-bench subcommand with its own flag set
+type command struct {
+	name     string
+	synopsis string
+	args     string
+	run      func(cmd *command, args []string)
+
+	// configURLs are used when no URL is given on the command line
+	configURLs []string
+	// preset is the -preset in effect, if any
+	preset *preset
+}
+
+var commands = []*command{
+	{name: "send", synopsis: "simulate collectd and send metrics or events", args: "amqp://... [amqp://...]", run: runSend},
+	{name: "receive", synopsis: "consume messages and report the receive rate", args: "amqp://...", run: runReceive},
+	{name: "limit", synopsis: "send as fast as possible to find the maximum message rate", args: "amqp://...", run: runLimit},
+	{name: "replay", synopsis: "send payloads read from a file, one per line", args: "amqp://...", run: runReplay},
+	{name: "verify", synopsis: "receive a run and check it against its startup metric", args: "amqp://...", run: runVerify},
+	{name: "validate", synopsis: "send a small tagged batch and confirm it arrived, hop by hop", args: "amqp://...", run: runValidate},
+	{name: "compare", synopsis: "diff the JSON results of two runs", args: "a.json b.json", run: runCompare},
+	{name: "sweep", synopsis: "send a short trial for every combination of a grid of send options", args: "-- (send options) amqp://...", run: runSweep},
+	{name: "version", synopsis: "print version information", run: runVersion},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> (options) amqp://...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "    %-10s%s\n", cmd.name, cmd.synopsis)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Without a command, send is assumed.\n")
+	fmt.Fprintf(os.Stderr, "\nOptions not given on the command line are read from %s<OPTION> environment\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "variables, then from the -config file. %s%s sets the URLs.\n", envPrefix, strings.ToUpper(urlOption))
+}
+
+func main() {
+	args := os.Args[1:]
+
+	// Plain flags or a URL without a command keep working as before the
+	// subcommands were introduced
+	name := "send"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") && !strings.Contains(args[0], "://") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(cmd, args)
+			closeOutput()
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+	usage()
+	os.Exit(1)
+}
+
+// flagSet returns a new flag set for the command, with a usage message
+// listing only its own options
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s (options) %s\n", os.Args[0], c.name, c.args)
+		fmt.Fprintf(os.Stderr, "options:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// urls returns the positional URL arguments of a parsed flag set, or the ones
+// from the config file or environment, exiting with the usage message when
+// there are none or more than max (0 for no limit). URLs without an address
+// get the -preset's address for the command's -messagetype.
+func (c *command) urls(fs *flag.FlagSet, max int) []string {
+	urls := fs.Args()
+	if len(urls) == 0 {
+		urls = c.configURLs
+	}
+	if len(urls) == 0 {
+		fmt.Fprintln(os.Stderr, "amqp URL is missing")
+		fs.Usage()
+		os.Exit(1)
+	} else if max > 0 && len(urls) > max {
+		fmt.Fprintf(os.Stderr, "Only %d amqp URL(s) supported by %s\n", max, c.name)
+		fs.Usage()
+		os.Exit(1)
+	}
+	if c.preset != nil {
+		kind := "metrics"
+		if f := fs.Lookup("messagetype"); f != nil {
+			kind = f.Value.String()
+		}
+		withAddress := make([]string, len(urls))
+		for i, u := range urls {
+			withAddress[i] = c.preset.address(u, kind)
+		}
+		urls = withAddress
+	}
+	return urls
+}
+
+// profiling holds the pprof options shared by the load generating commands
+type profiling struct {
+	enable   *bool
+	fileName *string
+	addr     *string
+}
+
+func addProfilingFlags(fs *flag.FlagSet) *profiling {
+	return &profiling{
+		enable:   fs.Bool("profenable", false, "Enable profiling and create and API endpoint"),
+		fileName: fs.String("pprofile", "", "go pprofile output"),
+		addr:     fs.String("httpaddr", "localhost:6060", "Listen address of the -profenable endpoint, which also serves /healthz, /readyz and /status"),
+	}
+}
+
+// start begins writing a CPU profile, or serves the pprof endpoint if
+// requested. The returned function stops the CPU profile.
+func (p *profiling) start() func() {
+	if *p.fileName != "" {
+		f, err := os.Create(*p.fileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pprof.StartCPUProfile(f)
+		return pprof.StopCPUProfile
+	}
+
+	if *p.enable == true {
+		go func() {
+			log.Println(http.ListenAndServe(*p.addr, nil))
+		}()
+	}
+	return func() {}
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM, so
+// every stage of a run shuts down the same way whatever stopped it
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// sleep waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+// management holds the options for polling the router or broker under test
+// through AMQP management, so its view of a run can be reported next to the
+// client side counters
+type management struct {
+	url     *string
+	kind    *string
+	address *string
+
+	sync.Mutex
+	last    brokerSample
+	sampled bool
+}
+
+// brokerSample is one poll of the router or broker. Fields it doesn't
+// provide are -1.
+type brokerSample struct {
+	// depth is the number of messages held for the address
+	depth int64
+	// credit is the credit the consumers of the address have issued
+	credit int64
+	// memory is the router or broker memory use in bytes
+	memory int64
+	err    error
+}
+
+func (s brokerSample) String() string {
+	if s.err != nil {
+		return "broker: " + s.err.Error()
+	}
+	field := func(v int64) string {
+		if v < 0 {
+			return "n/a"
+		}
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprintf("broker: depth %s, credit %s, memory %s", field(s.depth), field(s.credit), field(s.memory))
+}
+
+func addManagementFlags(fs *flag.FlagSet) *management {
+	return &management{
+		url:     fs.String("mgmturl", "", "AMQP URL of the router or broker management to poll during the run"),
+		kind:    fs.String("mgmttype", "qdrouterd", "Management flavour: qdrouterd or artemis"),
+		address: fs.String("mgmtaddress", "", "Address (qdrouterd) or queue (artemis) to report on (default the address of the run)"),
+	}
+}
+
+// mgmtClient is a request/response connection to the management node
+type mgmtClient struct {
+	client   *amqp.Client
+	sender   *amqp.Sender
+	receiver *amqp.Receiver
+}
+
+func dialManagement(rawurl, node string) (*mgmtClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("Dialing AMQP management: %v", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Creating AMQP management session: %v", err)
+	}
+	sender, err := session.NewSender(amqp.LinkTargetAddress(node))
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Creating management sender link: %v", err)
+	}
+	receiver, err := session.NewReceiver(amqp.LinkAddressDynamic(), amqp.LinkCredit(10))
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Creating management reply link: %v", err)
+	}
+	return &mgmtClient{client: client, sender: sender, receiver: receiver}, nil
+}
+
+// request sends a management request and waits for its response
+func (c *mgmtClient) request(ctx context.Context, properties map[string]interface{}, body interface{}) (*amqp.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	msg := &amqp.Message{
+		Properties:            &amqp.MessageProperties{ReplyTo: c.receiver.Address()},
+		ApplicationProperties: properties,
+		Value:                 body,
+	}
+	if err := c.sender.Send(ctx, msg); err != nil {
+		return nil, err
+	}
+	resp, err := c.receiver.Receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp.Accept()
+	if code, ok := resp.ApplicationProperties["statusCode"]; ok && toInt64(code) >= 300 {
+		return nil, fmt.Errorf("management request failed: %v %v", code, resp.ApplicationProperties["statusDescription"])
+	}
+	return resp, nil
+}
+
+// queryQdr runs a qdrouterd management QUERY and returns one attribute map
+// per entity
+func (c *mgmtClient) queryQdr(ctx context.Context, entityType string, attributes ...string) ([]map[string]interface{}, error) {
+	resp, err := c.request(ctx, map[string]interface{}{
+		"operation":  "QUERY",
+		"type":       "org.amqp.management",
+		"entityType": entityType,
+		"name":       "self",
+	}, map[string]interface{}{
+		"attributeNames": attributes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, _ := resp.Value.(map[string]interface{})
+	names, _ := body["attributeNames"].([]interface{})
+	results, _ := body["results"].([]interface{})
+	entities := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		values, _ := r.([]interface{})
+		entity := map[string]interface{}{}
+		for i, name := range names {
+			if i < len(values) {
+				entity[fmt.Sprint(name)] = values[i]
+			}
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// pollQdr samples the links attached to address and the router memory use
+func (c *mgmtClient) pollQdr(ctx context.Context, address string) brokerSample {
+	sample := brokerSample{depth: -1, credit: -1, memory: -1}
+	links, err := c.queryQdr(ctx, "org.apache.qpid.dispatch.router.link",
+		"owningAddr", "linkDir", "undeliveredCount", "unsettledCount", "creditAvailable")
+	if err != nil {
+		sample.err = err
+		return sample
+	}
+	sample.depth, sample.credit = 0, 0
+	for _, link := range links {
+		// owningAddr carries a one or two character class prefix, e.g.
+		// M0collectd/telemetry for a mobile address
+		if !strings.HasSuffix(fmt.Sprint(link["owningAddr"]), address) {
+			continue
+		}
+		sample.depth += toInt64(link["undeliveredCount"]) + toInt64(link["unsettledCount"])
+		if link["linkDir"] == "out" {
+			sample.credit += toInt64(link["creditAvailable"])
+		}
+	}
+
+	routers, err := c.queryQdr(ctx, "org.apache.qpid.dispatch.router", "memoryUsage")
+	if err == nil && len(routers) > 0 && routers[0]["memoryUsage"] != nil {
+		sample.memory = toInt64(routers[0]["memoryUsage"])
+	}
+	return sample
+}
+
+// readArtemis reads an attribute of an Artemis management resource
+func (c *mgmtClient) readArtemis(ctx context.Context, resource, attribute string) (int64, error) {
+	resp, err := c.request(ctx, map[string]interface{}{
+		"_AMQ_ResourceName": resource,
+		"_AMQ_Attribute":    attribute,
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// the result is a JSON array holding the attribute value
+	body, ok := resp.Value.(string)
+	if !ok {
+		body = string(resp.GetData())
+	}
+	var result []json.Number
+	if err := json.Unmarshal([]byte(body), &result); err != nil || len(result) == 0 {
+		return 0, fmt.Errorf("unexpected %s.%s response %q", resource, attribute, body)
+	}
+	return result[0].Int64()
+}
+
+// pollArtemis samples the queue depth and the broker memory use.
+// Artemis doesn't expose consumer credit.
+func (c *mgmtClient) pollArtemis(ctx context.Context, queue string) brokerSample {
+	sample := brokerSample{depth: -1, credit: -1, memory: -1}
+	var err error
+	if sample.depth, err = c.readArtemis(ctx, "queue."+queue, "messageCount"); err != nil {
+		sample.depth = -1
+		sample.err = err
+		return sample
+	}
+	if memory, err := c.readArtemis(ctx, "broker", "addressMemoryUsage"); err == nil {
+		sample.memory = memory
+	}
+	return sample
+}
+
+// start connects to the management node and polls it every interval until
+// ctx is done. It does nothing unless -mgmturl is set. defaultAddress is
+// watched when -mgmtaddress isn't given.
+func (m *management) start(ctx context.Context, defaultAddress string, interval time.Duration) {
+	if *m.url == "" {
+		return
+	}
+	address := *m.address
+	if address == "" {
+		address = defaultAddress
+	}
+	address = strings.Trim(address, "/")
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	var poll func(context.Context, *mgmtClient) brokerSample
+	var node string
+	switch *m.kind {
+	case "qdrouterd":
+		node = "$management"
+		poll = func(ctx context.Context, c *mgmtClient) brokerSample { return c.pollQdr(ctx, address) }
+	case "artemis":
+		node = "activemq.management"
+		poll = func(ctx context.Context, c *mgmtClient) brokerSample { return c.pollArtemis(ctx, address) }
+	default:
+		log.Fatalf("Unknown -mgmttype %q, options: qdrouterd, artemis", *m.kind)
+	}
+
+	c, err := dialManagement(*m.url, node)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		defer c.client.Close()
+		for {
+			sample := poll(ctx, c)
+			if ctx.Err() != nil {
+				return
+			}
+			m.Lock()
+			m.last, m.sampled = sample, true
+			m.Unlock()
+			if !sleep(ctx, interval) {
+				return
+			}
+		}
+	}()
+}
+
+// report returns the latest sample for the interval reports, or "" when
+// management isn't polled
+func (m *management) report() string {
+	m.Lock()
+	defer m.Unlock()
+	if !m.sampled {
+		return ""
+	}
+	return m.last.String()
+}
+
+// toInt64 converts the integer types AMQP values decode to
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int32:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int8:
+		return int64(n)
+	case int:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case uint16:
+		return int64(n)
+	case uint8:
+		return int64(n)
+	case uint:
+		return int64(n)
+	}
+	return 0
+}
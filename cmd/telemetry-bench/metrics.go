@@ -0,0 +1,126 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// receiverMetrics serves the counts, rate and latencies of receive on
+// /metrics in the Prometheus text format, so a receiver pod can be scraped
+// during long runs. The rate and the latencies are those of the last
+// report.
+type receiverMetrics struct {
+	// the counts of all the runs, first in the struct to keep them 64 bit
+	// aligned for atomic
+	received  int64
+	malformed int64
+	// labels are the -label pairs of every metric
+	labels runLabels
+	sync.Mutex
+	rate float64
+	// latencies by kind, in-band or delivery
+	latencies map[string]*latencies
+}
+
+// serve listens on addr in the background
+func (m *receiverMetrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveMetrics)
+	go func() {
+		log.Println(http.ListenAndServe(addr, mux))
+	}()
+}
+
+// receivedOne counts a message, and whether it was malformed
+func (m *receiverMetrics) receivedOne(malformed bool) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.received, 1)
+	if malformed {
+		atomic.AddInt64(&m.malformed, 1)
+	}
+}
+
+// setRate sets the receive rate of the last interval
+func (m *receiverMetrics) setRate(rate float64) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.rate = rate
+	m.Unlock()
+}
+
+// track serves the quantiles of l as the latency of kind
+func (m *receiverMetrics) track(kind string, l *latencies) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	if m.latencies == nil {
+		m.latencies = map[string]*latencies{}
+	}
+	m.latencies[kind] = l
+	m.Unlock()
+}
+
+func (m *receiverMetrics) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	labels := m.labels.prometheus()
+	plain := ""
+	if labels != "" {
+		plain = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP telemetry_bench_received_total Messages received.\n")
+	fmt.Fprintf(w, "# TYPE telemetry_bench_received_total counter\n")
+	fmt.Fprintf(w, "telemetry_bench_received_total%s %d\n", plain, atomic.LoadInt64(&m.received))
+	fmt.Fprintf(w, "# HELP telemetry_bench_malformed_total Messages failing the -validate check.\n")
+	fmt.Fprintf(w, "# TYPE telemetry_bench_malformed_total counter\n")
+	fmt.Fprintf(w, "telemetry_bench_malformed_total%s %d\n", plain, atomic.LoadInt64(&m.malformed))
+	fmt.Fprintf(w, "# HELP telemetry_bench_receive_rate Messages received per second over the last interval.\n")
+	fmt.Fprintf(w, "# TYPE telemetry_bench_receive_rate gauge\n")
+	fmt.Fprintf(w, "telemetry_bench_receive_rate%s %g\n", plain, m.rate)
+	fmt.Fprintf(w, "# HELP telemetry_bench_latency_seconds Message latency over the last interval.\n")
+	fmt.Fprintf(w, "# TYPE telemetry_bench_latency_seconds gauge\n")
+	for _, kind := range []string{"inband", "delivery"} {
+		l, ok := m.latencies[kind]
+		if !ok {
+			continue
+		}
+		q, ok := l.quantiles()
+		if !ok {
+			continue
+		}
+		for i, quantile := range []string{"0.5", "0.99", "1"} {
+			fmt.Fprintf(w, "telemetry_bench_latency_seconds{%skind=%q,quantile=%q} %g\n", labels, kind, quantile, q[i].Seconds())
+		}
+	}
+}
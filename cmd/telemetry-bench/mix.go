@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+)
+
+// renderers render a plugin's payloads with a timestamp for each
+// -messagetype
+var renderers = map[string]func(*generator.Plugin, time.Time, func([]byte) bool){
+	"metrics":    (*generator.Plugin).EachMetricMessageAt,
+	"events":     (*generator.Plugin).EachEventMessageAt,
+	"ceilometer": (*generator.Plugin).EachCeilometerMessageAt,
+}
+
+func messageTypes() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mixEntry is one message type of a -mix run. ratio is the number of
+// messages of the type generated per plugin series and interval, so 1 sends
+// every series and 0.1 one message for every ten series.
+type mixEntry struct {
+	messageType string
+	ratio       float64
+	address     string
+	render      func(*generator.Plugin, time.Time, func([]byte) bool)
+}
+
+// parseMix parses a -mix value such as metrics=1,events=0.01,ceilometer=0.2.
+// An empty mix is the -messagetype on its own. Every type but the
+// -messagetype one, which goes to the URL's address, needs an address from
+// -addresses or the -preset.
+func parseMix(mix, messageType, addresses string, p *preset) ([]mixEntry, error) {
+	if mix == "" {
+		mix = messageType + "=1"
+	}
+
+	addrs, err := parsePairs(addresses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -addresses: %v", err)
+	}
+	ratios, err := parsePairs(mix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -mix: %v", err)
+	}
+
+	var entries []mixEntry
+	for _, kind := range messageTypes() {
+		v, ok := ratios[kind]
+		if !ok {
+			continue
+		}
+		delete(ratios, kind)
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 {
+			return nil, fmt.Errorf("invalid -mix ratio %q for %s", v, kind)
+		}
+
+		e := mixEntry{messageType: kind, ratio: ratio, render: renderers[kind]}
+		if kind != messageType {
+			e.address = addrs[kind]
+			if e.address == "" && p != nil {
+				e.address = p.addresses[kind]
+			}
+			if e.address == "" {
+				return nil, fmt.Errorf("no address for %s messages, set one with -addresses", kind)
+			}
+		}
+		entries = append(entries, e)
+	}
+	for kind := range ratios {
+		return nil, fmt.Errorf("unknown message type %q (options: %s)", kind, strings.Join(messageTypes(), ", "))
+	}
+	return entries, nil
+}
+
+// parsePairs parses a comma separated list of key=value pairs
+func parsePairs(list string) (map[string]string, error) {
+	pairs := map[string]string{}
+	if list == "" {
+		return pairs, nil
+	}
+	for _, field := range strings.Split(list, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not key=value", field)
+		}
+		pairs[kv[0]] = kv[1]
+	}
+	return pairs, nil
+}
+
+// parseValueRanges parses a -valuerange value such as
+// cpu=0:100:2,*=20:90:0.5, giving the min:max:step of the values of each
+// plugin. The step may be left out for generators that don't use it.
+func parseValueRanges(list string) (map[string]generator.ValueRange, error) {
+	pairs, err := parsePairs(list)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make(map[string]generator.ValueRange, len(pairs))
+	for plugin, spec := range pairs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("%s=%s is not min:max[:step]", plugin, spec)
+		}
+		var bounds [3]float64
+		for i, part := range parts {
+			bounds[i], err = strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%s: %v", plugin, spec, err)
+			}
+		}
+		r := generator.ValueRange{Min: bounds[0], Max: bounds[1], Step: bounds[2]}
+		if r.Max < r.Min || r.Step < 0 {
+			return nil, fmt.Errorf("%s=%s: max is below min or step is negative", plugin, spec)
+		}
+		ranges[plugin] = r
+	}
+	return ranges, nil
+}
+
+// parseSeverities parses a -severities value such as
+// OKAY=90,WARNING=8,FAILURE=2 into weights in the order of
+// generator.Severities. Severities left out weigh nothing.
+func parseSeverities(list string) ([]float64, error) {
+	pairs, err := parsePairs(list)
+	if err != nil || len(pairs) == 0 {
+		return nil, err
+	}
+	weights := make([]float64, len(generator.Severities))
+	for name, value := range pairs {
+		i := indexOf(generator.Severities, strings.ToUpper(name))
+		if i < 0 {
+			return nil, fmt.Errorf("unknown severity %s (available: %v)", name, generator.Severities)
+		}
+		weights[i], err = strconv.ParseFloat(value, 64)
+		if err != nil || weights[i] < 0 {
+			return nil, fmt.Errorf("%s=%s is not a weight", name, value)
+		}
+	}
+	return weights, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,101 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// overruns accounts for the intervals whose generation and sending took
+// longer than the interval, which means the offered load couldn't be
+// achieved
+type overruns struct {
+	mode *string
+
+	count   int64
+	skipped int64
+	// late and max are the total and the largest lateness in nanoseconds
+	late    int64
+	max     int64
+	aborted int32
+}
+
+func addOverrunFlags(fs *flag.FlagSet) *overruns {
+	return &overruns{
+		mode: fs.String("overrun", "continue", "What to do when an interval takes longer than -interval: continue late, skip the intervals missed, or abort the run"),
+	}
+}
+
+// check validates -overrun
+func (o *overruns) check() {
+	switch *o.mode {
+	case "continue", "skip", "abort":
+	default:
+		log.Fatalf("Unknown -overrun %s, expected continue, skip or abort", *o.mode)
+	}
+}
+
+// record accounts for an interval that ended late past its deadline, when
+// the next one should have started. With -overrun skip it returns the
+// number of intervals to skip to get back on schedule, with -overrun abort
+// it calls stop.
+func (o *overruns) record(late, interval time.Duration, stop func()) int {
+	atomic.AddInt64(&o.count, 1)
+	atomic.AddInt64(&o.late, int64(late))
+	for {
+		max := atomic.LoadInt64(&o.max)
+		if int64(late) <= max || atomic.CompareAndSwapInt64(&o.max, max, int64(late)) {
+			break
+		}
+	}
+
+	switch *o.mode {
+	case "abort":
+		if atomic.CompareAndSwapInt32(&o.aborted, 0, 1) {
+			fmt.Printf("Interval overran by %v, aborting\n", late)
+			stop()
+		}
+	case "skip":
+		if interval > 0 {
+			skip := int(late/interval) + 1
+			atomic.AddInt64(&o.skipped, int64(skip))
+			return skip
+		}
+	}
+	return 0
+}
+
+// failed reports whether the run was aborted by an overrun
+func (o *overruns) failed() bool {
+	return atomic.LoadInt32(&o.aborted) != 0
+}
+
+func (o *overruns) report() string {
+	count := atomic.LoadInt64(&o.count)
+	if count == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d intervals late, by %v on average and %v at most, %d intervals skipped",
+		count, time.Duration(atomic.LoadInt64(&o.late)/count), time.Duration(atomic.LoadInt64(&o.max)), atomic.LoadInt64(&o.skipped))
+}
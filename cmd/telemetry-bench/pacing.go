@@ -0,0 +1,78 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// pacer holds a send thread to an even share of the -spread rate with
+// -pacing adaptive. It starts every send early by the send latency it has
+// observed, so the sends complete on schedule even as the latency of the
+// broker varies.
+type pacer struct {
+	gap time.Duration
+	// jitter varies every gap by up to this fraction either way
+	jitter float64
+	next   time.Time
+	// latency is the moving average of the send latency in nanoseconds
+	latency float64
+	// late counts the sends that started more than a gap behind schedule
+	late int64
+}
+
+// spreadSlack is how far ahead of its schedule paced generation or sending
+// may get before it sleeps, shorter sleeps would only overshoot
+const spreadSlack = time.Millisecond
+
+// pacerWeight is the weight of a new send latency in the moving average
+const pacerWeight = 0.1
+
+// wait sleeps until the next send is due, returning false if ctx is done
+func (p *pacer) wait(ctx context.Context) bool {
+	now := time.Now()
+	if p.next.IsZero() {
+		p.next = now
+	}
+	d := p.next.Sub(now) - time.Duration(p.latency)
+	gap := p.gap
+	if p.jitter > 0 {
+		gap = time.Duration(float64(gap) * (1 + (rand.Float64()*2-1)*p.jitter))
+	}
+	p.next = p.next.Add(gap)
+	if d < -p.gap {
+		p.late++
+	}
+	if d > spreadSlack {
+		return sleep(ctx, d)
+	}
+	return ctx.Err() == nil
+}
+
+// observe feeds the latency of a send into the moving average
+func (p *pacer) observe(d time.Duration) {
+	if p.latency == 0 {
+		p.latency = float64(d)
+		return
+	}
+	p.latency += pacerWeight * (float64(d) - p.latency)
+}
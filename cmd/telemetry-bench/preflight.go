@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+	"pack.ag/amqp"
+)
+
+// preflight sends one unsettled canary on every transport before the load
+// starts, so a run to an unroutable or forbidden address fails up front
+// rather than sends presettled messages into the void
+type preflight struct {
+	enable  *bool
+	timeout *int
+}
+
+func addPreflightFlags(fs *flag.FlagSet) *preflight {
+	return &preflight{
+		enable:  fs.Bool("preflight", false, "Send and settle one canary message on every URL before the load starts, and abort if it isn't accepted"),
+		timeout: fs.Int("preflighttimeout", 10, "Seconds the -preflight canary may take to be sent and settled"),
+	}
+}
+
+// canaryBody is the payload of the canary, a collectd message without any
+// metric in it
+var canaryBody = []byte("[]")
+
+// check sends the canary on each of transports, to the matching URL of
+// urls, and returns an error saying what is likely wrong if one fails
+func (p *preflight) check(ctx context.Context, urls []string, transports []transport.Transport) error {
+	if !*p.enable {
+		return nil
+	}
+	timeout := time.Duration(*p.timeout) * time.Second
+	for i, t := range transports {
+		start := time.Now()
+		if err := p.canary(ctx, t, timeout); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%s: %v", urls[i], err)
+		}
+		fmt.Printf("Preflight: canary to %s settled in %v\n", urls[i], time.Since(start))
+	}
+	return nil
+}
+
+func (p *preflight) canary(ctx context.Context, t transport.Transport, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	msg := &transport.Message{Body: canaryBody}
+	if err := t.Send(ctx, msg); err != nil {
+		return canaryError(ctx, err, timeout)
+	}
+	select {
+	case out := <-t.Acks():
+		if out.Error != nil {
+			return canaryError(ctx, out.Error, timeout)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("the canary was sent but not settled within %v, the address may have no consumer", timeout)
+	}
+}
+
+// canaryError explains the error of a canary
+func canaryError(ctx context.Context, err error, timeout time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("no credit to send the canary within %v, the address may be unroutable or have no consumer", timeout)
+	}
+	switch err.(type) {
+	case *amqp.Error:
+		return fmt.Errorf("the canary was rejected (%v), check that the address exists and this user may send to it", err)
+	case *amqp.DetachError:
+		return fmt.Errorf("the sender link was detached (%v), the address may not exist or sending to it may not be permitted", err)
+	}
+	return fmt.Errorf("sending the canary: %v", err)
+}
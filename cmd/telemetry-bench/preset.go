@@ -0,0 +1,226 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// preset is a named set of option values matching a known deployment, so a
+// user doesn't have to work out the right combination of flags for it
+type preset struct {
+	description string
+	// options are applied to the flags not set on the command line, in
+	// the environment or in the config file
+	options map[string]string
+	// addresses are the AMQP target addresses by message type, used for
+	// URLs given without a path
+	addresses map[string]string
+}
+
+var presets = map[string]*preset{
+	"stf": {
+		description: "Service Telemetry Framework: collectd and ceilometer addresses, unsettled sends",
+		options: map[string]string{
+			// the collectd amqp1 plugin is configured with PreSettle false
+			"ack": "true",
+		},
+		addresses: map[string]string{
+			"metrics":    "collectd/telemetry",
+			"events":     "collectd/notify",
+			"ceilometer": "anycast/ceilometer/metering.sample",
+		},
+	},
+	"osp": {
+		description: "OpenStack compute node names: overcloud-compute-N.localdomain hosts, virt instance-0000xxxx guests",
+		options: map[string]string{
+			"hostname":   "overcloud-compute-%d.localdomain",
+			"dictionary": "osp",
+		},
+	},
+	"k8s": {
+		description: "OpenShift node names: worker-N hosts, kubelet and cAdvisor series per pod and container, the pods churning",
+		options: map[string]string{
+			"hostname":   "worker-%d",
+			"dictionary": "kubelet",
+		},
+	},
+	"ceph": {
+		description: "Ceph storage node names: overcloud-cephstorage-N.localdomain hosts, ceph plugin counters per OSD, 12 OSDs each",
+		options: map[string]string{
+			"hostname":      "overcloud-cephstorage-%d.localdomain",
+			"dictionary":    "ceph",
+			"instances":     "12",
+			"types":         "3",
+			"typeinstances": "8",
+		},
+	},
+	"snmp": {
+		description: "collectd snmp plugin polling switches: every host a 48 port switch with its interface table, every 60 seconds",
+		options: map[string]string{
+			"hostname":      "switch-%02d.mgmt",
+			"dictionary":    "snmp",
+			"plugins":       "1",
+			"instances":     "1",
+			"types":         "4",
+			"typeinstances": "57",
+			"valuegen":      "counter",
+		},
+	},
+	"ipmi": {
+		description: "bare metal node: ipmi and lm-sensors temperatures, fan speeds and voltages every 60 and 30 seconds next to the fast plugins",
+		options: map[string]string{
+			"hostname":      "overcloud-baremetal-%d.localdomain",
+			"dictionary":    "ipmi",
+			"plugins":       "5",
+			"types":         "3",
+			"typeinstances": "8",
+			"valuegen":      "randomwalk",
+		},
+	},
+	"ovs-dpdk": {
+		description: "OVS-DPDK compute node: ovs_stats per port and dpdkstat per queue, 8 ports each, fast growing counters",
+		options: map[string]string{
+			"hostname":      "overcloud-computeovsdpdk-%d.localdomain",
+			"dictionary":    "ovs-dpdk",
+			"plugins":       "2",
+			"instances":     "8",
+			"types":         "4",
+			"typeinstances": "8",
+			"valuegen":      "counter",
+			"valuerange":    "*=0:1e15:1e8",
+		},
+	},
+}
+
+// profiles are presets of the topology and cadence only, sized after
+// clouds of a given number of nodes. They go below the -preset in
+// precedence, so the two can be combined.
+var profiles = map[string]*preset{
+	"small": {
+		description: "10 node OpenStack cloud",
+		options: map[string]string{
+			"hosts":         "10",
+			"plugins":       "20",
+			"types":         "2",
+			"typeinstances": "2",
+			"instances":     "4",
+			"interval":      "10",
+			"dictionary":    "collectd",
+		},
+	},
+	"medium": {
+		description: "100 node OpenStack cloud",
+		options: map[string]string{
+			"hosts":         "100",
+			"plugins":       "30",
+			"types":         "2",
+			"typeinstances": "2",
+			"instances":     "4",
+			"interval":      "10",
+			"dictionary":    "collectd",
+			"threads":       "2",
+		},
+	},
+	"large": {
+		description: "1000 node OpenStack cloud",
+		options: map[string]string{
+			"hosts":         "1000",
+			"plugins":       "40",
+			"types":         "2",
+			"typeinstances": "2",
+			"instances":     "4",
+			"interval":      "10",
+			"dictionary":    "collectd",
+			"threads":       "4",
+			"generators":    "4",
+		},
+	},
+}
+
+func presetNames() []string {
+	return namesOf(presets)
+}
+
+func profileNames() []string {
+	return namesOf(profiles)
+}
+
+// namesOf returns the sorted names of a set of presets
+func namesOf(set map[string]*preset) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupPreset returns the presets of a comma separated list merged into
+// one, the options and addresses of the earlier names first
+func lookupPreset(names string) *preset {
+	merged := &preset{options: map[string]string{}, addresses: map[string]string{}}
+	for _, name := range strings.Split(names, ",") {
+		p := lookupIn(presets, "preset", strings.TrimSpace(name))
+		for k, v := range p.options {
+			if _, ok := merged.options[k]; !ok {
+				merged.options[k] = v
+			}
+		}
+		for k, v := range p.addresses {
+			if _, ok := merged.addresses[k]; !ok {
+				merged.addresses[k] = v
+			}
+		}
+	}
+	return merged
+}
+
+func lookupProfile(name string) *preset {
+	return lookupIn(profiles, "profile", name)
+}
+
+// lookupIn returns the preset name of set, exiting if there is none
+func lookupIn(set map[string]*preset, kind, name string) *preset {
+	p, ok := set[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown %s %q, options: %s\n", kind, name, strings.Join(namesOf(set), ", "))
+		os.Exit(1)
+	}
+	return p
+}
+
+// address fills in the preset's address for kind when rawurl has no path
+func (p *preset) address(rawurl, kind string) string {
+	addr, ok := p.addresses[kind]
+	if !ok {
+		return rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || strings.Trim(u.Path, "/") != "" {
+		return rawurl
+	}
+	u.Path = "/" + addr
+	return u.String()
+}
@@ -0,0 +1,173 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+// latencyProbe measures the request/response round trip through the router
+// while the firehose is running. Requests go to an address this process
+// also consumes and answers, with a dynamic reply-to address, so the probe
+// doesn't need a responder of its own.
+type latencyProbe struct {
+	interval *int
+	address  *string
+	latencies
+}
+
+func addProbeFlags(fs *flag.FlagSet) *latencyProbe {
+	return &latencyProbe{
+		interval: fs.Int("probeinterval", 0, "Milliseconds between RPC round trip latency probes (0 to disable)"),
+		address:  fs.String("probeaddress", "telemetry-bench/probe", "Address the latency probe requests are sent to and answered from"),
+	}
+}
+
+// serveEcho answers the requests received on requests until ctx is done,
+// each to its reply-to address with the data reply returns for it, on a
+// link per reply-to address of session it has answered to
+func serveEcho(ctx context.Context, session *amqp.Session, requests *amqp.Receiver, reply func(req *amqp.Message) [][]byte) {
+	answers := map[string]*amqp.Sender{}
+	for {
+		req, err := requests.Receive(ctx)
+		if err != nil {
+			return
+		}
+		data := reply(req)
+		req.Accept()
+		if req.Properties == nil || req.Properties.ReplyTo == "" {
+			continue
+		}
+		answer, ok := answers[req.Properties.ReplyTo]
+		if !ok {
+			answer, err = session.NewSender(amqp.LinkTargetAddress(req.Properties.ReplyTo))
+			if err != nil {
+				log.Printf("Creating the answer link to %s: %v", req.Properties.ReplyTo, err)
+				continue
+			}
+			answers[req.Properties.ReplyTo] = answer
+		}
+		resp := &amqp.Message{
+			Properties: &amqp.MessageProperties{
+				CorrelationID: req.Properties.MessageID,
+			},
+			Data: data,
+		}
+		resp.SendSettled = true
+		if answer.Send(ctx, resp) != nil {
+			return
+		}
+	}
+}
+
+func (p *latencyProbe) enabled() bool {
+	return *p.interval > 0
+}
+
+// start connects the probe to the router of rawurl and sends requests until
+// ctx is done
+func (p *latencyProbe) start(ctx context.Context, rawurl string) {
+	if !p.enabled() {
+		return
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if u.Scheme != "amqp" && u.Scheme != "amqps" {
+		log.Printf("Latency probe needs an amqp URL, disabled for %s", rawurl)
+		*p.interval = 0
+		return
+	}
+
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		log.Fatal("Dialing AMQP server for the latency probe:", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatal("Creating AMQP probe session:", err)
+	}
+	requests, err := session.NewReceiver(amqp.LinkSourceAddress(*p.address), amqp.LinkCredit(10))
+	if err != nil {
+		log.Fatal("Creating probe responder link:", err)
+	}
+	responses, err := session.NewSender(amqp.LinkTargetAddress(*p.address))
+	if err != nil {
+		log.Fatal("Creating probe request link:", err)
+	}
+	replies, err := session.NewReceiver(amqp.LinkAddressDynamic(), amqp.LinkCredit(10))
+	if err != nil {
+		log.Fatal("Creating probe reply link:", err)
+	}
+	// responder, echoing the requests back
+	go serveEcho(ctx, session, requests, func(req *amqp.Message) [][]byte { return req.Data })
+
+	// requester: one probe in flight at a time, a probe without an answer
+	// before the next is due counts as lost
+	go func() {
+		defer client.Close()
+		interval := time.Duration(*p.interval) * time.Millisecond
+		for seq := uint64(0); ; seq++ {
+			sent := time.Now()
+			req := &amqp.Message{
+				Properties: &amqp.MessageProperties{
+					MessageID: seq,
+					ReplyTo:   replies.Address(),
+				},
+				Data: [][]byte{[]byte(strconv.FormatUint(seq, 10))},
+			}
+			req.SendSettled = true
+			if err := responses.Send(ctx, req); err != nil {
+				return
+			}
+
+			waitCtx, cancel := context.WithDeadline(ctx, sent.Add(interval))
+			for {
+				resp, err := replies.Receive(waitCtx)
+				if err != nil {
+					if ctx.Err() == nil {
+						p.Lock()
+						p.lost++
+						p.Unlock()
+					}
+					break
+				}
+				resp.Accept()
+				if resp.Properties != nil && resp.Properties.CorrelationID == seq {
+					p.add(time.Now().Sub(sent))
+					break
+				}
+				// a late answer to an earlier probe
+			}
+			cancel()
+			if !sleep(ctx, time.Until(sent.Add(interval))) {
+				return
+			}
+		}
+	}()
+}
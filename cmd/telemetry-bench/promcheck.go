@@ -0,0 +1,269 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+)
+
+// promCheck records the values sent for a random sample of plugins, and
+// after the run looks them up in Prometheus to check each value and
+// timestamp made it through the pipeline unchanged, catching corruption as
+// well as loss
+type promCheck struct {
+	url  string
+	name string
+	wait time.Duration
+	// millis is set when the time field is sent in milliseconds
+	millis bool
+
+	// picked is only written before generation starts
+	picked map[*generator.Plugin]bool
+
+	sync.Mutex
+	sent []collectdMetric
+}
+
+// pick chooses n plugins at random to record
+func (c *promCheck) pick(hosts []generator.Host, n int) {
+	c.picked = map[*generator.Plugin]bool{}
+	var total int
+	for _, h := range hosts {
+		total += len(h.Plugins)
+	}
+	if n > total {
+		n = total
+	}
+	for len(c.picked) < n {
+		h := &hosts[rand.Intn(len(hosts))]
+		if len(h.Plugins) > 0 {
+			c.picked[&h.Plugins[rand.Intn(len(h.Plugins))]] = true
+		}
+	}
+}
+
+// sampled reports whether the payloads of p are recorded
+func (c *promCheck) sampled(p *generator.Plugin) bool {
+	return c.picked[p]
+}
+
+// record keeps the samples of a sent metric payload
+func (c *promCheck) record(payload []byte) {
+	var metrics []collectdMetric
+	if err := json.Unmarshal(payload, &metrics); err != nil {
+		return
+	}
+	if c.millis {
+		for i := range metrics {
+			metrics[i].Time /= 1000
+		}
+	}
+	c.Lock()
+	c.sent = append(c.sent, metrics...)
+	c.Unlock()
+}
+
+// metricName expands the -promname template for one data source
+func (c *promCheck) metricName(m collectdMetric, dsname string) string {
+	return strings.NewReplacer(
+		"{plugin}", m.Plugin,
+		"{type}", m.Type,
+		"{dsname}", dsname,
+	).Replace(c.name)
+}
+
+// selector returns the Prometheus series selector of a data source
+func (c *promCheck) selector(m collectdMetric, dsname string) string {
+	return fmt.Sprintf(`%s{host=%q,plugin_instance=%q,type_instance=%q}`,
+		c.metricName(m, dsname), m.Host, m.PluginInstance, m.TypeInstance)
+}
+
+// promPoint is a sample read back from Prometheus
+type promPoint struct {
+	time  float64
+	value float64
+}
+
+// queryRange returns the samples of selector over the window ending at end
+func (c *promCheck) queryRange(ctx context.Context, selector string, window time.Duration, end float64) ([]promPoint, error) {
+	q := url.Values{}
+	q.Set("query", fmt.Sprintf("%s[%ds]", selector, int64(window.Seconds())+1))
+	q.Set("time", strconv.FormatFloat(end, 'f', 3, 64))
+	req, err := http.NewRequest("GET", strings.TrimRight(c.url, "/")+"/api/v1/query?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Values [][2]interface{} `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("Prometheus query %s: %s", selector, result.Error)
+	}
+
+	var points []promPoint
+	for _, r := range result.Data.Result {
+		for _, v := range r.Values {
+			t, _ := v[0].(float64)
+			s, _ := v[1].(string)
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+			points = append(points, promPoint{time: t, value: value})
+		}
+	}
+	return points, nil
+}
+
+// promResult counts the outcome of checking the recorded samples
+type promResult struct {
+	matched, wrongValue, wrongTime, missing int
+	examples                                []string
+	err                                     error
+}
+
+// Outcomes of looking a sent sample up
+const (
+	missing = iota
+	matched
+	wrongValue
+	wrongTime
+)
+
+// match finds the stored point corresponding to the sent one, preferring an
+// exact match, then one at the same time, then one with the same value
+func match(got []promPoint, sent promPoint) (promPoint, int) {
+	best, outcome := promPoint{}, missing
+	for _, g := range got {
+		sameTime := math.Abs(g.time-sent.time) < 0.001
+		sameValue := g.value == sent.value
+		switch {
+		case sameTime && sameValue:
+			return g, matched
+		case sameTime:
+			best, outcome = g, wrongValue
+		case sameValue && outcome == missing:
+			best, outcome = g, wrongTime
+		}
+	}
+	return best, outcome
+}
+
+// check looks every recorded sample up once
+func (c *promCheck) check(ctx context.Context) promResult {
+	bySeries := map[string][]promPoint{}
+	var first, last = math.Inf(1), math.Inf(-1)
+	for _, m := range c.sent {
+		for i, dsname := range m.DSNames {
+			if i < len(m.Values) {
+				sel := c.selector(m, dsname)
+				bySeries[sel] = append(bySeries[sel], promPoint{time: m.Time, value: m.Values[i]})
+			}
+		}
+		first, last = math.Min(first, m.Time), math.Max(last, m.Time)
+	}
+	window := time.Duration((last-first)*float64(time.Second)) + time.Minute
+
+	var r promResult
+	example := func(format string, args ...interface{}) {
+		if len(r.examples) < 5 {
+			r.examples = append(r.examples, fmt.Sprintf(format, args...))
+		}
+	}
+	for sel, want := range bySeries {
+		got, err := c.queryRange(ctx, sel, window, last+30)
+		if err != nil {
+			r.err = err
+			r.missing += len(want)
+			continue
+		}
+		for _, w := range want {
+			switch g, outcome := match(got, w); outcome {
+			case matched:
+				r.matched++
+			case wrongValue:
+				r.wrongValue++
+				example("%s at %.3f: sent %v, stored %v", sel, w.time, w.value, g.value)
+			case wrongTime:
+				r.wrongTime++
+				example("%s value %v: sent at %.3f, stored at %.3f", sel, w.value, w.time, g.time)
+			default:
+				r.missing++
+				example("%s at %.3f: not found", sel, w.time)
+			}
+		}
+	}
+	return r
+}
+
+// run checks the recorded samples until they all match or the wait expires
+func (c *promCheck) run(ctx context.Context) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.sent) == 0 {
+		fmt.Println("No metrics recorded, skipping the Prometheus check")
+		return
+	}
+	fmt.Printf("Checking %d recorded metrics from %d plugins against Prometheus %s\n", len(c.sent), len(c.picked), c.url)
+
+	deadline := time.Now().Add(c.wait)
+	for {
+		r := c.check(ctx)
+		if r.matched > 0 && r.wrongValue+r.wrongTime+r.missing == 0 || !time.Now().Before(deadline) || ctx.Err() != nil {
+			fmt.Printf("Prometheus: %d samples matched, %d wrong value, %d wrong timestamp, %d missing\n",
+				r.matched, r.wrongValue, r.wrongTime, r.missing)
+			if r.err != nil {
+				fmt.Printf("Prometheus: %v\n", r.err)
+			}
+			for _, e := range r.examples {
+				fmt.Printf("    %s\n", e)
+			}
+			return
+		}
+		sleep(ctx, 2*time.Second)
+	}
+}
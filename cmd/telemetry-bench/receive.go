@@ -0,0 +1,357 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+	"pack.ag/amqp"
+)
+
+func runReceive(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	intervalSec := fs.Int("interval", 1, "Reporting interval (sec)")
+	credit := fs.Int("credit", 256, "Link credit granted by the receiver")
+	acceptDelay := fs.Int("acceptdelay", 0, "Milliseconds to wait before accepting each message, to simulate a slow consumer")
+	validate := fs.Bool("validate", false, "Validate every message against the collectd JSON format and report the malformed ones")
+	creditSweep := fs.String("creditsweep", "", "Comma separated link credits to run one after the other, -sweepstep seconds each, reporting the rate of each")
+	sweepStep := fs.Int("sweepstep", 30, "Seconds each -creditsweep credit is run for")
+	batching := fs.Bool("batching", false, "Batch the dispositions of accepted messages instead of sending one per message")
+	batchMaxAge := fs.Int("batchmaxage", 5, "Milliseconds a disposition may wait in a -batching batch")
+	receivers := fs.Int("receivers", 1, "Receiver links attached to the address, competing or multicast consumers depending on the address distribution")
+	countSeries := fs.Bool("cardinality", false, "Count the unique host, plugin, type and instance identities of the collectd metrics received and report how the count grows")
+	metricsAddr := fs.String("metricsaddr", "", "Listen address of a Prometheus /metrics endpoint with the receive counts, rate and latencies (empty to disable)")
+	captureFile := fs.String("capture", "", "File to write every received payload to, one JSON line each with its creation and receive times")
+	cpu := addCPUFlags(fs)
+	mgmt := addManagementFlags(fs)
+	clock := addCalibrationFlags(fs)
+	sum := addChecksumFlags(fs)
+	summary := addSummaryFlags(fs)
+
+	cmd.parse(fs, args)
+	urls := cmd.urls(fs, 1)
+	cpu.apply()
+
+	u, err := url.Parse(urls[0])
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	cfg := receiveConfig{
+		endPointURL: u.Scheme + "://" + u.Host,
+		amqpAddr:    u.Path,
+		credit:      *credit,
+		acceptDelay: time.Duration(*acceptDelay) * time.Millisecond,
+		intervalSec: *intervalSec,
+		validate:    *validate,
+		receivers:   *receivers,
+		mgmt:        mgmt,
+		clock:       clock,
+	}
+	if sum.enabled() {
+		cfg.checksum = sum
+	}
+	if *countSeries {
+		cfg.cardinality = newCardinality()
+	}
+	if *metricsAddr != "" {
+		cfg.metrics = &receiverMetrics{labels: summary.labels}
+		cfg.metrics.serve(*metricsAddr)
+	}
+	if *captureFile != "" {
+		cfg.capture = openCapture(*captureFile)
+		defer cfg.capture.close()
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if *batching {
+		cfg.batchMaxAge = time.Duration(*batchMaxAge) * time.Millisecond
+	}
+	if *creditSweep == "" {
+		received, elapsed := receive(ctx, cfg)
+		summary.set("received", received)
+		if cfg.cardinality != nil {
+			summary.set("series", cfg.cardinality.count())
+		}
+		summary.set("elapsed_seconds", elapsed.Seconds())
+		if elapsed > 0 {
+			summary.set("rate", float64(received)/elapsed.Seconds())
+		}
+		summary.print(cmd.name, ctx.Err() != nil)
+		return
+	}
+
+	// Sweep the credits, each on fresh links, and compare their rates
+	var credits []int
+	for _, field := range strings.Split(*creditSweep, ",") {
+		c, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || c < 1 {
+			log.Fatalf("Invalid -creditsweep credit %q", field)
+		}
+		credits = append(credits, c)
+	}
+	cfg.duration = time.Duration(*sweepStep) * time.Second
+	results := make([]float64, 0, len(credits))
+	for _, c := range credits {
+		cfg.credit = c
+		received, elapsed := receive(ctx, cfg)
+		results = append(results, float64(received)/elapsed.Seconds())
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	fmt.Printf("%10s %14s\n", "credit", "msg/sec")
+	sweep := make([]map[string]interface{}, len(results))
+	for i, rate := range results {
+		fmt.Printf("%10d %14.1f\n", credits[i], rate)
+		sweep[i] = map[string]interface{}{"credit": credits[i], "rate": rate}
+	}
+	summary.set("credit_sweep", sweep)
+	summary.print(cmd.name, ctx.Err() != nil)
+}
+
+// receiveConfig holds the options of a receive run
+type receiveConfig struct {
+	endPointURL string
+	amqpAddr    string
+	credit      int
+	acceptDelay time.Duration
+	intervalSec int
+	validate    bool
+	receivers   int
+	mgmt        *management
+	// clock corrects the latencies from the sender timestamps
+	clock *clockCalibration
+	// cardinality counts the unique series received when set
+	cardinality *cardinality
+	// metrics are served on /metrics when set
+	metrics *receiverMetrics
+	// capture records the received payloads when set
+	capture *capture
+	// checksum verifies the payload signatures when set
+	checksum *checksum
+	// batchMaxAge enables batched dispositions when non-zero
+	batchMaxAge time.Duration
+	// duration stops the run after the given time when non-zero
+	duration time.Duration
+}
+
+// receive consumes messages from amqpAddr until ctx is done and prints the receive rate every
+// interval. acceptDelay and credit simulate a lagging consumer, so broker
+// queue growth and flow control back to producers can be reproduced. Each
+// of the receivers links gets its own session, so they consume in parallel.
+// It returns the number of messages received and the time it ran for.
+func receive(ctx context.Context, cfg receiveConfig) (int64, time.Duration) {
+	client, err := amqp.Dial(cfg.endPointURL)
+	if err != nil {
+		log.Fatal("Dialing AMQP server:", err)
+		return 0, 0
+	}
+	defer client.Close()
+
+	if cfg.receivers < 1 {
+		cfg.receivers = 1
+	}
+	links := make([]*amqp.Receiver, cfg.receivers)
+	for i := range links {
+		session, err := client.NewSession()
+		if err != nil {
+			log.Fatal("Creating AMQP session:", err)
+			return 0, 0
+		}
+
+		opts := []amqp.LinkOption{
+			amqp.LinkSourceAddress(cfg.amqpAddr),
+			amqp.LinkCredit(uint32(cfg.credit)),
+		}
+		if cfg.batchMaxAge > 0 {
+			opts = append(opts, amqp.LinkBatching(true), amqp.LinkBatchMaxAge(cfg.batchMaxAge))
+		}
+		links[i], err = session.NewReceiver(opts...)
+		if err != nil {
+			log.Fatal("Creating receiver link:", err)
+			return 0, 0
+		}
+	}
+
+	fmt.Printf("Receiving from %s with %d link(s) (credit %d, accept delay %v)\n", cfg.amqpAddr, cfg.receivers, cfg.credit, cfg.acceptDelay)
+
+	if cfg.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+		defer cancel()
+	}
+
+	st := stats.New(0)
+	perLink := make([]int64, cfg.receivers)
+	var malformed int64
+	var examplesLock sync.Mutex
+	var examples []string
+	inBand := &latencies{}
+	delivery := &latencies{}
+	cfg.metrics.track("inband", inBand)
+	cfg.metrics.track("delivery", delivery)
+	cfg.mgmt.start(ctx, cfg.amqpAddr, time.Duration(cfg.intervalSec)*time.Second)
+	cfg.clock.start(ctx, cfg.endPointURL)
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.intervalSec) * time.Second)
+		defer ticker.Stop()
+
+		last := st.Snapshot()
+		var rt runtimeStats
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			snap := st.Snapshot()
+			fmt.Printf("Total received %d, %.1f msg/sec", snap.Received, snap.ReceiveRate(last))
+			cfg.metrics.setRate(snap.ReceiveRate(last))
+			if cfg.receivers > 1 {
+				fmt.Printf(" (")
+				for i := range perLink {
+					fmt.Printf("(%d)%d, ", i, atomic.LoadInt64(&perLink[i]))
+				}
+				fmt.Printf(")")
+			}
+			if cfg.validate {
+				fmt.Printf(", %d malformed", atomic.LoadInt64(&malformed))
+			}
+			if cfg.checksum != nil {
+				fmt.Printf(", %s", cfg.checksum.report())
+			}
+			if inBand.count() > 0 {
+				fmt.Printf(", latency %s", inBand.report())
+			}
+			if delivery.count() > 0 {
+				fmt.Printf(", delivery latency %s", delivery.report())
+			}
+			if cfg.cardinality != nil {
+				fmt.Printf(", %s", cfg.cardinality.report())
+			}
+			if cfg.clock.calibrated() {
+				fmt.Printf(", %s", cfg.clock.report())
+			}
+			if broker := cfg.mgmt.report(); broker != "" {
+				fmt.Printf(", %s", broker)
+			}
+			fmt.Printf(", %s\n", rt.report())
+			last = snap
+		}
+	}()
+
+	var wait sync.WaitGroup
+	for i, receiver := range links {
+		wait.Add(1)
+		go func(link int, receiver *amqp.Receiver) {
+			defer wait.Done()
+			for {
+				msg, err := receiver.Receive(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Fatal("Reading message from AMQP:", err)
+					return
+				}
+				now := time.Now()
+				if d, ok := latencyOf(msg, now); ok {
+					inBand.add(cfg.clock.correct(d))
+				}
+				if d, ok := deliveryLatencyOf(msg, now); ok {
+					delivery.add(cfg.clock.correct(d))
+				}
+				if cfg.acceptDelay > 0 {
+					sleep(ctx, cfg.acceptDelay)
+				}
+				bad := false
+				if cfg.validate {
+					if err := validateCollectd(msg.GetData()); err != nil {
+						bad = true
+						atomic.AddInt64(&malformed, 1)
+						examplesLock.Lock()
+						if len(examples) < 5 {
+							examples = append(examples, fmt.Sprintf("%v: %.200s", err, msg.GetData()))
+						}
+						examplesLock.Unlock()
+					}
+				}
+				if cfg.checksum != nil {
+					cfg.checksum.verify(msg.ApplicationProperties, msg.GetData())
+				}
+				if cfg.cardinality != nil {
+					cfg.cardinality.add(msg.GetData())
+				}
+				if cfg.capture != nil {
+					var created time.Time
+					if msg.Properties != nil {
+						created = msg.Properties.CreationTime
+					}
+					cfg.capture.write(now, created, runOf(msg.ApplicationProperties), msg.GetData())
+				}
+				msg.Accept()
+				st.Received()
+				cfg.metrics.receivedOne(bad)
+				atomic.AddInt64(&perLink[link], 1)
+			}
+		}(i, receiver)
+	}
+	wait.Wait()
+
+	final := st.Snapshot()
+	fmt.Printf("Total received %d\n", final.Received)
+	if cfg.receivers > 1 {
+		min, max := perLink[0], perLink[0]
+		for _, n := range perLink {
+			if n < min {
+				min = n
+			}
+			if n > max {
+				max = n
+			}
+		}
+		fmt.Printf("Per link: min %d, max %d\n", min, max)
+	}
+	if cfg.validate {
+		fmt.Printf("Malformed %d\n", atomic.LoadInt64(&malformed))
+		for _, e := range examples {
+			fmt.Printf("    %s\n", e)
+		}
+	}
+	if cfg.checksum != nil {
+		fmt.Printf("Integrity: %s\n", cfg.checksum.report())
+	}
+	if cfg.cardinality != nil {
+		fmt.Printf("Series: %d unique\n", cfg.cardinality.count())
+	}
+	fmt.Printf("Runtime: %s\n", runtimeTotal())
+	return final.Received, final.Elapsed
+}
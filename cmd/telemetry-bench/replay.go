@@ -0,0 +1,148 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/bench"
+	"github.com/infrawatch/telemetry-bench/stats"
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+func runReplay(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	fileName := fs.String("file", "-", "File with one payload per line (- for stdin)")
+	repeat := fs.Int("repeat", 1, "How many times to replay the file (-1 for continuous)")
+	requireAck := fs.Bool("ack", false, "Require messages to be ack'd ")
+	dial := addDialFlags(fs)
+	ackErrs := addAckErrorFlags(fs)
+
+	cmd.parse(fs, args)
+	urls := cmd.urls(fs, 1)
+
+	in := os.Stdin
+	if *fileName != "-" {
+		f, err := os.Open(*fileName)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		defer f.Close()
+		in = f
+	}
+	payloads, err := readPayloads(in)
+	if err != nil {
+		log.Fatal("Reading payloads:", err)
+		return
+	}
+	if len(payloads) == 0 {
+		log.Fatal("No payloads to replay")
+		return
+	}
+
+	t, err := transport.New(urls[0], dial.config(transport.Config{AckBuffer: 100}))
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	err = t.Connect(ctx)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	defer t.Close()
+
+	st := stats.New(1)
+	var waitAck sync.WaitGroup
+	ackCtx, stopAck := context.WithCancel(ctx)
+	defer stopAck()
+	waitAck.Add(1)
+	go func() {
+		defer waitAck.Done()
+		for {
+			select {
+			case out := <-t.Acks():
+				if out.Error != nil {
+					ackErrs.observe(out)
+					continue
+				}
+				st.Acked()
+			case <-ackCtx.Done():
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+replay:
+	for i := 0; *repeat == -1 || i < *repeat; i++ {
+		for _, payload := range payloads {
+			msg := &transport.Message{
+				Body:    payload,
+				Settled: !*requireAck,
+			}
+			if err := t.Send(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					break replay
+				}
+				log.Fatal("Sending AMQP message:", err)
+				return
+			}
+			st.Sent(0)
+		}
+	}
+	if *requireAck && ctx.Err() == nil && !bench.WaitAcks(st, ackErrs.count, st.Snapshot().Sent, 10*time.Second) {
+		log.Printf("Timed out waiting for acknowledgements")
+	}
+	stopAck()
+	waitAck.Wait()
+
+	duration := time.Now().Sub(start)
+	snap := st.Snapshot()
+	fmt.Printf("Replayed %d messages (%d ack'd) in %v, %.1f msg/sec\n", snap.Sent, snap.Acked, duration, float64(snap.Sent)/duration.Seconds())
+	if *requireAck {
+		fmt.Printf("Ack errors: %s\n", ackErrs.report())
+	}
+}
+
+// readPayloads returns the non-empty lines of r
+func readPayloads(r io.Reader) ([][]byte, error) {
+	var payloads [][]byte
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		payloads = append(payloads, append([]byte(nil), scanner.Bytes()...))
+	}
+	return payloads, scanner.Err()
+}
@@ -0,0 +1,181 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// reportPrefix starts the names of the periodic report files, which sort
+// by the UTC start of their period
+const reportPrefix = "telemetry-bench-"
+
+// periodicReports writes a results file for every period of a long run,
+// e.g. hourly, keeping only the latest ones. A run sending forever never
+// gets to its final summary.
+type periodicReports struct {
+	dir    *string
+	period *int
+	keep   *int
+}
+
+func addReportFlags(fs *flag.FlagSet) *periodicReports {
+	return &periodicReports{
+		dir:    fs.String("reportdir", "", "Write the results of every -reportperiod to a JSON file in this directory (empty to disable)"),
+		period: fs.Int("reportperiod", 3600, "Seconds covered by each -reportdir file, aligned to the clock (3600 hourly, 86400 daily)"),
+		keep:   fs.Int("reportkeep", 48, "Number of -reportdir files kept, the oldest are removed (0 to keep all)"),
+	}
+}
+
+// check exits on a -reportperiod or -reportkeep that can't be honored
+func (r *periodicReports) check() {
+	if *r.period < 1 {
+		log.Fatal("-reportperiod must be at least 1")
+	}
+	if *r.keep < 0 {
+		log.Fatal("-reportkeep must be at least 0")
+	}
+}
+
+// periodReport is the content of a report file
+type periodReport struct {
+	RunID     string            `json:"run_id,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Partial   bool              `json:"partial"`
+	Intervals int64             `json:"intervals"`
+	Generated int64             `json:"generated"`
+	Sent      int64             `json:"sent"`
+	Failed    int64             `json:"failed"`
+	Acked     int64             `json:"acked"`
+	SendRate  float64           `json:"send_rate"`
+	AckRate   float64           `json:"ack_rate"`
+	// the totals of the run so far
+	Elapsed    float64 `json:"elapsed_seconds"`
+	TotalSent  int64   `json:"total_sent"`
+	TotalAcked int64   `json:"total_acked"`
+}
+
+// start writes a report at the end of every period of st until ctx is
+// done. The returned function writes the partial period and stops.
+func (r *periodicReports) start(ctx context.Context, st *stats.Stats) func() {
+	if *r.dir == "" {
+		return func() {}
+	}
+	if err := os.MkdirAll(*r.dir, 0755); err != nil {
+		fmt.Printf("Creating the report directory: %v\n", err)
+		return func() {}
+	}
+	period := time.Duration(*r.period) * time.Second
+
+	prev := st.Snapshot()
+	reportCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			end := prev.Time.Truncate(period).Add(period)
+			if !sleep(reportCtx, time.Until(end)) {
+				break
+			}
+			snap := st.Snapshot()
+			r.write(prev, snap)
+			prev = snap
+		}
+		r.write(prev, st.Snapshot())
+	}()
+	return func() {
+		stop()
+		<-done
+	}
+}
+
+// write writes the report of the period from prev to snap and removes the
+// reports beyond -reportkeep. The first and last periods of a run are
+// partial.
+func (r *periodicReports) write(prev, snap stats.Snapshot) {
+	period := time.Duration(*r.period) * time.Second
+	current := status.report()
+	report := periodReport{
+		RunID:      current.RunID,
+		Labels:     current.Labels,
+		Start:      prev.Time.UTC(),
+		End:        snap.Time.UTC(),
+		Partial:    snap.Time.Sub(prev.Time) < period*99/100,
+		Intervals:  snap.Intervals - prev.Intervals,
+		Generated:  snap.Generated - prev.Generated,
+		Sent:       snap.Sent - prev.Sent,
+		Failed:     snap.Failed - prev.Failed,
+		Acked:      snap.Acked - prev.Acked,
+		SendRate:   snap.SendRate(prev),
+		AckRate:    snap.AckRate(prev),
+		Elapsed:    snap.Elapsed.Seconds(),
+		TotalSent:  snap.Sent,
+		TotalAcked: snap.Acked,
+	}
+	name := filepath.Join(*r.dir, reportPrefix+report.Start.Format("20060102T150405Z")+".json")
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(name+".tmp", data, 0644)
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		fmt.Printf("Writing the period report: %v\n", err)
+		return
+	}
+	r.prune()
+}
+
+// prune removes the oldest reports beyond -reportkeep
+func (r *periodicReports) prune() {
+	if *r.keep <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(*r.dir)
+	if err != nil {
+		return
+	}
+	var reports []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), reportPrefix) && strings.HasSuffix(f.Name(), ".json") {
+			reports = append(reports, f.Name())
+		}
+	}
+	sort.Strings(reports)
+	for len(reports) > *r.keep {
+		os.Remove(filepath.Join(*r.dir, reports[0]))
+		reports = reports[1:]
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// restart simulates a fleet-wide agent restart: after a while every host
+// stops and every connection closes, then they all come back at once and
+// send their samples in one thundering herd
+type restart struct {
+	after *int
+	down  *int
+
+	// up is closed while the hosts are up, and replaced by an open channel
+	// while they're down
+	up atomic.Value
+}
+
+func addRestartFlags(fs *flag.FlagSet) *restart {
+	r := &restart{
+		after: fs.Int("restartafter", 0, "Seconds into the run to stop every host and connection and bring them back at once (0 to disable)"),
+		down:  fs.Int("restartdown", 10, "Seconds the hosts stay down during a -restartafter restart"),
+	}
+	up := make(chan struct{})
+	close(up)
+	r.up.Store(up)
+	return r
+}
+
+// wait blocks while the hosts are down. It returns false if ctx is
+// cancelled meanwhile.
+func (r *restart) wait(ctx context.Context) bool {
+	select {
+	case <-r.up.Load().(chan struct{}):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run restarts the agents once, -restartafter seconds after it is called,
+// and reports how the bus took the burst
+func (r *restart) run(ctx context.Context, transports []transport.Transport, st *stats.Stats) {
+	if *r.after <= 0 || !sleep(ctx, time.Duration(*r.after)*time.Second) {
+		return
+	}
+	up := make(chan struct{})
+	r.up.Store(up)
+	down := time.Duration(*r.down) * time.Second
+	fmt.Printf("Restarting all agents, down for %v\n", down)
+	for _, t := range transports {
+		t.Close()
+	}
+	if !sleep(ctx, down) {
+		return
+	}
+
+	reconnecting := time.Now()
+	for _, t := range transports {
+		for {
+			err := t.Connect(ctx)
+			if err == nil {
+				break
+			}
+			log.Println("Reconnecting:", err)
+			if !sleep(ctx, 100*time.Millisecond) {
+				return
+			}
+		}
+	}
+	reconnected := time.Since(reconnecting)
+
+	before := st.Snapshot()
+	close(up)
+	if !sleep(ctx, time.Second) {
+		return
+	}
+	after := st.Snapshot()
+	fmt.Printf("Agents restarted: reconnected in %v, %d messages sent and %d failed in the first second\n",
+		reconnected, after.Sent-before.Sent, after.Failed-before.Failed)
+}
@@ -0,0 +1,76 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"log"
+	"time"
+)
+
+// runProperty is the application property carrying the run ID of every
+// message, so overlapping runs can be told apart downstream
+const runProperty = "telemetry_bench_run"
+
+// runID identifies a run in its messages and in all its reports
+type runID struct {
+	id *string
+	// props are the application properties of the messages with no
+	// other properties, shared by all of them and never modified
+	props map[string]interface{}
+}
+
+func addRunIDFlags(fs *flag.FlagSet) *runID {
+	return &runID{
+		id: fs.String("runid", "", "ID of the run, sent as the "+runProperty+" application property of every message and included in every report (default generated)"),
+	}
+}
+
+// resolve generates the run ID unless one was given, and returns it
+func (r *runID) resolve() string {
+	if *r.id == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatal("Generating the run ID:", err)
+		}
+		*r.id = time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+	}
+	r.props = map[string]interface{}{runProperty: *r.id}
+	return *r.id
+}
+
+// tag adds the run ID to the application properties of a message, props
+// being nil or its own
+func (r *runID) tag(props map[string]interface{}) map[string]interface{} {
+	if props == nil {
+		return r.props
+	}
+	props[runProperty] = *r.id
+	return props
+}
+
+// runOf returns the run ID in the application properties of a received
+// message, empty if it has none
+func runOf(props map[string]interface{}) string {
+	id, _ := props[runProperty].(string)
+	return id
+}
@@ -0,0 +1,55 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// runtimeStats samples the bench's own Go runtime, to tell when the client
+// rather than the system under test is the limiting factor. It isn't safe
+// for concurrent use; each report loop keeps its own.
+type runtimeStats struct {
+	numGC   uint32
+	pauseNs uint64
+}
+
+// report returns the heap in use, the collections and pause time since the
+// previous report and the number of goroutines
+func (r *runtimeStats) report() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := fmt.Sprintf("heap %.1f MB, %d GC (%v paused), %d goroutines",
+		float64(m.HeapInuse)/(1<<20), m.NumGC-r.numGC,
+		time.Duration(m.PauseTotalNs-r.pauseNs), runtime.NumGoroutine())
+	r.numGC, r.pauseNs = m.NumGC, m.PauseTotalNs
+	return s
+}
+
+// runtimeTotal returns the runtime statistics of the whole run
+func runtimeTotal() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return fmt.Sprintf("heap %.1f MB in use (%.1f MB from the OS), %d GC, %v paused (%.2f%% of CPU), %d goroutines",
+		float64(m.HeapInuse)/(1<<20), float64(m.Sys)/(1<<20), m.NumGC,
+		time.Duration(m.PauseTotalNs), m.GCCPUFraction*100, runtime.NumGoroutine())
+}
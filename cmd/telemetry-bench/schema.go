@@ -0,0 +1,99 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// collectdDSTypes are the data source types collectd knows
+var collectdDSTypes = map[string]bool{"gauge": true, "derive": true, "counter": true, "absolute": true}
+
+// validateCollectd checks body against the collectd write_http JSON format:
+// an array of value lists, each with matching values, dstypes and dsnames
+// and the identifying fields
+func validateCollectd(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var lists []map[string]interface{}
+	if err := dec.Decode(&lists); err != nil {
+		return fmt.Errorf("not a JSON array of objects: %v", err)
+	}
+	if len(lists) == 0 {
+		return fmt.Errorf("empty array")
+	}
+	for i, vl := range lists {
+		if err := validateValueList(vl); err != nil {
+			return fmt.Errorf("value list %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func validateValueList(vl map[string]interface{}) error {
+	for _, name := range []string{"host", "plugin", "type"} {
+		s, ok := vl[name].(string)
+		if !ok || s == "" {
+			return fmt.Errorf("%s must be a non-empty string", name)
+		}
+	}
+	for _, name := range []string{"plugin_instance", "type_instance"} {
+		if _, ok := vl[name].(string); !ok {
+			return fmt.Errorf("%s must be a string", name)
+		}
+	}
+	for _, name := range []string{"time", "interval"} {
+		if _, ok := vl[name].(json.Number); !ok {
+			return fmt.Errorf("%s must be a number", name)
+		}
+	}
+
+	values, ok := vl["values"].([]interface{})
+	if !ok || len(values) == 0 {
+		return fmt.Errorf("values must be a non-empty array")
+	}
+	for i, v := range values {
+		// collectd writes NaN gauges as null
+		if _, ok := v.(json.Number); !ok && v != nil {
+			return fmt.Errorf("values[%d] must be a number or null", i)
+		}
+	}
+	dstypes, ok := vl["dstypes"].([]interface{})
+	if !ok || len(dstypes) != len(values) {
+		return fmt.Errorf("dstypes must be an array as long as values")
+	}
+	for i, v := range dstypes {
+		if s, ok := v.(string); !ok || !collectdDSTypes[s] {
+			return fmt.Errorf("dstypes[%d] %v is not a collectd data source type", i, v)
+		}
+	}
+	dsnames, ok := vl["dsnames"].([]interface{})
+	if !ok || len(dsnames) != len(values) {
+		return fmt.Errorf("dsnames must be an array as long as values")
+	}
+	for i, v := range dsnames {
+		if s, ok := v.(string); !ok || s == "" {
+			return fmt.Errorf("dsnames[%d] must be a non-empty string", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// selfCheck verifies at the end of a run that it shut down cleanly: that
+// every goroutine it started has exited, the queues are drained and every
+// unsettled delivery has an outcome. The process exiting would hide any of
+// these, and with them the messages or the work they stand for.
+type selfCheck struct {
+	mode *string
+	// baseline counts the goroutines running before the run by creator
+	baseline map[string]int
+}
+
+// selfCheckGrace is how long the goroutines are given to exit once the
+// transports are closed
+const selfCheckGrace = 2 * time.Second
+
+func addSelfCheckFlags(fs *flag.FlagSet) *selfCheck {
+	return &selfCheck{
+		mode: fs.String("selfcheck", "report", "At shutdown, report: check for goroutines left running, undrained queues and unsettled deliveries without an outcome, fail: exit with status 1 on any too, off: skip the check"),
+	}
+}
+
+// start records the goroutines running before the run, which the check
+// leaves out
+func (c *selfCheck) start() {
+	switch *c.mode {
+	case "report", "fail":
+		c.baseline = goroutineCreators()
+	case "off":
+	default:
+		log.Fatalf("Unknown -selfcheck %s, expected report, fail or off", *c.mode)
+	}
+}
+
+// shutdownState is what the run leaves behind once it's done
+type shutdownState struct {
+	transports []transport.Transport
+	// queued counts the messages still queued for the send threads
+	queued int
+	// unsettled counts the unsettled messages sent without an outcome,
+	// only known when the run wasn't interrupted
+	unsettled int64
+}
+
+// check closes the transports and returns the leaks found, printing them,
+// an empty list rather than nil for a clean shutdown. It fails the run with
+// -selfcheck fail if there are any.
+func (c *selfCheck) check(s shutdownState) (leaks []string, ok bool) {
+	leaks = []string{}
+	if c.baseline == nil {
+		return leaks, true
+	}
+	var outcomes int
+	for _, t := range s.transports {
+		outcomes += len(t.Acks())
+		t.Close()
+	}
+	if s.queued > 0 {
+		leaks = append(leaks, fmt.Sprintf("%d messages left queued", s.queued))
+	}
+	if outcomes > 0 {
+		leaks = append(leaks, fmt.Sprintf("%d outcomes left unprocessed", outcomes))
+	}
+	if s.unsettled > 0 {
+		leaks = append(leaks, fmt.Sprintf("%d unsettled deliveries without an outcome", s.unsettled))
+	}
+	leaks = append(leaks, c.goroutineLeaks()...)
+
+	if len(leaks) == 0 {
+		fmt.Println("Shutdown check: ok")
+		return leaks, true
+	}
+	fmt.Printf("Shutdown check: %s\n", strings.Join(leaks, ", "))
+	return leaks, *c.mode != "fail"
+}
+
+// goroutineLeaks waits for the goroutines started by the run to exit,
+// returning the ones still running after the grace period by creator
+func (c *selfCheck) goroutineLeaks() []string {
+	deadline := time.Now().Add(selfCheckGrace)
+	for {
+		extra := map[string]int{}
+		for creator, n := range goroutineCreators() {
+			if n > c.baseline[creator] {
+				extra[creator] = n - c.baseline[creator]
+			}
+		}
+		if len(extra) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			leaks := make([]string, 0, len(extra))
+			for creator, n := range extra {
+				leaks = append(leaks, fmt.Sprintf("%d goroutines left running, created by %s", n, creator))
+			}
+			sort.Strings(leaks)
+			return leaks
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// goroutineCreators counts the running goroutines of the bench and of the
+// AMQP library by the function and line that started them, e.g.
+// main.runSend.func12 (send.go:876). Those of the runtime and of the
+// standard library, like the HTTP connections of the status API, are left
+// out.
+func goroutineCreators() map[string]int {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	creators := map[string]int{}
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		lines := strings.Split(string(g), "\n")
+		for i, line := range lines {
+			if !strings.HasPrefix(line, "created by ") || i+1 == len(lines) {
+				continue
+			}
+			fn := strings.TrimPrefix(line, "created by ")
+			if end := strings.Index(fn, " in goroutine "); end >= 0 {
+				fn = fn[:end]
+			}
+			if !strings.HasPrefix(fn, "main.") && !strings.HasPrefix(fn, "github.com/infrawatch/") && !strings.HasPrefix(fn, "pack.ag/") {
+				break
+			}
+			at := strings.TrimSpace(lines[i+1])
+			if end := strings.LastIndex(at, " +0x"); end >= 0 {
+				at = at[:end]
+			}
+			creators[fmt.Sprintf("%s (%s)", fn, filepath.Base(at))]++
+			break
+		}
+	}
+	return creators
+}
@@ -0,0 +1,72 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+// TestSelfCheckClean checks a clean shutdown passes and reports an empty
+// list of leaks, not null, in the JSON summary
+func TestSelfCheckClean(t *testing.T) {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	c := addSelfCheckFlags(fs)
+	if err := fs.Parse([]string{"-selfcheck", "fail"}); err != nil {
+		t.Fatal(err)
+	}
+	c.start()
+	null, err := transport.New("null://", transport.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaks, ok := c.check(shutdownState{transports: []transport.Transport{null}})
+	if !ok {
+		t.Fatalf("clean shutdown failed with %v", leaks)
+	}
+	b, err := json.Marshal(map[string]interface{}{"shutdown_leaks": leaks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"shutdown_leaks":[]}` {
+		t.Errorf("summary %s", b)
+	}
+}
+
+// TestSelfCheckLeaks checks undrained queues and goroutines started after
+// the baseline are reported
+func TestSelfCheckLeaks(t *testing.T) {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	c := addSelfCheckFlags(fs)
+	if err := fs.Parse([]string{"-selfcheck", "fail"}); err != nil {
+		t.Fatal(err)
+	}
+	c.start()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { <-stop }()
+	leaks, ok := c.check(shutdownState{queued: 3, unsettled: 2})
+	if ok || len(leaks) != 3 {
+		t.Errorf("leaks %q, ok %v", leaks, ok)
+	}
+}
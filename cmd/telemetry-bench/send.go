@@ -0,0 +1,1345 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/bench"
+	"github.com/infrawatch/telemetry-bench/generator"
+	"github.com/infrawatch/telemetry-bench/stats"
+	"github.com/infrawatch/telemetry-bench/transport"
+)
+
+func runSend(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	topo := addTopologyFlags(fs)
+	hostClasses := fs.String("hostclasses", "", "Simulate several classes of hosts, each with a topology of its own: preset=hosts pairs such as osp=800,ceph=12, or a JSON file of classes to their options")
+	hostOffset := fs.Int("host-offset", 0, "Number of the first simulated host, so replicas simulate disjoint hosts (-1 for the pod ordinal times -hosts)")
+	spread := fs.Bool("spread", false, "Spread messages over the interval")
+	rateJitter := fs.Float64("rate-jitter", 0, "With -spread, vary every gap between messages randomly by up to this percentage either way")
+	pacing := fs.String("pacing", "generator", "With -spread, generator: the generators pace the messages, adaptive: the send threads do, starting every send early by the send latency they observe")
+	metricsNum := fs.Int("metrics", 1, "Metrics per AMQP messages")
+	intervalSec := fs.Int("interval", 1, "Generation interval (sec)")
+	metricMaxSend := fs.Int("send", 1, "How many metrics to send (-1 for continuous)")
+	maxMessages := fs.Int64("max-messages", 0, "Stop after exactly this many messages, across all threads, generating as many intervals as needed unless -send is given too (0 for no limit)")
+	showTimePerMessages := fs.Int("timepermesgs", -1, "Show time for each TIMEPERMESGS message")
+	profile := addProfilingFlags(fs)
+	cpu := addCPUFlags(fs)
+	mgmt := addManagementFlags(fs)
+	probe := addProbeFlags(fs)
+	echo := addEchoFlags(fs)
+	beacon := addBeaconFlags(fs)
+	sum := addChecksumFlags(fs)
+	flap := addFlapFlags(fs)
+	agents := addRestartFlags(fs)
+	events := addStormFlags(fs)
+	cp := addCheckpointFlags(fs)
+	summary := addSummaryFlags(fs)
+	overrun := addOverrunFlags(fs)
+	throttle := addThrottleFlags(fs)
+	ackErrs := addAckErrorFlags(fs)
+	shutdown := addSelfCheckFlags(fs)
+	stalls := addCreditFlags(fs)
+	dial := addDialFlags(fs)
+	pre := addPreflightFlags(fs)
+	slos := addSLOFlags(fs)
+	reports := addReportFlags(fs)
+	run := addRunIDFlags(fs)
+	captureFile := fs.String("capture", "", "Write a sample of the payloads sent to this file, one JSON line each with its sequence number and send time")
+	captureEvery := fs.Int("captureevery", 1000, "With -capture, write every CAPTUREEVERY-th message sent")
+	latencySample := fs.Int("latencysample", 0, "Mark every LATENCYSAMPLE-th message of each send thread with its send time, for in-band latency (0 to disable)")
+	latencyLoopback := fs.Bool("latencyloopback", false, "Consume the address alongside the send threads and report the latency of the -latencysample messages")
+	promURL := fs.String("promurl", "", "Prometheus URL to check the values and timestamps of a sample of the sent metrics against after the run")
+	promName := fs.String("promname", "collectd_{plugin}_{type}_{dsname}_total", "Prometheus metric name of a collectd data source, with {plugin}, {type} and {dsname} replaced")
+	promSamples := fs.Int("promsamples", 10, "Number of plugins whose metrics are recorded for -promurl")
+	promWait := fs.Int("promwait", 60, "Seconds to wait for the sampled metrics to be stored")
+	esURL := fs.String("esurl", "", "ElasticSearch URL to check the indexed event count against after an events run")
+	esIndex := fs.String("esindex", "collectd_*", "ElasticSearch index holding the events")
+	esWait := fs.Int("eswait", 60, "Seconds to wait for the events to be indexed")
+	verbose := fs.Bool("verbose", false, "Print extra info during test...")
+	sendThreads := fs.Int("threads", 1, "How many send threads, defaults to 1")
+	threadAddress := fs.String("threadaddress", "", "Send the messages of thread i to their own address instead of the URL's, from a template such as collectd/telemetry-%d or a comma separated list used round robin")
+	sessions := fs.Int("sessions", 1, "How many AMQP sessions to open on each connection and spread the sends across")
+	scheduler := fs.String("scheduler", "sequential", "sequential: each generator loops over its hosts every interval, hosts: every host runs on its own ticker, at most -generators at a time")
+	generators := fs.Int("generators", 1, "How many generator goroutines to shard the hosts across")
+	drift := fs.Float64("drift", 0, "With -scheduler hosts, let the interval of every host drift by up to this percentage, like agents with skewed clocks")
+	driftPeriod := fs.Int("driftperiod", 60, "Seconds over which the hosts drift to their full -drift")
+	requireAck := fs.Bool("ack", false, "Require messages to be ack'd ")
+	ackWindow := fs.Int("ackwindow", 0, "With -ack, unsettled messages each connection keeps in flight before the senders pause (0 for one per send thread)")
+	ackBuffer := fs.Int("ackbuffer", 100, "With -ack, acknowledgements each connection queues for the ack workers before its sends stall")
+	ackWorkers := fs.Int("ackworkers", 1, "With -ack, goroutines processing the acknowledgements of each connection")
+	ewmaWindow := fs.Int("ewma", 30, "Seconds the moving averages of the send and ack rates in the interval lines span (0 to leave them out)")
+	syncSend := fs.Bool("sync", false, "Send unsettled and wait for every disposition before the next send, reporting the round trip of each")
+	startMetricEnable := fs.Bool("startmetricenable", false, "Generate telemetry_bench_expected_metrics metric at start of test")
+	startAt := fs.String("startat", "", "Wall-clock time to start generating at, RFC3339 or seconds since the epoch, to start replicas together")
+	startBarrier := fs.String("startbarrier", "", "URL polled every second until it returns 200 OK before generating starts")
+	startupWait := fs.Int("startupwait", 5, "Seconds to wait between startup metric and start of test (also helps settle queue timing when no startupmetric is sent)")
+	messageType := fs.String("messagetype", "metrics", "options: "+strings.Join(messageTypes(), ", ")+". Default messagetype=metrics")
+	mix := fs.String("mix", "", "Generate several message types at once at the given ratios per series, e.g. metrics=1,events=0.01,ceilometer=0.2")
+	addresses := fs.String("addresses", "", "Target addresses of the -mix message types other than -messagetype, e.g. events=collectd/notify")
+	genPlugins := fs.String("genplugin", "", "Comma separated Go plugins (.so) to load, registering their own value generators and message types")
+	collectdSock := fs.String("collectdsock", "", "Path of a live collectd unixsock whose metrics every simulated host reports, instead of the synthetic plugins")
+	format := generator.Format{}
+	fs.StringVar(&format.Time, "timeformat", "float", fmt.Sprintf("Encoding of the metric time field %v", generator.TimeFormats))
+	severities := fs.String("severities", "", "Weights of the event severities, e.g. OKAY=90,WARNING=8,FAILURE=2 (all OKAY by default)")
+	fs.Float64Var(&format.SeverityFlip, "severityflip", 1, "Chance an event series changes severity from one interval to the next (1 draws every event afresh)")
+	fs.IntVar(&format.Interval, "declaredinterval", 0, "Interval in seconds the metrics declare, regardless of -interval (0 to declare the real one)")
+	deterministic := fs.Bool("deterministic", false, "Derive timestamps from -startat and the interval and values from the series, so runs produce identical payloads")
+	dropInterval := fs.Int("dropinterval", 0, "Drop and re-establish the AMQP connection on average every DROPINTERVAL seconds (0 to disable)")
+	dropMessages := fs.Int("dropmessages", 0, "Drop and re-establish the AMQP connection on average every DROPMESSAGES messages (0 to disable)")
+
+	cmd.parse(fs, args)
+	urls := cmd.urls(fs, 0)
+	loadGenPlugins(*genPlugins)
+	if err := slos.parse(*requireAck); err != nil {
+		log.Fatal(err)
+	}
+	status.setRun(run.resolve(), summary.labels)
+	var sentCaptures *capture
+	// seq numbers the sends for the -capture sample
+	var seq int64
+	if *captureFile != "" {
+		if *captureEvery < 1 {
+			log.Fatal("-captureevery must be at least 1")
+		}
+		sentCaptures = openCapture(*captureFile)
+	}
+	if *maxMessages > 0 && !flagGiven(fs, "send") {
+		*metricMaxSend = -1
+	}
+	overrun.check()
+	throttle.check(*requireAck)
+	flap.check()
+	reports.check()
+	adaptive := *spread && *pacing == "adaptive"
+	if *pacing != "generator" && *pacing != "adaptive" {
+		log.Fatalf("Unknown -pacing %s, expected generator or adaptive", *pacing)
+	}
+	if *rateJitter != 0 && (!*spread || *rateJitter < 0 || *rateJitter > 100) {
+		log.Fatal("-rate-jitter needs -spread and a percentage from 0 to 100")
+	}
+	jitter := *rateJitter / 100
+
+	cpu.apply()
+	defer profile.start()()
+
+	entries, err := parseMix(*mix, *messageType, *addresses, cmd.preset)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the event storms go to the events address, and can only be
+	// triggered through the API if there is one
+	var stormAddress string
+	if storm, err := parseMix("events=1", *messageType, *addresses, cmd.preset); err == nil {
+		stormAddress = storm[0].address
+		http.HandleFunc("/storm", events.serve)
+	} else if *events.at > 0 {
+		log.Fatal("Event storm: ", err)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	shutdown.start()
+
+	seed, err := cp.load()
+	if err != nil {
+		log.Fatal("Reading checkpoint:", err)
+	}
+	rand.Seed(seed)
+	resumed := cp.resumed()
+	if *syncSend {
+		if *ackWindow > 0 {
+			log.Fatal("-sync can't be combined with -ackwindow")
+		}
+		*requireAck = true
+	}
+	if *ackErrs.fatal && throttle.enabled() {
+		log.Fatal("-ackerrorfatal can't be combined with -throttle, which slows down on rejections")
+	}
+	if *ackBuffer < 0 || *ackWorkers < 1 {
+		log.Fatal("-ackbuffer can't be negative and -ackworkers must be at least 1")
+	}
+	if *deterministic {
+		// counter and hash values depend on the series and interval only
+		if topo.valueGenerator == "random" {
+			topo.valueGenerator = "hash"
+		}
+		if !generator.IsDeterministic(topo.valueGenerator) {
+			log.Fatalf("-deterministic needs -valuegen %s, not %s", strings.Join(generator.DeterministicValues, " or "), topo.valueGenerator)
+		}
+		if topo.uptime || *collectdSock != "" {
+			log.Fatal("-deterministic can't be combined with -uptimeenable or -collectdsock")
+		}
+	}
+	var classes []hostClass
+	if *hostClasses != "" {
+		if *collectdSock != "" {
+			log.Fatal("-hostclasses can't be combined with -collectdsock")
+		}
+		classes, err = parseHostClasses(*hostClasses, *topo)
+		if err != nil {
+			log.Fatal("Parsing -hostclasses:", err)
+		}
+		// the classes take the place of -hosts
+		topo.hosts = 0
+		for i := range classes {
+			c := &classes[i]
+			if *deterministic {
+				if c.valueGenerator == "random" {
+					c.valueGenerator = "hash"
+				}
+				if !generator.IsDeterministic(c.valueGenerator) || c.uptime {
+					log.Fatalf("-deterministic needs -valuegen %s and no -uptimeenable, unlike class %s", strings.Join(generator.DeterministicValues, " or "), c.name)
+				}
+			}
+			topo.hosts += c.hosts
+		}
+	}
+	offset := *hostOffset
+	if offset < 0 {
+		ordinal, err := podOrdinal()
+		if err != nil {
+			log.Fatal("Deriving -host-offset:", err)
+		}
+		offset = ordinal * topo.hosts
+	}
+	format.Severities, err = parseSeverities(*severities)
+	if err != nil {
+		log.Fatal("Parsing -severities:", err)
+		return
+	}
+	format.Deterministic = *deterministic
+
+	// the heap in use before and after building the topology gives its size
+	var memTopology runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memTopology)
+	topologyStart := memTopology.HeapAlloc
+
+	var hosts []generator.Host
+	// the collectd source is read for as long as the run sends
+	sourceCtx, stopSource := context.WithCancel(ctx)
+	defer stopSource()
+	if *collectdSock != "" {
+		source, err := generator.NewCollectdSource(*collectdSock)
+		if err != nil {
+			log.Fatal("Reading collectd unixsock:", err)
+			return
+		}
+		hosts, err = source.Hosts(topo.hostPrefix, topo.hosts, offset, *intervalSec, topo.naming, format)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		go func() {
+			if err := source.Run(sourceCtx, time.Duration(*intervalSec)*time.Second); err != nil {
+				log.Fatal("Reading collectd unixsock:", err)
+			}
+		}()
+	} else {
+		if classes != nil {
+			hosts, err = generateClasses(classes, offset, *intervalSec, format)
+		} else {
+			hosts, err = topo.generate(offset, *intervalSec, format)
+		}
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+	}
+	// plugins slower than -interval take their share of the intervals
+	series := 0
+	var averagePerInterval float64
+	for i := range hosts {
+		for j := range hosts[i].Plugins {
+			p := &hosts[i].Plugins[j]
+			series += p.Series()
+			averagePerInterval += float64(p.Series()) / float64(p.Every())
+		}
+	}
+	perInterval := int(math.Round(averagePerInterval))
+	runtime.GC()
+	runtime.ReadMemStats(&memTopology)
+	var topologyBytes uint64
+	if memTopology.HeapAlloc > topologyStart {
+		topologyBytes = memTopology.HeapAlloc - topologyStart
+	}
+
+	var memStart runtime.MemStats
+	runtime.ReadMemStats(&memStart)
+
+	// Send threads are spread round robin over one transport per URL, which
+	// allows mixed-transport runs
+	transports := make([]transport.Transport, len(urls))
+	for i, u := range urls {
+		t, err := transport.New(u, dial.config(transport.Config{AckBuffer: *ackBuffer, Sessions: *sessions, Window: *ackWindow}))
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		err = t.Connect(ctx)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		defer t.Close()
+		transports[i] = t
+	}
+	if timings := connectTimings(transports); len(timings) > 0 {
+		fmt.Printf("Connection setup: %s\n", describeConnects(timings))
+	}
+	if err := pre.check(ctx, urls, transports); err != nil {
+		log.Fatal("Preflight check failed:", err)
+	}
+
+	var prom *promCheck
+	if *promURL != "" {
+		prom = &promCheck{url: *promURL, name: *promName, wait: time.Duration(*promWait) * time.Second, millis: format.Time == "millis"}
+		prom.pick(hosts, *promSamples)
+	}
+
+	inBand := &latencies{}
+	// with -sync every send is a round trip, timed per interval and in
+	// total for the summary
+	roundTrips := &latencies{}
+	var roundTripTotal int64
+	mesgChan := make(chan *transport.Message, 200)
+	// queueFull and queueEmpty add up the nanoseconds the generators wait
+	// for room on mesgChan and the send threads wait for messages on it,
+	// which tells whether the transport or the generation holds a run back
+	var queueFull, queueEmpty int64
+	// the generators stop once they have queued -max-messages, and the send
+	// threads once they've sent every message queued
+	genCtx, stopGenerating := context.WithCancel(ctx)
+	defer stopGenerating()
+	var budget, queued int64
+	enqueue := func(ctx context.Context, msg *transport.Message) bool {
+		if *maxMessages > 0 && atomic.AddInt64(&budget, 1) > *maxMessages {
+			msg.Release()
+			stopGenerating()
+			return false
+		}
+		select {
+		case mesgChan <- msg:
+			atomic.AddInt64(&queued, 1)
+			return true
+		default:
+		}
+		blocked := time.Now()
+		defer func() { atomic.AddInt64(&queueFull, int64(time.Since(blocked))) }()
+		select {
+		case mesgChan <- msg:
+			atomic.AddInt64(&queued, 1)
+			return true
+		case <-ctx.Done():
+			msg.Release()
+			return false
+		}
+	}
+	dequeue := func(ctx context.Context) (*transport.Message, bool) {
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		select {
+		case msg := <-mesgChan:
+			return msg, true
+		default:
+		}
+		idle := time.Now()
+		defer func() { atomic.AddInt64(&queueEmpty, int64(time.Since(idle))) }()
+		select {
+		case msg := <-mesgChan:
+			return msg, true
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	var wait sync.WaitGroup
+	var waitb sync.WaitGroup
+
+	st := stats.New(*sendThreads)
+	cp.resume(st)
+	status.track(st)
+	stopBeacon := beacon.start(ctx, cmd.name, urls[0])
+
+	fmt.Printf("Run ID: %s\n", *run.id)
+	fmt.Printf("Send %v metrics every %v second(s)\n", perInterval, *intervalSec)
+
+	start := make(chan bool) // For synchronizing the start of generating and sending
+
+	// Each generator owns a shard of the hosts and keeps its own counts
+	shards := shardHosts(hosts, *generators)
+	if *scheduler == "hosts" {
+		// with a goroutine per host, the generators are the worker slots
+		// bounding how many hosts generate at once
+		shards = make([][]generator.Host, *generators)
+		if len(shards) < 1 {
+			shards = make([][]generator.Host, 1)
+		}
+	} else if *scheduler != "sequential" {
+		log.Fatalf("Unknown -scheduler %q, options: sequential, hosts", *scheduler)
+	} else if *drift != 0 {
+		log.Fatal("-drift needs -scheduler hosts")
+	}
+	genCounts := make([]int64, len(shards))
+	genBusy := make([]time.Duration, len(shards))
+	genTypes := make([][]int64, len(shards))
+
+	// completed returns the number of intervals every generator is done
+	// with, when each host runs on its own the intervals reported so far
+	intervalsDone := make([]int64, len(shards))
+	for worker := range intervalsDone {
+		intervalsDone[worker] = int64(resumed)
+	}
+	completed := func() int64 {
+		if *scheduler == "hosts" {
+			return st.Intervals() - 1
+		}
+		done := atomic.LoadInt64(&intervalsDone[0])
+		for worker := range intervalsDone {
+			if n := atomic.LoadInt64(&intervalsDone[worker]); n < done {
+				done = n
+			}
+		}
+		return done
+	}
+
+	// report prints the totals at the start of every interval
+	var rt runtimeStats
+	prev := st.Snapshot()
+	var prevFull, prevEmpty int64
+	window := time.Duration(*ewmaWindow) * time.Second
+	sendAvg, ackAvg := stats.EWMA{Window: window}, stats.EWMA{Window: window}
+	report := func() {
+		st.StartInterval()
+		snap := st.Snapshot()
+		fmt.Printf("Total sent ")
+		for index, sent := range snap.ThreadSent {
+			fmt.Printf("(%d)%d, ", index, sent)
+		}
+		fmt.Printf("total %d, %d ack'd", snap.Sent, snap.Acked)
+		// the generation and send rates apart, with the share of their
+		// time the generators were blocked and the senders starved
+		full, empty := atomic.LoadInt64(&queueFull), atomic.LoadInt64(&queueEmpty)
+		if elapsed := snap.Time.Sub(prev.Time); elapsed > 0 {
+			fmt.Printf(", generated %.0f/s, sent %.0f/s, generators blocked %.0f%%, senders idle %.0f%%",
+				snap.GenerateRate(prev), snap.SendRate(prev),
+				100*float64(full-prevFull)/float64(elapsed)/float64(len(shards)),
+				100*float64(empty-prevEmpty)/float64(elapsed)/float64(*sendThreads))
+			// smoothed, the trend of a long run shows through the
+			// jitter of the intervals
+			if window > 0 {
+				fmt.Printf(", avg sent %.0f/s", sendAvg.Add(snap.SendRate(prev), elapsed))
+				if *requireAck {
+					fmt.Printf(", avg ack'd %.0f/s", ackAvg.Add(snap.AckRate(prev), elapsed))
+				}
+			}
+			if !*requireAck {
+				fmt.Printf(", %s", stalls.report(elapsed, *sendThreads))
+			}
+		}
+		prev, prevFull, prevEmpty = snap, full, empty
+		cp.tick(st, completed())
+		if broker := mgmt.report(); broker != "" {
+			fmt.Printf(", %s", broker)
+		}
+		if probe.enabled() {
+			fmt.Printf(", probe rtt %s", probe.report())
+		}
+		if *latencyLoopback {
+			fmt.Printf(", latency %s", inBand.report())
+		}
+		if *syncSend {
+			fmt.Printf(", send rtt %s", roundTrips.report())
+		}
+		if throttle.enabled() {
+			fmt.Printf(", %s", throttle.report())
+		}
+		fmt.Printf(", %s\n", rt.report())
+	}
+
+	// timestampAt returns the timestamp of the i-th interval: zero for the
+	// current time, or with -deterministic one counted from the start in
+	// whole intervals, so it doesn't depend on when the messages are sent
+	step := time.Duration(*intervalSec) * time.Second
+	if step <= 0 {
+		step = time.Second
+	}
+	var base time.Time
+	timestampAt := func(i int) time.Time {
+		if !*deterministic {
+			return time.Time{}
+		}
+		return base.Add(time.Duration(i) * step)
+	}
+
+	// generateHost queues interval i of messages for a host and returns
+	// how many it queued. credit carries the fractions of messages left
+	// over by the -mix ratios from one plugin to the next. at is the
+	// timestamp of the messages, zero for the current time. pace, if set,
+	// is called before every message and returns false to stop.
+	generateHost := func(worker int, v *generator.Host, i int, credit []float64, at time.Time, pace func() bool) int {
+		if !agents.wait(genCtx) || flap.silent(v, time.Now()) {
+			return 0
+		}
+		genCount := 0
+		for j := range v.Plugins {
+			// by pointer, saving a copy of the plugin per interval
+			w := &v.Plugins[j]
+			if !w.Due(i) {
+				continue
+			}
+			for e := range entries {
+				entry := &entries[e]
+				quota := credit[e] + entry.ratio*float64(w.Series())
+				n := int(quota)
+				credit[e] = quota - float64(n)
+
+				record := prom != nil && entry.messageType == "metrics" && prom.sampled(w)
+				queued := 0
+				queue := func(payload []byte) bool {
+					if queued == n || (pace != nil && !pace()) {
+						return false
+					}
+					if record {
+						prom.record(payload)
+					}
+					msg := bench.NewMessage(payload, !*requireAck, entry.address)
+					if !enqueue(genCtx, msg) {
+						return false
+					}
+
+					queued++
+					return true
+				}
+				// ratios above 1 render the plugin several times
+				for queued < n && genCtx.Err() == nil {
+					t := at
+					if t.IsZero() {
+						t = time.Now()
+					}
+					entry.render(w, t, queue)
+				}
+				genCount += queued
+				genTypes[worker][e] += int64(queued)
+			}
+			if genCtx.Err() != nil {
+				break
+			}
+		}
+		return genCount
+	}
+
+	// The following function generates AMQP messages for a shard of hosts and
+	// places them on a queue after we tell it to start, until done, out of
+	// -max-messages or cancelled
+	generate := func(worker int, shard []generator.Host) {
+		defer wait.Done()
+
+		select {
+		case <-start: // Wait here for the sending thread to be ready
+		case <-genCtx.Done():
+			return
+		}
+
+		credit := make([]float64, len(entries))
+
+		// due is when the current interval should start, the intervals
+		// keep to it unless one overruns
+		interval := time.Duration(*intervalSec) * time.Second
+		due := time.Now()
+
+		// with -spread the messages of the shard are paced evenly over the
+		// interval, whatever hosts and plugins they come from. paced counts
+		// the messages of the current interval.
+		var pace func() bool
+		paced := 0
+		if *spread && !adaptive {
+			var ratios float64
+			for e := range entries {
+				ratios += entries[e].ratio
+			}
+			var perShard float64
+			for v := range shard {
+				for j := range shard[v].Plugins {
+					p := &shard[v].Plugins[j]
+					perShard += float64(p.Series()) / float64(p.Every())
+				}
+			}
+			if messages := perShard * ratios; messages > 0 {
+				gap := float64(interval) / messages
+				pace = func() bool {
+					// the jitter moves every message around its slot,
+					// which keeps the rate
+					offset := float64(paced)
+					if jitter > 0 {
+						offset += (rand.Float64()*2 - 1) * jitter
+					}
+					target := due.Add(time.Duration(offset * gap))
+					paced++
+					// sleeping for less than the timer resolution would
+					// only slow the pacing down
+					if d := time.Until(target); d > spreadSlack {
+						return sleep(genCtx, d)
+					}
+					return genCtx.Err() == nil
+				}
+			}
+		}
+		for i := resumed; ; i++ {
+			if i >= *metricMaxSend && *metricMaxSend != -1 {
+				if worker == 0 {
+					fmt.Printf("done...\n")
+				}
+				break
+			}
+			start := time.Now()
+			genCount := 0
+			if worker == 0 {
+				report()
+			}
+
+			paced = 0
+			for v := range shard {
+				genCount += generateHost(worker, &shard[v], i, credit, timestampAt(i), pace)
+				if genCtx.Err() != nil {
+					break
+				}
+			}
+			duration := time.Now().Sub(start)
+			genCounts[worker] += int64(genCount)
+			st.Generated(genCount)
+			genBusy[worker] += duration
+			// stopped in the middle of the interval, e.g. by -max-messages,
+			// the messages of the hosts done so far still count
+			if genCtx.Err() != nil {
+				return
+			}
+			atomic.StoreInt64(&intervalsDone[worker], int64(i+1))
+
+			if *verbose {
+				fmt.Printf("(%d): Generated %d metrics in %v\n", worker, genCount*(*metricsNum), duration)
+			}
+			due = due.Add(interval)
+			if late := time.Since(due); interval > 0 && late > 0 {
+				skip := overrun.record(late, interval, stopGenerating)
+				i += skip
+				due = due.Add(time.Duration(skip) * interval)
+			}
+			if !sleep(genCtx, time.Until(due)) {
+				return
+			}
+		}
+	}
+
+	// generateHosts runs every host on its own ticker, starting at a random
+	// point of the first interval, so the traffic of the hosts interleaves
+	// like that of real collectd agents. At most one generation per worker
+	// slot runs at a time.
+	generateHosts := func() {
+		defer wait.Done()
+
+		select {
+		case <-start:
+		case <-genCtx.Done():
+			return
+		}
+
+		slots := make(chan int, len(shards))
+		for worker := range shards {
+			slots <- worker
+		}
+
+		interval := time.Duration(*intervalSec) * time.Second
+		if interval <= 0 {
+			log.Fatal("-scheduler hosts needs an -interval")
+		}
+		var hostWait sync.WaitGroup
+		for h := range hosts {
+			hostWait.Add(1)
+			go func(v *generator.Host) {
+				defer hostWait.Done()
+				credit := make([]float64, len(entries))
+				// the host's interval drifts linearly to skew over
+				// -driftperiod, faster or slower than the others
+				skew := (rand.Float64()*2 - 1) * *drift / 100
+				if !sleep(genCtx, time.Duration(rand.Int63n(int64(interval)))) {
+					return
+				}
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				started := time.Now()
+				next := started
+				for i := resumed; i < *metricMaxSend || *metricMaxSend == -1; i++ {
+					if i > resumed && skew != 0 {
+						ramp := 1.0
+						if *driftPeriod > 0 {
+							ramp = math.Min(1, time.Since(started).Seconds()/float64(*driftPeriod))
+						}
+						next = next.Add(time.Duration(float64(interval) * (1 + skew*ramp)))
+						if !sleep(genCtx, time.Until(next)) {
+							return
+						}
+					} else if i > resumed {
+						select {
+						case <-ticker.C:
+						case <-genCtx.Done():
+							return
+						}
+					}
+					tick := time.Now()
+					// a restarting host doesn't hold a slot
+					if !agents.wait(genCtx) {
+						return
+					}
+					var worker int
+					select {
+					case worker = <-slots:
+					case <-genCtx.Done():
+						return
+					}
+					start := time.Now()
+					genCount := generateHost(worker, v, i, credit, timestampAt(i), nil)
+					genCounts[worker] += int64(genCount)
+					genBusy[worker] += time.Now().Sub(start)
+					slots <- worker
+					st.Generated(genCount)
+					// the ticker drops the ticks a late host misses
+					if late := time.Since(tick) - interval; late > 0 {
+						i += overrun.record(late, interval, stopGenerating)
+					}
+					if genCtx.Err() != nil {
+						return
+					}
+				}
+			}(&hosts[h])
+		}
+
+		done := make(chan struct{})
+		go func() {
+			hostWait.Wait()
+			close(done)
+		}()
+		report()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-done:
+				fmt.Printf("done...\n")
+				return
+			}
+		}
+	}
+
+	for worker := range shards {
+		genTypes[worker] = make([]int64, len(entries))
+	}
+	if *scheduler == "hosts" {
+		wait.Add(1)
+		go generateHosts()
+	} else {
+		for worker, shard := range shards {
+			wait.Add(1)
+			go generate(worker, shard)
+		}
+	}
+
+	// Send threads stop once the generator is done, ack routines once the
+	// send threads are done; both stop immediately when ctx is cancelled
+	sendCtx, stopSend := context.WithCancel(ctx)
+	defer stopSend()
+	ackCtx, stopAck := context.WithCancel(ctx)
+	defer stopAck()
+
+	// Send startup message to prime the pipe and help with evaluating test
+	// See https://github.com/infrawatch/telemetry-bench/issues/6 for details
+	if *startMetricEnable {
+		startMetricContent := fmt.Sprintf(`
+		  [{"values": [%d, %d, %d],
+		  "dstypes": ["gauge", "gauge", "gauge"], "dsnames":["expected_metrics_per_interval", "intervals", "interval_length_seconds"], "time": %d, "interval": %d,
+		  "host": "%s", "plugin": "telemetry_bench", "plugin_instance": "%d",
+		  "type": "%s", "type_instance": "%d"}]`,
+			perInterval,
+			*metricMaxSend, *intervalSec,
+			time.Now().Unix(), *intervalSec,
+			os.Getenv("HOSTNAME"), time.Now().Unix()+int64(*startupWait),
+			"simulate", *sendThreads,
+		)
+		// Send settled so the startup metric doesn't show up as an ack
+		msg := &transport.Message{
+			Body:    []byte(startMetricContent),
+			Settled: true,
+		}
+		err := transports[0].Send(ctx, msg)
+		if err != nil {
+			log.Fatal("Sending startup AMQP message:", err)
+			return
+		}
+	}
+
+	status.setPhase(phaseWaiting)
+	sleep(ctx, time.Duration(*startupWait)*time.Second)
+	waitForStart(ctx, *startAt, *startBarrier)
+
+	ch := &chaos{
+		interval: time.Duration(*dropInterval) * time.Second,
+		messages: int64(*dropMessages),
+		trigger:  make(chan struct{}, 1),
+	}
+	var dropThreshold int64
+	if ch.interval > 0 || ch.messages > 0 {
+		waitb.Add(1)
+		go func() {
+			defer waitb.Done()
+			ch.run(sendCtx, transports, &dropThreshold)
+		}()
+	}
+
+	// routines for waiting ack...., -ackworkers of them draining the
+	// outcomes of each connection into the shared counters
+	var waitAck sync.WaitGroup
+	// the latencies of the whole run from the start of the sends to their
+	// acknowledgements
+	ackLatencies := &stats.Latencies{}
+	for i := 0; i < len(transports)*(*ackWorkers); i++ {
+		waitAck.Add(1)
+		go func(acks <-chan transport.Outcome) {
+			defer waitAck.Done()
+			bench.AckLoop(ackCtx, acks, st, func(out transport.Outcome) {
+				// with -throttle rejections slow the run down instead
+				if throttle.enabled() {
+					throttle.observe(out.Message.Created, out.Error)
+				}
+				if out.Error != nil {
+					ackErrs.observe(out)
+				} else if !out.Message.Created.IsZero() {
+					ackLatencies.Add(time.Since(out.Message.Created))
+				}
+			})
+		}(transports[i/(*ackWorkers)].Acks())
+	}
+
+	status.setPhase(phaseRunning)
+	stopReports := reports.start(ctx, st)
+	if u, err := url.Parse(urls[0]); err == nil {
+		mgmt.start(sendCtx, u.Path, time.Duration(*intervalSec)*time.Second)
+	}
+	probe.start(sendCtx, urls[0])
+	go throttle.run(sendCtx, st)
+	echo.serve(sendCtx, urls[0])
+	if *latencyLoopback {
+		inBand.loopback(sendCtx, urls[0])
+	}
+	runStart := time.Now()
+	flap.pick(hosts, runStart)
+	base = runStart.Truncate(step)
+	if *startAt != "" {
+		// replicas and reruns with the same -startat agree on the timestamps
+		base, _ = parseStartTime(*startAt)
+	}
+	close(start) // Signal to the generators that we're ready to start
+	waitb.Add(1)
+	go func() {
+		defer waitb.Done()
+		events.run(sendCtx, hosts, func(payload []byte) bool {
+			msg := bench.NewMessage(payload, !*requireAck, stormAddress)
+			if !enqueue(sendCtx, msg) {
+				return false
+			}
+			st.Generated(1)
+			return true
+		})
+	}()
+	if *agents.after > 0 {
+		waitb.Add(1)
+		go func() {
+			defer waitb.Done()
+			agents.run(sendCtx, transports, st)
+		}()
+	}
+	// sendGap is the time between the sends of each thread -spread asks
+	// for, with -pacing adaptive every send thread paces its share of the
+	// messages of an interval
+	var pacers []*pacer
+	var sendGap time.Duration
+	if *spread && perInterval > 0 && *sendThreads > 0 {
+		var ratios float64
+		for e := range entries {
+			ratios += entries[e].ratio
+		}
+		if messages := averagePerInterval * ratios; messages > 0 {
+			sendGap = time.Duration(float64(step) * float64(*sendThreads) / messages)
+		}
+	}
+	if adaptive && sendGap > 0 {
+		pacers = make([]*pacer, *sendThreads)
+	}
+	// the gaps between the consecutive sends of every thread, to check the
+	// pacing delivered is the one asked for
+	sendGaps := make([]stats.Latencies, *sendThreads)
+	for index := 0; index < *sendThreads; index++ {
+		// routine for sending mesg
+		waitb.Add(1)
+		go func(threadIndex int) {
+			defer waitb.Done()
+			t := transports[threadIndex%len(transports)]
+			lastCounted := time.Now()
+			// sendCount is the per thread count for -timepermesgs,
+			// restarted with every interval
+			sendCount := 0
+			interval := st.Intervals()
+			if pacers != nil {
+				pacers[threadIndex] = &pacer{gap: sendGap, jitter: jitter}
+			}
+			address := threadAddressOf(*threadAddress, threadIndex)
+			var lastSend time.Time
+			// sampled counts the messages of the thread for
+			// -latencysample over the whole run
+			var sampled int
+
+			for {
+				msg, ok := dequeue(sendCtx)
+				if !ok {
+					return
+				}
+				if current := st.Intervals(); current != interval {
+					interval = current
+					sendCount = 0
+				}
+				if sendCount == 0 {
+					lastCounted = time.Now()
+				}
+				sampled++
+				if *latencySample > 0 && sampled%*latencySample == 0 {
+					msg.Properties = map[string]interface{}{sentProperty: time.Now().UnixNano()}
+				}
+				if sum.enabled() {
+					msg.Properties = sum.sign(msg.Properties, msg.Body)
+				}
+				msg.Properties = run.tag(msg.Properties)
+				// nothing is sent while the agents are restarting
+				if !agents.wait(sendCtx) {
+					msg.Release()
+					return
+				}
+				// messages for the URL address go to the thread's own
+				if address != "" && msg.Address == "" {
+					msg.Address = address
+				}
+				if pacers != nil && !pacers[threadIndex].wait(sendCtx) {
+					msg.Release()
+					return
+				}
+				if !throttle.wait(sendCtx) {
+					msg.Release()
+					return
+				}
+				// the ack routine may release msg as soon as it's sent
+				settled := msg.Settled
+				sendStart := time.Now()
+				msg.Created = sendStart
+				if !lastSend.IsZero() {
+					sendGaps[threadIndex].Add(sendStart.Sub(lastSend))
+				}
+				lastSend = sendStart
+				// the payload is copied before it may be released
+				if sentCaptures != nil {
+					if n := atomic.AddInt64(&seq, 1); n%int64(*captureEvery) == 0 {
+						sentCaptures.writeSent(n, sendStart, *run.id, msg.Address, msg.Body)
+					}
+				}
+				err := bench.Send(ctx, t, msg, st, threadIndex)
+				took := time.Since(sendStart)
+				if pacers != nil {
+					pacers[threadIndex].observe(took)
+				}
+				if settled {
+					stalls.observe(took)
+				}
+				if err == nil && *syncSend {
+					roundTrips.add(took)
+					atomic.AddInt64(&roundTripTotal, int64(took))
+				}
+				ch.sent(&dropThreshold)
+				sendCount++
+				if *showTimePerMessages != -1 && sendCount == *showTimePerMessages {
+					d := time.Now().Sub(lastCounted)
+					tpm := (d.Seconds() / float64(sendCount**metricsNum)) * 1000000
+					fmt.Printf("(%d): Sent %d metrics in %v, ( %.3f uS per metric )\n", threadIndex, sendCount**metricsNum, d, tpm)
+					sendCount = 0
+				}
+			}
+		}(index)
+	}
+
+	wait.Wait()
+	status.setPhase(phaseDraining)
+	// every message queued is sent before the send threads stop
+	for ctx.Err() == nil {
+		snap := st.Snapshot()
+		if snap.Sent+snap.Failed >= atomic.LoadInt64(&queued) {
+			break
+		}
+		sleep(ctx, 10*time.Millisecond)
+	}
+	stopSend()
+	waitb.Wait()
+	stopSource()
+	// with a window the last dispositions are still on their way
+	if *requireAck && *ackWindow > 0 && ctx.Err() == nil && !bench.WaitAcks(st, ackErrs.count, st.Snapshot().Sent, 10*time.Second) {
+		log.Printf("Timed out waiting for acknowledgements")
+	}
+	stopAck()
+	waitAck.Wait()
+	status.setPhase(phaseDone)
+	if sentCaptures != nil {
+		sentCaptures.close()
+	}
+	stopReports()
+	stopBeacon()
+	if ctx.Err() != nil {
+		fmt.Println("interrupted")
+		cp.save(st, completed())
+	} else {
+		cp.finish()
+	}
+	if cp.state.Legs > 0 {
+		fmt.Printf("Checkpoint: %s\n", cp.report())
+	}
+
+	if *mix != "" {
+		for e, entry := range entries {
+			var count int64
+			for worker := range shards {
+				count += genTypes[worker][e]
+			}
+			address := entry.address
+			if address == "" {
+				address = "the URL address"
+			}
+			fmt.Printf("Generated %d %s messages to %s\n", count, entry.messageType, address)
+		}
+	}
+
+	if len(shards) > 1 {
+		for worker := range shards {
+			if *scheduler == "hosts" {
+				fmt.Printf("Generator (%d): %d messages, %v generating\n", worker, genCounts[worker], genBusy[worker])
+				continue
+			}
+			fmt.Printf("Generator (%d): %d hosts, %d messages, %v generating\n", worker, len(shards[worker]), genCounts[worker], genBusy[worker])
+		}
+	}
+
+	if series > 0 {
+		fmt.Printf("Topology: %d hosts, %d series in %d bytes (%d bytes per host, %d per series)\n",
+			len(hosts), series, topologyBytes, topologyBytes/uint64(len(hosts)), topologyBytes/uint64(series))
+	}
+	if classes != nil {
+		fmt.Printf("Host classes: %s\n", describeClasses(classes))
+	}
+
+	fmt.Printf("Runtime: %s\n", runtimeTotal())
+	fmt.Printf("Queue: generators blocked %v, send threads idle %v in total\n",
+		time.Duration(atomic.LoadInt64(&queueFull)), time.Duration(atomic.LoadInt64(&queueEmpty)))
+
+	var memEnd runtime.MemStats
+	runtime.ReadMemStats(&memEnd)
+	final := st.Snapshot()
+	totalSent := final.Sent
+	if totalSent > 0 {
+		fmt.Printf("Allocated %d bytes in %d allocations (%.1f allocs, %d bytes per message)\n",
+			memEnd.TotalAlloc-memStart.TotalAlloc, memEnd.Mallocs-memStart.Mallocs,
+			float64(memEnd.Mallocs-memStart.Mallocs)/float64(totalSent), int64(memEnd.TotalAlloc-memStart.TotalAlloc)/totalSent)
+	}
+
+	if sizes := st.Sizes(); sizes.Count > 0 {
+		fmt.Printf("Message sizes: %s\n", sizes)
+	}
+	if n := generator.NonFiniteValues(); n > 0 {
+		fmt.Printf("Value expressions: %d results weren't finite numbers and were replaced\n", n)
+	}
+	if *syncSend && roundTrips.count() > 0 {
+		fmt.Printf("Synchronous sends: %d round trips, avg %v\n", roundTrips.count(), time.Duration(roundTripTotal/roundTrips.count()))
+	}
+	if ackLatencies.Count() > 0 {
+		fmt.Printf("Ack latency: p50 %v, p90 %v, p99 %v, max %v\n", ackLatencies.Quantile(0.5), ackLatencies.Quantile(0.9),
+			ackLatencies.Quantile(0.99), ackLatencies.Quantile(1))
+	}
+	if *requireAck {
+		fmt.Printf("Ack errors: %s\n", ackErrs.report())
+	}
+	fmt.Printf("Overruns: %s\n", overrun.report())
+	timings := connectTimings(transports)
+	if len(timings) > len(transports) {
+		fmt.Printf("Connection setups with reconnects: %s\n", describeConnects(timings))
+	}
+	errs, counted := transportErrors(transports)
+	if counted {
+		fmt.Printf("Transport errors: %d link detaches, %d connection errors, %d rejections, %d recoveries (%v in total, longest %v)\n",
+			errs.LinkDetaches, errs.ConnectionErrors, errs.Rejections, errs.Recoveries, errs.Recovery, errs.LongestRecovery)
+	}
+	if !*requireAck {
+		fmt.Printf("Credit: %s\n", stalls)
+	}
+	if throttle.enabled() {
+		fmt.Printf("Throttle: %s\n", throttle.summary())
+	}
+	if pacers != nil {
+		var latency float64
+		var late int64
+		for _, p := range pacers {
+			latency += p.latency / float64(len(pacers))
+			late += p.late
+		}
+		fmt.Printf("Pacing: %v between the sends of each thread, send latency %v (moving average), %d sends behind schedule\n",
+			sendGap, time.Duration(latency), late)
+	}
+	var allGaps stats.Latencies
+	for i := range sendGaps {
+		allGaps.Merge(&sendGaps[i])
+	}
+	if allGaps.Count() > 0 {
+		fmt.Printf("Send gaps: p50 %v, p90 %v, p99 %v, max %v, mean %v between the sends of each thread",
+			allGaps.Quantile(0.5), allGaps.Quantile(0.9), allGaps.Quantile(0.99), allGaps.Quantile(1), allGaps.Mean())
+		if sendGap > 0 {
+			fmt.Printf(" (-spread asks for %v)", sendGap)
+		}
+		fmt.Println()
+	}
+	if *flap.percent > 0 {
+		fmt.Printf("Flapping: %s\n", flap.report())
+	}
+	if events.queued > 0 {
+		fmt.Printf("Storms: %s\n", events.report())
+	}
+
+	if ch.interval > 0 || ch.messages > 0 {
+		var avgDowntime time.Duration
+		if ch.drops > 0 {
+			avgDowntime = ch.downtime / time.Duration(ch.drops)
+		}
+		fmt.Printf("Connection drops: %d, total reconnect time %v (avg %v), %d sends failed\n", ch.drops, ch.downtime, avgDowntime, final.Failed)
+	}
+
+	if prom != nil && ctx.Err() == nil {
+		prom.run(ctx)
+	}
+
+	if *esURL != "" && ctx.Err() == nil {
+		var events int64
+		for e, entry := range entries {
+			if entry.messageType != "events" {
+				continue
+			}
+			for worker := range shards {
+				events += genTypes[worker][e]
+			}
+		}
+		if events > 0 {
+			es := &esCheck{url: *esURL, index: *esIndex, wait: time.Duration(*esWait) * time.Second}
+			es.run(ctx, runStart, events)
+		} else {
+			fmt.Println("No events sent, skipping the ElasticSearch check")
+		}
+	}
+
+	// deliveries in flight when the run is interrupted are given up on
+	var unsettled int64
+	if *requireAck && ctx.Err() == nil {
+		unsettled = final.Sent - final.Acked - ackErrs.count()
+	}
+	leaks, clean := shutdown.check(shutdownState{transports: transports, queued: len(mesgChan), unsettled: unsettled})
+
+	summary.counters(final, final.Sent)
+	summary.set("ack", *requireAck)
+	summary.set("run_id", *run.id)
+	summary.set("intervals", final.Intervals)
+	summary.set("hosts", len(hosts))
+	summary.set("series", series)
+	summary.set("topology_bytes", topologyBytes)
+	if sizes := st.Sizes(); sizes.Count > 0 {
+		summary.set("bytes", sizes.Bytes)
+		summary.set("message_size", map[string]interface{}{
+			"min": sizes.Min, "mean": sizes.Mean(), "p50": sizes.P50, "p90": sizes.P90, "p99": sizes.P99, "max": sizes.Max,
+		})
+	}
+	if n := generator.NonFiniteValues(); n > 0 {
+		summary.set("nonfinite_values", n)
+	}
+	summary.set("generators_blocked_seconds", time.Duration(atomic.LoadInt64(&queueFull)).Seconds())
+	summary.set("senders_idle_seconds", time.Duration(atomic.LoadInt64(&queueEmpty)).Seconds())
+	if !*requireAck {
+		summary.set("credit_blocked_seconds", time.Duration(atomic.LoadInt64(&stalls.blocked)).Seconds())
+		summary.set("credit_starved_intervals", stalls.starved)
+	}
+	if *syncSend && roundTrips.count() > 0 {
+		summary.set("round_trip_seconds", time.Duration(roundTripTotal/roundTrips.count()).Seconds())
+	}
+	if events.queued > 0 {
+		summary.set("storm_events", events.queued)
+	}
+	if ch.interval > 0 || ch.messages > 0 {
+		summary.set("connection_drops", ch.drops)
+		summary.set("reconnect_seconds", ch.downtime.Seconds())
+	}
+	if cp.state.Legs > 0 {
+		summary.set("resumed_legs", cp.state.Legs)
+	}
+	if throttle.enabled() {
+		summary.set("rejected", throttle.rejectedTotal())
+		summary.set("throttle_equilibrium_rate", throttle.equilibrium())
+	}
+	if len(timings) > 0 {
+		summary.set("connects", len(timings))
+		summary.set("connect_seconds", summarizeConnects(timings))
+	}
+	if counted {
+		summary.set("link_detaches", errs.LinkDetaches)
+		summary.set("connection_errors", errs.ConnectionErrors)
+		summary.set("rejections", errs.Rejections)
+		summary.set("recoveries", errs.Recoveries)
+		summary.set("recovery_seconds", errs.Recovery.Seconds())
+	}
+	summary.set("overruns", atomic.LoadInt64(&overrun.count))
+	summary.set("overrun_skipped", atomic.LoadInt64(&overrun.skipped))
+	summary.set("overrun_max_seconds", time.Duration(atomic.LoadInt64(&overrun.max)).Seconds())
+	summary.set("aborted", overrun.failed())
+	if ackLatencies.Count() > 0 {
+		summary.set("ack_latency_seconds", map[string]interface{}{
+			"p50": ackLatencies.Quantile(0.5).Seconds(), "p90": ackLatencies.Quantile(0.9).Seconds(),
+			"p99": ackLatencies.Quantile(0.99).Seconds(), "max": ackLatencies.Quantile(1).Seconds(),
+		})
+	}
+	if allGaps.Count() > 0 {
+		gaps := gapSummary(&allGaps)
+		if sendGap > 0 {
+			gaps["target"] = sendGap.Seconds()
+		}
+		threads := make([]map[string]interface{}, len(sendGaps))
+		for i := range sendGaps {
+			threads[i] = gapSummary(&sendGaps[i])
+		}
+		gaps["threads"] = threads
+		summary.set("send_gap_seconds", gaps)
+	}
+	if *requireAck {
+		summary.set("ack_errors", ackErrs.count())
+		summary.set("ack_error_kinds", ackErrs.kinds())
+	}
+	if *shutdown.mode != "off" {
+		summary.set("shutdown_leaks", leaks)
+	}
+	sloPass := slos.evaluate(sloResults{snap: final, ack: *requireAck, acks: ackLatencies}, summary)
+	failed := overrun.failed() || !sloPass || !clean
+	if failed {
+		summary.set("exit_status", 1)
+	}
+	summary.print(cmd.name, ctx.Err() != nil)
+	if failed {
+		exit(1)
+	}
+}
+
+// gapSummary returns the percentiles and the mean of send gaps in seconds
+func gapSummary(gaps *stats.Latencies) map[string]interface{} {
+	return map[string]interface{}{
+		"p50": gaps.Quantile(0.5).Seconds(), "p90": gaps.Quantile(0.9).Seconds(), "p99": gaps.Quantile(0.99).Seconds(),
+		"max": gaps.Quantile(1).Seconds(), "mean": gaps.Mean().Seconds(),
+	}
+}
+
+// transportErrors adds up the error counts of the transports, false if
+// none of them counts errors
+func transportErrors(transports []transport.Transport) (transport.ErrorCounts, bool) {
+	var errs transport.ErrorCounts
+	counted := false
+	for _, t := range transports {
+		if c, ok := t.(transport.ErrorCounter); ok {
+			errs = errs.Add(c.Errors())
+			counted = true
+		}
+	}
+	return errs, counted
+}
+
+// podOrdinal returns the ordinal of a StatefulSet pod, the number at the end
+// of its hostname
+func podOrdinal() (int, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return 0, err
+	}
+	i := strings.LastIndex(hostname, "-")
+	ordinal, err := strconv.Atoi(hostname[i+1:])
+	if i < 0 || err != nil {
+		return 0, fmt.Errorf("hostname %s doesn't end in a pod ordinal", hostname)
+	}
+	return ordinal, nil
+}
+
+// threadAddressOf returns the address of send thread i given -threadaddress:
+// a template with the thread number, or a list of addresses the threads are
+// assigned to round robin
+func threadAddressOf(spec string, i int) string {
+	if spec == "" {
+		return ""
+	}
+	if strings.Contains(spec, "%") {
+		return fmt.Sprintf(spec, i)
+	}
+	addresses := strings.Split(spec, ",")
+	return strings.TrimSpace(addresses[i%len(addresses)])
+}
+
+// shardHosts splits hosts into at most n contiguous shards of nearly equal
+// size, one per generator goroutine
+func shardHosts(hosts []generator.Host, n int) [][]generator.Host {
+	if n > len(hosts) {
+		n = len(hosts)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	shards := make([][]generator.Host, n)
+	for i := range shards {
+		shards[i] = hosts[i*len(hosts)/n : (i+1)*len(hosts)/n]
+	}
+	return shards
+}
@@ -0,0 +1,213 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// slo is a service level objective the results of a run are held to, such
+// as ack_p99<100ms: 99% of the messages acked within 100ms
+type slo struct {
+	text   string
+	metric string
+	op     string
+	target float64
+	// quantile of the ack_pNN objectives
+	quantile float64
+}
+
+// sloOps are the comparisons of the objectives, longest first to be
+// matched before their prefixes
+var sloOps = []string{"<=", ">=", "<", ">"}
+
+// parseSLOs parses a comma separated list of objectives on ack_pNN (the
+// ack latency percentile NN), loss (the percentage of the messages sent
+// but not acked or failed), rate (messages sent per second) and failed
+func parseSLOs(list string, ack bool) ([]slo, error) {
+	var slos []slo
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		s := slo{text: field}
+		for _, op := range sloOps {
+			if i := strings.Index(field, op); i > 0 {
+				s.metric, s.op = field[:i], op
+				field = field[i+len(op):]
+				break
+			}
+		}
+		if s.op == "" {
+			return nil, fmt.Errorf("invalid -slo %q, expecting a metric, a comparison and a target such as ack_p99<100ms", s.text)
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(s.metric, "ack_p"):
+			if !ack {
+				return nil, fmt.Errorf("-slo %s needs -ack", s.text)
+			}
+			var p float64
+			p, err = strconv.ParseFloat(strings.TrimPrefix(s.metric, "ack_p"), 64)
+			if err != nil || p <= 0 || p >= 100 {
+				return nil, fmt.Errorf("invalid percentile in -slo %q", s.text)
+			}
+			s.quantile = p / 100
+			var d time.Duration
+			d, err = time.ParseDuration(field)
+			s.target = d.Seconds()
+		case s.metric == "loss":
+			s.target, err = strconv.ParseFloat(strings.TrimSuffix(field, "%"), 64)
+		case s.metric == "rate" || s.metric == "failed":
+			s.target, err = strconv.ParseFloat(field, 64)
+		default:
+			return nil, fmt.Errorf("unknown metric %s in -slo %q, expecting ack_pNN, loss, rate or failed", s.metric, s.text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid target in -slo %q: %v", s.text, err)
+		}
+		slos = append(slos, s)
+	}
+	return slos, nil
+}
+
+// sloResults are the measurements the objectives are evaluated against
+type sloResults struct {
+	snap stats.Snapshot
+	ack  bool
+	// acks are the latencies from the start of the sends to their
+	// acknowledgements
+	acks *stats.Latencies
+}
+
+// measure returns the value of the metric of s in its unit, false if the
+// run didn't measure it
+func (s slo) measure(r sloResults) (float64, bool) {
+	switch s.metric {
+	case "loss":
+		return lossPercent(r.snap.Sent, r.snap.Failed, r.snap.Acked, r.ack)
+	case "rate":
+		if r.snap.Elapsed <= 0 {
+			return 0, false
+		}
+		return float64(r.snap.Sent) / r.snap.Elapsed.Seconds(), true
+	case "failed":
+		return float64(r.snap.Failed), true
+	}
+	if r.acks.Count() == 0 {
+		return 0, false
+	}
+	return r.acks.Quantile(s.quantile).Seconds(), true
+}
+
+// lossPercent returns the percentage of the messages sent or failed that
+// were lost: the failed ones and, when the run acks, the ones sent but not
+// acked. False if nothing was sent.
+func lossPercent(sent, failed, acked int64, ack bool) (float64, bool) {
+	attempts := sent + failed
+	if attempts == 0 {
+		return 0, false
+	}
+	lost := failed
+	if ack {
+		lost += sent - acked
+	}
+	return float64(lost) / float64(attempts) * 100, true
+}
+
+// format formats a value of the metric of s
+func (s slo) format(v float64) string {
+	switch s.metric {
+	case "loss":
+		return fmt.Sprintf("%.4g%%", v)
+	case "rate":
+		return fmt.Sprintf("%.1f msg/s", v)
+	case "failed":
+		return fmt.Sprintf("%.0f", v)
+	}
+	return time.Duration(v * float64(time.Second)).String()
+}
+
+func (s slo) met(v float64) bool {
+	switch s.op {
+	case "<":
+		return v < s.target
+	case "<=":
+		return v <= s.target
+	case ">":
+		return v > s.target
+	}
+	return v >= s.target
+}
+
+// sloCheck holds the -slo objectives of a run
+type sloCheck struct {
+	list *string
+	slos []slo
+}
+
+func addSLOFlags(fs *flag.FlagSet) *sloCheck {
+	return &sloCheck{
+		list: fs.String("slo", "", "Objectives the final report passes or fails, comma separated, e.g. ack_p99<100ms,loss<0.01%,rate>=1000"),
+	}
+}
+
+// parse parses the -slo objectives, ack tells whether the run acks
+func (c *sloCheck) parse(ack bool) error {
+	var err error
+	c.slos, err = parseSLOs(*c.list, ack)
+	return err
+}
+
+// evaluate prints a line for every objective and records them in summary,
+// returning false if any failed
+func (c *sloCheck) evaluate(r sloResults, summary *jsonSummary) bool {
+	if len(c.slos) == 0 {
+		return true
+	}
+	pass := true
+	results := make([]map[string]interface{}, len(c.slos))
+	for i, s := range c.slos {
+		v, measured := s.measure(r)
+		met := measured && s.met(v)
+		pass = pass && met
+		outcome := "FAIL"
+		if met {
+			outcome = "PASS"
+		}
+		if measured {
+			fmt.Printf("SLO %-24s %s (measured %s)\n", s.text, outcome, s.format(v))
+			results[i] = map[string]interface{}{"slo": s.text, "pass": met, "measured": v}
+		} else {
+			fmt.Printf("SLO %-24s %s (not measured)\n", s.text, outcome)
+			results[i] = map[string]interface{}{"slo": s.text, "pass": false}
+		}
+	}
+	summary.set("slos", results)
+	summary.set("slo_pass", pass)
+	return pass
+}
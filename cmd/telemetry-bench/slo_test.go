@@ -0,0 +1,135 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// TestParseSLOs checks the objectives are split into metric, comparison
+// and target, <= and >= before < and >
+func TestParseSLOs(t *testing.T) {
+	slos, err := parseSLOs(" ack_p99<=100ms, loss<0.01% ,, rate>=1000,failed>0,ack_p99.5<2s", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []slo{
+		{text: "ack_p99<=100ms", metric: "ack_p99", op: "<=", target: 0.1, quantile: 0.99},
+		{text: "loss<0.01%", metric: "loss", op: "<", target: 0.01},
+		{text: "rate>=1000", metric: "rate", op: ">=", target: 1000},
+		{text: "failed>0", metric: "failed", op: ">", target: 0},
+		{text: "ack_p99.5<2s", metric: "ack_p99.5", op: "<", target: 2, quantile: 0.995},
+	}
+	if len(slos) != len(want) {
+		t.Fatalf("parsed %+v", slos)
+	}
+	for i := range want {
+		if slos[i] != want[i] {
+			t.Errorf("slo %d: %+v, want %+v", i, slos[i], want[i])
+		}
+	}
+}
+
+// TestParseSLOErrors checks malformed objectives are rejected
+func TestParseSLOErrors(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		ack  bool
+		want string
+	}{
+		{"ack_p99<100ms", false, "needs -ack"},
+		{"ack_p0<100ms", true, "invalid percentile"},
+		{"ack_p100<100ms", true, "invalid percentile"},
+		{"ack_pxx<100ms", true, "invalid percentile"},
+		{"ack_p99<100", true, "invalid target"},
+		{"loss<lots", true, "invalid target"},
+		{"rate=1000", true, "expecting a metric, a comparison and a target"},
+		{"<1000", true, "expecting a metric, a comparison and a target"},
+		{"latency<1s", true, "unknown metric latency"},
+	} {
+		_, err := parseSLOs(tc.list, tc.ack)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.list, err, tc.want)
+		}
+	}
+}
+
+// TestSLOMet checks every comparison, at and around the target
+func TestSLOMet(t *testing.T) {
+	for _, tc := range []struct {
+		op                string
+		below, at, beyond bool
+	}{
+		{"<", true, false, false},
+		{"<=", true, true, false},
+		{">", false, false, true},
+		{">=", false, true, true},
+	} {
+		s := slo{op: tc.op, target: 10}
+		if s.met(9) != tc.below || s.met(10) != tc.at || s.met(11) != tc.beyond {
+			t.Errorf("%s 10: met 9 %v, 10 %v, 11 %v", tc.op, s.met(9), s.met(10), s.met(11))
+		}
+	}
+}
+
+// TestSLOMeasure checks the metrics are measured from the results, the
+// loss counting the unacked messages only when the run acks
+func TestSLOMeasure(t *testing.T) {
+	acks := &stats.Latencies{}
+	for i := 0; i < 100; i++ {
+		acks.Add(10 * time.Millisecond)
+	}
+	snap := stats.Snapshot{Sent: 90, Failed: 10, Acked: 60, Elapsed: 10 * time.Second}
+	for _, tc := range []struct {
+		metric   string
+		r        sloResults
+		want     float64
+		measured bool
+	}{
+		{"loss", sloResults{snap: snap, ack: true}, 40, true},
+		{"loss", sloResults{snap: snap}, 10, true},
+		{"loss", sloResults{snap: stats.Snapshot{Elapsed: time.Second}, ack: true}, 0, false},
+		{"rate", sloResults{snap: snap}, 9, true},
+		{"rate", sloResults{}, 0, false},
+		{"failed", sloResults{snap: snap}, 10, true},
+		{"ack_p99", sloResults{snap: snap, ack: true, acks: &stats.Latencies{}}, 0, false},
+	} {
+		v, ok := slo{metric: tc.metric}.measure(tc.r)
+		if v != tc.want || ok != tc.measured {
+			t.Errorf("%s of %+v: %v (%v), want %v (%v)", tc.metric, tc.r.snap, v, ok, tc.want, tc.measured)
+		}
+	}
+
+	slos, err := parseSLOs("ack_p99<100ms,ack_p50<1ms", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := sloResults{snap: snap, ack: true, acks: acks}
+	for i, met := range []bool{true, false} {
+		v, ok := slos[i].measure(r)
+		if !ok || slos[i].met(v) != met {
+			t.Errorf("%s measured %v (%v), met %v", slos[i].text, v, ok, slos[i].met(v))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseStartTime parses a -startat value, either RFC3339 or seconds since
+// the epoch
+func parseStartTime(at string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(at, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, at)
+}
+
+// waitForStart holds the run back until the -startat wall-clock time and
+// until the -startbarrier URL answers 200 OK, so a fleet of replicas starts
+// at once. It returns false if ctx is cancelled meanwhile.
+func waitForStart(ctx context.Context, at, barrier string) bool {
+	if barrier != "" {
+		fmt.Printf("Waiting for start barrier %s\n", barrier)
+		for {
+			resp, err := http.Get(barrier)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					break
+				}
+			}
+			if !sleep(ctx, time.Second) {
+				return false
+			}
+		}
+	}
+
+	if at != "" {
+		startAt, err := parseStartTime(at)
+		if err != nil {
+			log.Fatal("Parsing -startat:", err)
+		}
+		d := time.Until(startAt)
+		if d < 0 {
+			log.Printf("Start time %v already passed %v ago, starting now", startAt, -d)
+			return ctx.Err() == nil
+		}
+		fmt.Printf("Starting at %v (in %v)\n", startAt, d)
+		return sleep(ctx, d)
+	}
+	return ctx.Err() == nil
+}
@@ -0,0 +1,151 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// Run phases reported by /status. Only a running bench is ready.
+const (
+	phaseStarting = "starting"
+	phaseWaiting  = "waiting"
+	phaseRunning  = "running"
+	phaseDraining = "draining"
+	phaseDone     = "done"
+)
+
+// runStatus backs the health and status endpoints served next to pprof,
+// so Jobs and Deployments running the bench can be probed and monitored
+type runStatus struct {
+	sync.Mutex
+	phase  string
+	run    string
+	labels runLabels
+	st     *stats.Stats
+	// last and prev are sampled every second for the current rates
+	last, prev stats.Snapshot
+}
+
+var status = &runStatus{phase: phaseStarting}
+
+func init() {
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	http.HandleFunc("/readyz", status.serveReady)
+	http.HandleFunc("/status", status.serveStatus)
+}
+
+// setRun sets the ID and the labels of the run reported
+func (s *runStatus) setRun(run string, labels runLabels) {
+	s.Lock()
+	s.run, s.labels = run, labels
+	s.Unlock()
+}
+
+func (s *runStatus) setPhase(phase string) {
+	s.Lock()
+	s.phase = phase
+	s.Unlock()
+}
+
+// track starts sampling st for the current rates, until the run is done
+func (s *runStatus) track(st *stats.Stats) {
+	s.Lock()
+	s.st = st
+	s.last = st.Snapshot()
+	s.prev = s.last
+	s.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			snap := st.Snapshot()
+			s.Lock()
+			s.prev, s.last = s.last, snap
+			done := s.phase == phaseDone
+			s.Unlock()
+			if done {
+				return
+			}
+		}
+	}()
+}
+
+func (s *runStatus) serveReady(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	phase := s.phase
+	s.Unlock()
+	if phase != phaseRunning {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(phase + "\n"))
+}
+
+// statusReport is the current status of the run, as served by /status
+type statusReport struct {
+	RunID       string            `json:"run_id,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Phase       string            `json:"phase"`
+	Elapsed     float64           `json:"elapsed_seconds"`
+	Intervals   int64             `json:"intervals"`
+	Generated   int64             `json:"generated"`
+	Sent        int64             `json:"sent"`
+	Failed      int64             `json:"failed"`
+	Acked       int64             `json:"acked"`
+	Received    int64             `json:"received"`
+	SendRate    float64           `json:"send_rate"`
+	AckRate     float64           `json:"ack_rate"`
+	ReceiveRate float64           `json:"receive_rate"`
+}
+
+func (s *runStatus) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.report())
+}
+
+// report returns the current status
+func (s *runStatus) report() statusReport {
+	s.Lock()
+	defer s.Unlock()
+
+	resp := statusReport{RunID: s.run, Labels: s.labels, Phase: s.phase}
+	if s.st != nil {
+		snap := s.st.Snapshot()
+		resp.Elapsed = snap.Elapsed.Seconds()
+		resp.Intervals = snap.Intervals
+		resp.Generated = snap.Generated
+		resp.Sent = snap.Sent
+		resp.Failed = snap.Failed
+		resp.Acked = snap.Acked
+		resp.Received = snap.Received
+		resp.SendRate = s.last.SendRate(s.prev)
+		resp.AckRate = s.last.AckRate(s.prev)
+		resp.ReceiveRate = s.last.ReceiveRate(s.prev)
+	}
+	return resp
+}
@@ -0,0 +1,147 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/generator"
+)
+
+// storm emits a burst of FAILURE events from every host at once, like a
+// site-wide outage, on a timer or when POST /storm is called on the
+// -profenable endpoint
+type storm struct {
+	at       *int
+	events   *int
+	duration *int
+
+	trigger chan int
+	queued  int64
+	storms  int64
+}
+
+func addStormFlags(fs *flag.FlagSet) *storm {
+	return &storm{
+		at:       fs.Int("stormat", 0, "Seconds into the run to emit an event storm (0 for none, POST /storm triggers one too)"),
+		events:   fs.Int("stormevents", 100000, "FAILURE events in an event storm"),
+		duration: fs.Int("stormduration", 0, "Seconds an event storm is spread over (0 for as fast as possible)"),
+		trigger:  make(chan int, 1),
+	}
+}
+
+// serve triggers a storm of the events query parameter, or -stormevents,
+// events
+func (s *storm) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST to start an event storm", http.StatusMethodNotAllowed)
+		return
+	}
+	n := *s.events
+	if v := r.URL.Query().Get("events"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			http.Error(w, "invalid events count", http.StatusBadRequest)
+			return
+		}
+	}
+	select {
+	case s.trigger <- n:
+		fmt.Fprintf(w, "storm of %d events started\n", n)
+	default:
+		http.Error(w, "a storm is already pending", http.StatusConflict)
+	}
+}
+
+// run emits the storms until ctx is done, queueing every event payload
+// with queue. queue returns false once the run is over.
+func (s *storm) run(ctx context.Context, hosts []generator.Host, queue func([]byte) bool) {
+	var timer <-chan time.Time
+	if *s.at > 0 {
+		timer = time.After(time.Duration(*s.at) * time.Second)
+	}
+	for {
+		n := *s.events
+		select {
+		case <-timer:
+		case n = <-s.trigger:
+		case <-ctx.Done():
+			return
+		}
+		start := time.Now()
+		fmt.Printf("Event storm of %d events\n", n)
+		if !s.burst(ctx, hosts, n, queue) {
+			return
+		}
+		atomic.AddInt64(&s.storms, 1)
+		fmt.Printf("Event storm queued in %v\n", time.Since(start))
+	}
+}
+
+// burst queues n FAILURE events, cycling over the hosts and their plugins
+func (s *storm) burst(ctx context.Context, hosts []generator.Host, n int, queue func([]byte) bool) bool {
+	if len(hosts) == 0 {
+		log.Print("No hosts to emit an event storm from")
+		return true
+	}
+	// with a duration, the events go out in 10ms batches
+	batch := n
+	tick := 10 * time.Millisecond
+	if *s.duration > 0 {
+		batch = int(int64(n) * int64(tick) / int64(time.Duration(*s.duration)*time.Second))
+		if batch < 1 {
+			batch = 1
+		}
+	}
+	queued := 0
+	for h := 0; queued < n; h = (h + 1) % len(hosts) {
+		for j := range hosts[h].Plugins {
+			ok := true
+			now := time.Now()
+			hosts[h].Plugins[j].EachEventMessageSeverity(now, "FAILURE", func(payload []byte) bool {
+				ok = queue(payload)
+				queued++
+				atomic.AddInt64(&s.queued, 1)
+				return ok && queued < n && queued%batch != 0
+			})
+			if !ok {
+				return false
+			}
+			if queued >= n {
+				return true
+			}
+			if queued%batch == 0 && *s.duration > 0 && !sleep(ctx, tick) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *storm) report() string {
+	return fmt.Sprintf("%d event storms, %d events", atomic.LoadInt64(&s.storms), atomic.LoadInt64(&s.queued))
+}
@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// jsonSummary collects the key results of a run and prints them as one JSON
+// line at exit, for wrapper scripts to parse instead of the text output. It
+// can also be posted to a webhook, for orchestration to be notified of the
+// end of the run without polling its logs.
+type jsonSummary struct {
+	enabled    *bool
+	onComplete *string
+	labels     runLabels
+	fields     map[string]interface{}
+}
+
+func addSummaryFlags(fs *flag.FlagSet) *jsonSummary {
+	s := &jsonSummary{
+		enabled:    fs.Bool("summary-json", false, "Print the results as a single JSON line on stdout at exit"),
+		onComplete: fs.String("on-complete-url", "", "POST the JSON results and exit status to this URL when the run finishes or aborts"),
+		labels:     runLabels{},
+		fields:     map[string]interface{}{},
+	}
+	fs.Var(s.labels, "label", "Label the results with key=value, e.g. build=1234 (repeatable or comma separated)")
+	return s
+}
+
+// set records a result under key
+func (s *jsonSummary) set(key string, value interface{}) {
+	s.fields[key] = value
+}
+
+// counters records the counters of snap shared by all the commands, and
+// the number of messages per second made of count
+func (s *jsonSummary) counters(snap stats.Snapshot, count int64) {
+	s.set("elapsed_seconds", snap.Elapsed.Seconds())
+	s.set("generated", snap.Generated)
+	s.set("sent", snap.Sent)
+	s.set("failed", snap.Failed)
+	s.set("acked", snap.Acked)
+	s.set("received", snap.Received)
+	if snap.Elapsed > 0 {
+		s.set("rate", float64(count)/snap.Elapsed.Seconds())
+	}
+}
+
+// print writes the summary line if -summary-json is set and posts it to
+// the -on-complete-url. The exit status is 0 unless set first.
+func (s *jsonSummary) print(command string, interrupted bool) {
+	if !*s.enabled && *s.onComplete == "" {
+		return
+	}
+	s.set("command", command)
+	s.set("version", version)
+	s.set("interrupted", interrupted)
+	if len(s.labels) > 0 {
+		s.set("labels", s.labels)
+	}
+	if _, ok := s.fields["exit_status"]; !ok {
+		s.set("exit_status", 0)
+	}
+	line, err := json.Marshal(s.fields)
+	if err != nil {
+		log.Fatal("Encoding the JSON summary:", err)
+	}
+	if *s.enabled {
+		fmt.Printf("%s\n", line)
+	}
+	if *s.onComplete != "" {
+		s.post(line)
+	}
+}
+
+// post sends the summary line to the -on-complete-url. A failure is only
+// logged, the run itself is over.
+func (s *jsonSummary) post(line []byte) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(*s.onComplete, "application/json", bytes.NewReader(line))
+	if err != nil {
+		log.Printf("Posting the results to -on-complete-url: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Posting the results to -on-complete-url: %s", resp.Status)
+	}
+}
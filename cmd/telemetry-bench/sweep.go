@@ -0,0 +1,213 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// sweepParam is one axis of the grid, a send option and its values
+type sweepParam struct {
+	name   string
+	values []string
+}
+
+// parseGrid parses a grid such as threads=1,2,4;metrics=1,10
+func parseGrid(grid string) ([]sweepParam, error) {
+	var params []sweepParam
+	for _, field := range strings.Split(grid, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid -grid parameter %q, expecting option=value,value", field)
+		}
+		p := sweepParam{name: strings.TrimPrefix(kv[0], "-")}
+		for _, v := range strings.Split(kv[1], ",") {
+			p.values = append(p.values, strings.TrimSpace(v))
+		}
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("empty -grid")
+	}
+	return params, nil
+}
+
+// combinations returns every combination of the values of params, the last
+// parameter varying fastest
+func combinations(params []sweepParam) [][]string {
+	combos := [][]string{nil}
+	for _, p := range params {
+		var next [][]string
+		for _, c := range combos {
+			for _, v := range p.values {
+				next = append(next, append(append([]string(nil), c...), v))
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+func runSweep(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	grid := fs.String("grid", "", "Send options and their values to run every combination of, e.g. threads=1,2,4;metrics=1,10")
+	trial := fs.Int("trial", 10, "Seconds each combination sends for")
+	summary := addSummaryFlags(fs)
+
+	cmd.parse(fs, args)
+	params, err := parseGrid(*grid)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sendArgs := fs.Args()
+	if len(sendArgs) == 0 {
+		fmt.Fprintln(os.Stderr, "send options and amqp URL are missing")
+		fs.Usage()
+		os.Exit(1)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal("Locating the telemetry-bench executable:", err)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	combos := combinations(params)
+	var results []map[string]interface{}
+	for i, combo := range combos {
+		if ctx.Err() != nil {
+			break
+		}
+		// the combination goes after the send options so it wins, and
+		// the URLs go last
+		trialArgs := []string{"send", "-send", "-1", "-startupwait", "0"}
+		trialArgs = append(trialArgs, sendArgs[:len(sendArgs)-countURLs(sendArgs)]...)
+		var described []string
+		for k, p := range params {
+			trialArgs = append(trialArgs, "-"+p.name+"="+combo[k])
+			described = append(described, p.name+"="+combo[k])
+		}
+		trialArgs = append(trialArgs, "-summary-json")
+		trialArgs = append(trialArgs, sendArgs[len(sendArgs)-countURLs(sendArgs):]...)
+
+		fmt.Printf("Trial %d/%d: %s\n", i+1, len(combos), strings.Join(described, " "))
+		res, err := runTrial(ctx, exe, trialArgs, time.Duration(*trial)*time.Second)
+		if err != nil {
+			log.Printf("Trial %s: %v", strings.Join(described, " "), err)
+		}
+		results = append(results, res)
+	}
+
+	for _, p := range params {
+		fmt.Printf("%14s ", p.name)
+	}
+	fmt.Printf("%14s %12s %10s %12s\n", "msg/sec", "sent", "failed", "acked")
+	sweep := make([]map[string]interface{}, len(results))
+	for i, res := range results {
+		values := map[string]string{}
+		for k, p := range params {
+			values[p.name] = combos[i][k]
+			fmt.Printf("%14s ", combos[i][k])
+		}
+		if res == nil {
+			fmt.Printf("%14s\n", "failed")
+			sweep[i] = map[string]interface{}{"params": values}
+			continue
+		}
+		rate, _ := res["rate"].(float64)
+		sent, _ := res["sent"].(float64)
+		failed, _ := res["failed"].(float64)
+		acked, _ := res["acked"].(float64)
+		fmt.Printf("%14.1f %12.0f %10.0f %12.0f\n", rate, sent, failed, acked)
+		sweep[i] = map[string]interface{}{"params": values, "results": res}
+	}
+	summary.set("trial_seconds", *trial)
+	summary.set("sweep", sweep)
+	summary.print(cmd.name, ctx.Err() != nil)
+}
+
+// countURLs returns the number of URL arguments at the end of args
+func countURLs(args []string) int {
+	n := 0
+	for i := len(args) - 1; i >= 0 && strings.Contains(args[i], "://"); i-- {
+		n++
+	}
+	return n
+}
+
+// runTrial runs the send command of args for d, interrupting it then,
+// and returns the JSON summary it prints last
+func runTrial(ctx context.Context, exe string, args []string, d time.Duration) (map[string]interface{}, error) {
+	var out bytes.Buffer
+	trial := exec.Command(exe, args...)
+	trial.Stdout = &out
+	trial.Stderr = os.Stderr
+	if err := trial.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- trial.Wait() }()
+
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(d):
+		trial.Process.Signal(os.Interrupt)
+		err = <-exited
+	case <-ctx.Done():
+		trial.Process.Signal(os.Interrupt)
+		err = <-exited
+	}
+
+	var last string
+	scanner := bufio.NewScanner(&out)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "{") {
+			last = line
+		}
+	}
+	if last == "" {
+		if err == nil {
+			err = fmt.Errorf("no summary")
+		}
+		return nil, err
+	}
+	var res map[string]interface{}
+	if jsonErr := json.Unmarshal([]byte(last), &res); jsonErr != nil {
+		return nil, jsonErr
+	}
+	// send exits non-zero when it aborts, with the results so far
+	return res, err
+}
@@ -0,0 +1,198 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/stats"
+)
+
+// throttle slows the send threads down the way a well behaved producer
+// backs off under flow control: the rate is halved when the broker rejects
+// deliveries or the ack latency spikes, and grows by a step every second it
+// doesn't (AIMD). The run starts unlimited and the first back-off halves
+// the rate observed.
+type throttle struct {
+	enable  *bool
+	latency *int
+	step    *float64
+
+	sync.Mutex
+	// rate is the current limit in messages per second, 0 for none
+	rate float64
+	next time.Time
+	// the rejections and acks of the current second, and their latency
+	rejected int64
+	acks     int64
+	ackTotal time.Duration
+	// backoffs counts the halvings by cause
+	backoffs, slow, rejections int64
+	// total counts the rejected messages of the run
+	total int64
+	// since is the first back-off and area the integral of the rate from
+	// then until the last adjustment, for the equilibrium
+	since, until time.Time
+	area         float64
+}
+
+func addThrottleFlags(fs *flag.FlagSet) *throttle {
+	return &throttle{
+		enable:  fs.Bool("throttle", false, "With -ack, halve the send rate when deliveries are rejected or the ack latency spikes, and raise it again step by step while they aren't (AIMD)"),
+		latency: fs.Int("throttlelatency", 100, "Average ack latency in milliseconds over a second that -throttle backs off above"),
+		step:    fs.Float64("throttlestep", 100, "Messages per second -throttle raises the send rate by every second without congestion"),
+	}
+}
+
+func (t *throttle) enabled() bool {
+	return *t.enable
+}
+
+// wait holds a send thread to the current rate, shared by all of them,
+// returning false if ctx is done
+func (t *throttle) wait(ctx context.Context) bool {
+	if !t.enabled() {
+		return true
+	}
+	t.Lock()
+	if t.rate == 0 {
+		t.Unlock()
+		return ctx.Err() == nil
+	}
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	d := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(float64(time.Second) / t.rate))
+	t.Unlock()
+	if d > spreadSlack {
+		return sleep(ctx, d)
+	}
+	return ctx.Err() == nil
+}
+
+// observe accounts for the outcome of an unsettled message created at
+// created
+func (t *throttle) observe(created time.Time, err error) {
+	t.Lock()
+	defer t.Unlock()
+	if err != nil {
+		t.rejected++
+		t.total++
+		return
+	}
+	t.acks++
+	t.ackTotal += time.Since(created)
+}
+
+// run adjusts the rate every second until ctx is done
+func (t *throttle) run(ctx context.Context, st *stats.Stats) {
+	if !t.enabled() {
+		return
+	}
+	limit := time.Duration(*t.latency) * time.Millisecond
+	prev := st.Snapshot()
+	for sleep(ctx, time.Second) {
+		snap := st.Snapshot()
+		t.Lock()
+		if !t.since.IsZero() {
+			t.area += t.rate * snap.Time.Sub(prev.Time).Seconds()
+			t.until = snap.Time
+		}
+		slow := t.acks > 0 && t.ackTotal/time.Duration(t.acks) > limit
+		if t.rejected > 0 || slow {
+			if t.rate == 0 {
+				t.rate = snap.SendRate(prev)
+				t.since = snap.Time
+			}
+			t.rate /= 2
+			t.backoffs++
+			if slow {
+				t.slow++
+			} else {
+				t.rejections++
+			}
+		} else if t.rate > 0 {
+			t.rate += *t.step
+		}
+		if t.rate > 0 && t.rate < 1 {
+			t.rate = 1
+		}
+		t.rejected, t.acks, t.ackTotal = 0, 0, 0
+		t.Unlock()
+		prev = snap
+	}
+}
+
+// equilibrium returns the average rate since the first back-off, the rate
+// the throttling settled at, or 0 if it never backed off
+func (t *throttle) equilibrium() float64 {
+	t.Lock()
+	defer t.Unlock()
+	if t.since.IsZero() {
+		return 0
+	}
+	elapsed := t.until.Sub(t.since).Seconds()
+	if elapsed <= 0 {
+		return t.rate
+	}
+	return t.area / elapsed
+}
+
+// rejectedTotal returns the number of messages rejected in the run
+func (t *throttle) rejectedTotal() int64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.total
+}
+
+// report returns the current rate
+func (t *throttle) report() string {
+	t.Lock()
+	defer t.Unlock()
+	if t.rate == 0 {
+		return "throttle off"
+	}
+	return fmt.Sprintf("throttle %.0f/s", t.rate)
+}
+
+// summary returns the back-offs and the equilibrium rate
+func (t *throttle) summary() string {
+	eq := t.equilibrium()
+	t.Lock()
+	defer t.Unlock()
+	if t.backoffs == 0 {
+		return "never backed off"
+	}
+	return fmt.Sprintf("%d back-offs (%d rejecting, %d slow acks), equilibrium %.1f msg/sec", t.backoffs, t.rejections, t.slow, eq)
+}
+
+// check validates the throttle options
+func (t *throttle) check(requireAck bool) {
+	if t.enabled() && !requireAck {
+		log.Fatal("-throttle needs -ack")
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"github.com/infrawatch/telemetry-bench/transport"
+	"pack.ag/amqp"
+)
+
+// validation checks the hops of the path of a small batch one by one, and
+// prints a line for each
+type validation struct {
+	failed bool
+}
+
+// hop prints the outcome of one hop
+func (v *validation) hop(name string, ok bool, format string, args ...interface{}) {
+	outcome := "OK"
+	if !ok {
+		outcome = "FAIL"
+		v.failed = true
+	}
+	fmt.Printf("%-5s %-10s %s\n", outcome, name, fmt.Sprintf(format, args...))
+}
+
+func runValidate(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	count := fs.Int("count", 10, "Number of tagged metrics to send")
+	receiveURL := fs.String("receiveurl", "", "AMQP URL to receive the batch from, e.g. the address behind the router (default the URL sent to, empty with -promurl only)")
+	promURL := fs.String("promurl", "", "Prometheus URL to look the batch up in")
+	promName := fs.String("promname", "collectd_{plugin}_{type}_{dsname}_total", "Prometheus metric name of a collectd data source, with {plugin}, {type} and {dsname} replaced")
+	timeout := fs.Int("timeout", 30, "Seconds to wait for the batch at each hop")
+	dial := addDialFlags(fs)
+
+	cmd.parse(fs, args)
+	urls := cmd.urls(fs, 1)
+	if *receiveURL == "" && *promURL == "" {
+		*receiveURL = urls[0]
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	wait := time.Duration(*timeout) * time.Second
+
+	// every run tags its batch with a host name of its own, so earlier
+	// runs and other traffic on the address are told apart
+	tag := fmt.Sprintf("telemetry-bench-validate-%08x", rand.New(rand.NewSource(time.Now().UnixNano())).Uint32())
+	fmt.Printf("Validating %s with %d metrics from host %s\n", urls[0], *count, tag)
+	v := &validation{}
+
+	// the receiver attaches before anything is sent, not to miss any
+	var receiver *amqp.Receiver
+	if *receiveURL != "" {
+		var client *amqp.Client
+		var err error
+		client, receiver, err = attachReceiver(*receiveURL)
+		v.hop("attach", err == nil, "%s%v", *receiveURL, errOrNil(err))
+		if err != nil {
+			exit(1)
+		}
+		defer client.Close()
+	}
+
+	start := time.Now()
+	t, err := transport.New(urls[0], dial.config(transport.Config{AckBuffer: *count}))
+	if err == nil {
+		connectCtx, stop := context.WithTimeout(ctx, wait)
+		err = t.Connect(connectCtx)
+		stop()
+	}
+	v.hop("connect", err == nil, "%s in %v%v", urls[0], time.Since(start), errOrNil(err))
+	if err != nil {
+		exit(1)
+	}
+	defer t.Close()
+
+	start = time.Now()
+	sent := make([]collectdMetric, *count)
+	accepted, rejected := 0, 0
+	sendCtx, stop := context.WithTimeout(ctx, wait)
+	for i := range sent {
+		sent[i] = collectdMetric{
+			Values: []float64{float64(i)}, DSNames: []string{"value"}, Time: float64(time.Now().Unix()),
+			Host: tag, Plugin: "telemetry_bench", PluginInstance: "validate", Type: "validate", TypeInstance: fmt.Sprint(i),
+		}
+		if err = t.Send(sendCtx, &transport.Message{Body: validationPayload(sent[i])}); err != nil {
+			break
+		}
+		select {
+		case out := <-t.Acks():
+			if out.Error != nil {
+				rejected++
+				err = out.Error
+			} else {
+				accepted++
+			}
+		case <-sendCtx.Done():
+			err = sendCtx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	stop()
+	v.hop("send", accepted == *count, "%d/%d accepted, %d rejected in %v%v", accepted, *count, rejected, time.Since(start), errOrNil(err))
+	if accepted == 0 {
+		exit(1)
+	}
+
+	if receiver != nil {
+		got, elapsed := receiveTagged(ctx, receiver, tag, accepted, wait)
+		v.hop("receive", got == accepted, "%d/%d from %s in %v", got, accepted, *receiveURL, elapsed)
+	}
+
+	if *promURL != "" {
+		check := &promCheck{url: *promURL, name: *promName, sent: sent[:accepted]}
+		start = time.Now()
+		deadline := start.Add(wait)
+		for {
+			r := check.check(ctx)
+			if r.matched == accepted || !time.Now().Before(deadline) || ctx.Err() != nil {
+				v.hop("prometheus", r.matched == accepted, "%d/%d stored in %s after %v, %d wrong value, %d wrong timestamp%v",
+					r.matched, accepted, *promURL, time.Since(start), r.wrongValue, r.wrongTime, errOrNil(r.err))
+				for _, e := range r.examples {
+					fmt.Printf("    %s\n", e)
+				}
+				break
+			}
+			sleep(ctx, time.Second)
+		}
+	}
+
+	if v.failed {
+		exit(1)
+	}
+}
+
+// errOrNil formats err for a hop line, with a leading space, empty if nil
+func errOrNil(err error) string {
+	if err == nil {
+		return ""
+	}
+	return " (" + err.Error() + ")"
+}
+
+// validationPayload renders m as a collectd message
+func validationPayload(m collectdMetric) []byte {
+	payload, err := json.Marshal([]map[string]interface{}{{
+		"values": m.Values, "dstypes": []string{"derive"}, "dsnames": m.DSNames, "time": m.Time, "interval": 1,
+		"host": m.Host, "plugin": m.Plugin, "plugin_instance": m.PluginInstance, "type": m.Type, "type_instance": m.TypeInstance,
+	}})
+	if err != nil {
+		log.Fatal("Encoding the validation metric:", err)
+	}
+	return payload
+}
+
+// attachReceiver opens a receiver link on the address of rawurl, on a
+// connection of its own the caller closes
+func attachReceiver(rawurl string) (*amqp.Client, *amqp.Receiver, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Dialing AMQP server: %v", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("Creating AMQP session: %v", err)
+	}
+	receiver, err := session.NewReceiver(amqp.LinkSourceAddress(u.Path), amqp.LinkCredit(100))
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("Creating receiver link: %v", err)
+	}
+	return client, receiver, nil
+}
+
+// receiveTagged receives until want metrics of host tag arrived or wait
+// expires. It accepts the messages of the tag and releases any other, for
+// the consumer they were meant for.
+func receiveTagged(ctx context.Context, receiver *amqp.Receiver, tag string, want int, wait time.Duration) (int, time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	got := 0
+	for got < want {
+		msg, err := receiver.Receive(ctx)
+		if err != nil {
+			break
+		}
+		var metrics []collectdMetric
+		json.Unmarshal(msg.GetData(), &metrics)
+		tagged := 0
+		for _, m := range metrics {
+			if m.Host == tag {
+				tagged++
+			}
+		}
+		if tagged == 0 {
+			msg.Release()
+			continue
+		}
+		msg.Accept()
+		got += tagged
+	}
+	return got, time.Since(start)
+}
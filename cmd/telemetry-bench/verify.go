@@ -0,0 +1,136 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+// collectdMetric holds the fields of a collectd JSON sample needed to
+// recognise the telemetry_bench startup metric and to look samples up in a
+// TSDB
+type collectdMetric struct {
+	Values         []float64 `json:"values"`
+	DSNames        []string  `json:"dsnames"`
+	Time           float64   `json:"time"`
+	Host           string    `json:"host"`
+	Plugin         string    `json:"plugin"`
+	PluginInstance string    `json:"plugin_instance"`
+	Type           string    `json:"type"`
+	TypeInstance   string    `json:"type_instance"`
+}
+
+func runVerify(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	idle := fs.Int("idle", 10, "Seconds without messages after which the run is considered complete")
+	expect := fs.Int("expect", 0, "Expected number of metrics (default taken from the telemetry_bench startup metric)")
+	credit := fs.Int("credit", 256, "Link credit granted by the receiver")
+
+	cmd.parse(fs, args)
+	urls := cmd.urls(fs, 1)
+
+	u, err := url.Parse(urls[0])
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	client, err := amqp.Dial(u.Scheme + "://" + u.Host)
+	if err != nil {
+		log.Fatal("Dialing AMQP server:", err)
+		return
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		log.Fatal("Creating AMQP session:", err)
+		return
+	}
+
+	receiver, err := session.NewReceiver(
+		amqp.LinkSourceAddress(u.Path),
+		amqp.LinkCredit(uint32(*credit)),
+	)
+	if err != nil {
+		log.Fatal("Creating receiver link:", err)
+		return
+	}
+
+	fmt.Printf("Verifying messages from %s, stopping after %d second(s) idle\n", u.Path, *idle)
+
+	runCtx, stop := signalContext()
+	defer stop()
+
+	expected := int64(*expect)
+	var received, malformed int64
+	for {
+		// Wait for the first message indefinitely, the sender may not
+		// have started yet
+		ctx := runCtx
+		cancel := func() {}
+		if received > 0 || expected > 0 {
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(*idle)*time.Second)
+		}
+		msg, err := receiver.Receive(ctx)
+		cancel()
+		if err == context.DeadlineExceeded || runCtx.Err() != nil {
+			break
+		} else if err != nil {
+			log.Fatal("Reading message from AMQP:", err)
+			return
+		}
+		msg.Accept()
+
+		var metrics []collectdMetric
+		if err := json.Unmarshal(msg.GetData(), &metrics); err != nil {
+			malformed++
+			continue
+		}
+		for _, m := range metrics {
+			if m.Plugin == "telemetry_bench" && len(m.Values) >= 2 && len(m.DSNames) > 0 && m.DSNames[0] == "expected_metrics_per_interval" {
+				if expected == 0 && m.Values[1] > 0 {
+					expected = int64(m.Values[0] * m.Values[1])
+					fmt.Printf("Startup metric: %d metrics per interval, %d intervals\n", int64(m.Values[0]), int64(m.Values[1]))
+				}
+				continue
+			}
+			received++
+		}
+	}
+
+	fmt.Printf("Received %d metrics, %d malformed messages\n", received, malformed)
+	if expected == 0 {
+		fmt.Println("No expected count, send with -startmetricenable and a finite -send or use -expect")
+		exit(1)
+	}
+	loss := float64(expected-received) / float64(expected) * 100
+	fmt.Printf("Expected %d metrics, missing %d (%.3f%% loss)\n", expected, expected-received, loss)
+	if received != expected || malformed > 0 {
+		exit(1)
+	}
+}
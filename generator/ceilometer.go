@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// EachCeilometerMessage renders the plugin's series as ceilometer metering
+// samples the way the ceilometer AMQP publisher sends them: an oslo.messaging
+// v2 envelope with the notification itself encoded as a JSON string. Like
+// EachMetricMessage, the payload is only valid until fn returns.
+func (m *Plugin) EachCeilometerMessage(fn func(payload []byte) bool) {
+	m.EachCeilometerMessageAt(time.Now(), fn)
+}
+
+// EachCeilometerMessageAt renders the metering samples with the timestamp t.
+// The message IDs derive from the series and t, so samples rendered with the
+// same timestamp are duplicates of each other, as far as dedup is concerned.
+func (m *Plugin) EachCeilometerMessageAt(t time.Time, fn func(payload []byte) bool) {
+	timestamp := t.UTC().Format("2006-01-02T15:04:05.000000")
+
+	inner := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(inner)
+	sb := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(sb)
+
+	var scratch [32]byte
+	series := 0
+	for _, mtype := range m.mtype {
+		for p := range m.pluginInstance {
+			pluginInstance := m.instanceName(p, t)
+			for _, typeInstance := range m.typeInstance {
+				// the series are numbered like the metric templates
+				key := m.seriesKey(series, 0)
+				value := m.seriesValues(series)[0]
+				series++
+				inner.Reset()
+				inner.WriteString(`{"message_id": "`)
+				inner.Write(strconv.AppendUint(scratch[:0], mix64(key^uint64(t.UnixNano())), 16))
+				inner.WriteString(`", "publisher_id": "telemetry.publisher.`)
+				inner.WriteString(*m.hostname)
+				inner.WriteString(`", "event_type": "metering", "priority": "SAMPLE", "payload": [{"source": "openstack", "counter_name": "`)
+				inner.WriteString(m.name)
+				inner.WriteString(".")
+				inner.WriteString(mtype)
+				inner.WriteString(`", "counter_type": "gauge", "counter_unit": "`)
+				inner.WriteString(m.dsnames[0])
+				inner.WriteString(`", "counter_volume": `)
+				if v, ok := value.(SeriesValueGenerator); ok {
+					inner.WriteString(v.ValueAt(key, t))
+				} else {
+					inner.WriteString(value.Next())
+				}
+				inner.WriteString(`, "user_id": null, "project_id": null, "resource_id": "`)
+				inner.WriteString(*m.hostname)
+				inner.WriteString("-")
+				inner.WriteString(pluginInstance)
+				inner.WriteString("-")
+				inner.WriteString(typeInstance)
+				inner.WriteString(`", "timestamp": "`)
+				inner.WriteString(timestamp)
+				inner.WriteString(`", "message_signature": "", "resource_metadata": {"host": "`)
+				inner.WriteString(*m.hostname)
+				inner.WriteString(`"}}], "timestamp": "`)
+				inner.WriteString(timestamp)
+				inner.WriteString(`"}`)
+
+				sb.Reset()
+				sb.WriteString(`{"request": {"oslo.version": "2.0", "oslo.message": "`)
+				writeEscaped(sb, inner.Bytes())
+				sb.WriteString(`"}, "context": {}}`)
+
+				if !fn(sb.Bytes()) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeEscaped writes b escaped for use inside a JSON string. The generated
+// names are plain ASCII, so only quotes and backslashes need escaping.
+func writeEscaped(sb *bytes.Buffer, b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, `"\`)
+		if i < 0 {
+			sb.Write(b)
+			return
+		}
+		sb.Write(b[:i])
+		sb.WriteByte('\\')
+		sb.WriteByte(b[i])
+		b = b[i+1:]
+	}
+}
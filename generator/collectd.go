@@ -0,0 +1,270 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collectdSeries is one value list of a live collectd, e.g.
+// host/interface-eth0/if_octets
+type collectdSeries struct {
+	identifier     string
+	plugin         string
+	pluginInstance string
+	mtype          string
+	typeInstance   string
+	dsnames        []string
+}
+
+// CollectdSource reads the metrics of a live collectd through its unixsock
+// plugin, so the simulated hosts carry authentic metric names and value
+// dynamics instead of synthetic ones
+type CollectdSource struct {
+	path   string
+	series []collectdSeries
+
+	sync.RWMutex
+	// values holds the latest values by identifier, in dsnames order
+	values map[string][]string
+}
+
+// NewCollectdSource lists the value lists of the collectd listening on the
+// unixsock at path and reads their current values
+func NewCollectdSource(path string) (*CollectdSource, error) {
+	s := &CollectdSource{path: path, values: map[string][]string{}}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	lines, err := command(conn, r, "LISTVAL")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		// each line is "<time> <identifier>"
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		series, ok := parseIdentifier(fields[1])
+		if !ok {
+			continue
+		}
+		dsnames, values, err := getValues(conn, r, series.identifier)
+		if err != nil {
+			return nil, err
+		}
+		series.dsnames = dsnames
+		s.series = append(s.series, series)
+		s.values[series.identifier] = values
+	}
+	if len(s.series) == 0 {
+		return nil, fmt.Errorf("collectd at %s has no values", path)
+	}
+	return s, nil
+}
+
+// parseIdentifier splits host/plugin[-instance]/type[-instance]
+func parseIdentifier(identifier string) (collectdSeries, bool) {
+	parts := strings.SplitN(identifier, "/", 3)
+	if len(parts) != 3 {
+		return collectdSeries{}, false
+	}
+	s := collectdSeries{identifier: identifier}
+	s.plugin, s.pluginInstance = splitInstance(parts[1])
+	s.mtype, s.typeInstance = splitInstance(parts[2])
+	return s, true
+}
+
+func splitInstance(name string) (string, string) {
+	if i := strings.Index(name, "-"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// command sends a unixsock command and returns the lines of its response,
+// which starts with "<count> <message>" or a negative count on error
+func command(conn net.Conn, r *bufio.Reader, cmd string) ([]string, error) {
+	if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+	status, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(strings.TrimSpace(status), " ", 2)
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("collectd %s: %s", cmd, strings.TrimSpace(status))
+	}
+
+	lines := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return lines, nil
+}
+
+// getValues reads the current values of a value list
+func getValues(conn net.Conn, r *bufio.Reader, identifier string) ([]string, []string, error) {
+	lines, err := command(conn, r, `GETVAL "`+identifier+`"`)
+	if err != nil {
+		return nil, nil, err
+	}
+	dsnames := make([]string, 0, len(lines))
+	values := make([]string, 0, len(lines))
+	for _, line := range lines {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := kv[1]
+		if f, err := strconv.ParseFloat(value, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			// NaN isn't valid JSON, collectd's own JSON output uses null
+			value = "null"
+		}
+		dsnames = append(dsnames, kv[0])
+		values = append(values, value)
+	}
+	return dsnames, values, nil
+}
+
+// Refresh reads the current values of every value list
+func (s *CollectdSource) Refresh() error {
+	conn, err := net.Dial("unix", s.path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for _, series := range s.series {
+		_, values, err := getValues(conn, r, series.identifier)
+		if err != nil {
+			return err
+		}
+		s.Lock()
+		s.values[series.identifier] = values
+		s.Unlock()
+	}
+	return nil
+}
+
+// Run refreshes the values every interval until ctx is done, returning the
+// first error
+func (s *CollectdSource) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Refresh(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// liveValue is a ValueGenerator returning the latest value of a data source
+// of the collectd being amplified
+type liveValue struct {
+	source     *CollectdSource
+	identifier string
+	ds         int
+}
+
+func (v *liveValue) Next() string {
+	v.source.RLock()
+	defer v.source.RUnlock()
+	values := v.source.values[v.identifier]
+	if v.ds >= len(values) {
+		return "null"
+	}
+	return values[v.ds]
+}
+
+// Hosts builds numHosts simulated hosts, numbered from hostOffset, that each
+// report every value list of the live collectd with its current values. Only
+// the host template of naming applies; the plugins keep their collectd names.
+func (s *CollectdSource) Hosts(hostPrefix string, numHosts int, hostOffset int, intervalSec int, naming Naming, format Format) ([]Host, error) {
+	naming, err := naming.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	format, err = format.check()
+	if err != nil {
+		return nil, err
+	}
+
+	descs := make([]*pluginDesc, len(s.series))
+	for j, series := range s.series {
+		desc := pluginDesc{
+			name:           series.plugin,
+			interval:       format.declared(intervalSec),
+			mtype:          []string{series.mtype},
+			typeInstance:   []string{series.typeInstance},
+			pluginInstance: []string{series.pluginInstance},
+			dsnames:        series.dsnames,
+			dstypes:        make([]string, len(series.dsnames)),
+			timeFormat:     format.Time,
+		}
+		for k := range desc.dstypes {
+			// the unixsock plugin doesn't report data source types
+			desc.dstypes[k] = "gauge"
+		}
+		descs[j] = newPluginDesc(desc)
+	}
+
+	hosts := make([]Host, numHosts)
+	for i := range hosts {
+		hosts[i].Name = hostPrefix + formatName(naming.Host, hostOffset+i)
+		hosts[i].Plugins = make([]Plugin, len(s.series))
+		for j, series := range s.series {
+			p := &hosts[i].Plugins[j]
+			p.pluginDesc = descs[j]
+			p.hostname = &hosts[i].Name
+			p.values = make([]ValueGenerator, len(series.dsnames))
+			for k := range series.dsnames {
+				p.values[k] = &liveValue{source: s, identifier: series.identifier, ds: k}
+			}
+		}
+	}
+	return hosts, nil
+}
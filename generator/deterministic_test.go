@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testTopology is a host of two plugins of two types, type instances and
+// plugin instances
+func testTopology(valueGenerator string, format Format) Topology {
+	return Topology{HostPrefix: "test", Hosts: 1, Plugins: 2, Types: 2, TypeInstances: 2, PluginInstances: 2, IntervalSec: 10,
+		ValueGenerator: valueGenerator, Naming: DefaultNaming, Format: format}
+}
+
+// renderMetrics renders three intervals of the metrics of a fresh host
+// with valueGenerator, after seeding the global random source with seed
+func renderMetrics(t *testing.T, valueGenerator string, seed int64) [][]byte {
+	rand.Seed(seed)
+	hosts, err := GenerateHosts(testTopology(valueGenerator, Format{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads [][]byte
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 3; i++ {
+		for p := range hosts[0].Plugins {
+			hosts[0].Plugins[p].EachMetricMessageAt(start.Add(time.Duration(i)*10*time.Second), func(payload []byte) bool {
+				payloads = append(payloads, append([]byte(nil), payload...))
+				return true
+			})
+		}
+	}
+	return payloads
+}
+
+// TestDeterministicValues checks every value generator -deterministic
+// accepts renders byte-identical payloads whatever the random seed
+func TestDeterministicValues(t *testing.T) {
+	for _, name := range DeterministicValues {
+		t.Run(name, func(t *testing.T) {
+			first, second := renderMetrics(t, name, 1), renderMetrics(t, name, 2)
+			if len(first) == 0 || len(first) != len(second) {
+				t.Fatalf("rendered %d and %d payloads", len(first), len(second))
+			}
+			for i := range first {
+				if !bytes.Equal(first[i], second[i]) {
+					t.Errorf("payload %d differs:\n%s\n%s", i, first[i], second[i])
+				}
+			}
+		})
+	}
+}
+
+// renderEvents renders three intervals of the events of a fresh host with
+// format, after seeding the global random source with seed
+func renderEvents(t *testing.T, format Format, seed int64) [][]byte {
+	rand.Seed(seed)
+	hosts, err := GenerateHosts(testTopology("hash", format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads [][]byte
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 3; i++ {
+		for p := range hosts[0].Plugins {
+			hosts[0].Plugins[p].EachEventMessageAt(start.Add(time.Duration(i)*10*time.Second), func(payload []byte) bool {
+				payloads = append(payloads, append([]byte(nil), payload...))
+				return true
+			})
+		}
+	}
+	return payloads
+}
+
+// TestDeterministicSeverities checks deterministic events draw and flip
+// their severities the same whatever the random seed
+func TestDeterministicSeverities(t *testing.T) {
+	format := Format{Severities: []float64{50, 30, 20}, SeverityFlip: 0.5, Deterministic: true}
+	first, second := renderEvents(t, format, 1), renderEvents(t, format, 2)
+	if len(first) == 0 || len(first) != len(second) {
+		t.Fatalf("rendered %d and %d payloads", len(first), len(second))
+	}
+	severities := map[string]bool{}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Errorf("event %d differs:\n%s\n%s", i, first[i], second[i])
+		}
+		for _, s := range Severities {
+			if bytes.Contains(first[i], []byte(`"severity":"`+s+`"`)) {
+				severities[s] = true
+			}
+		}
+	}
+	// the draws still follow the distribution rather than being constant
+	if len(severities) < 2 {
+		t.Errorf("only %v drawn in %d events", severities, len(first))
+	}
+}
@@ -0,0 +1,439 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// Dictionary lists plugin, type and instance names to generate instead of
+// the naming templates, so the length and variety of the names match a
+// production deployment. Lists left empty fall back to the templates.
+// Shapes override the names of single plugins, by plugin name.
+type Dictionary struct {
+	Plugins         []string               `json:"plugins"`
+	PluginInstances []string               `json:"plugin_instances"`
+	Types           []string               `json:"types"`
+	TypeInstances   []string               `json:"type_instances"`
+	Shapes          map[string]PluginShape `json:"shapes"`
+}
+
+// PluginShape holds the names of one plugin that differ from the rest,
+// e.g. the instance-0000002c plugin instances of virt. Its lists take the
+// place of the dictionary's for the plugin.
+type PluginShape struct {
+	// PluginInstance is a naming template for the plugin instances, in
+	// place of a list
+	PluginInstance  string   `json:"plugin_instance"`
+	PluginInstances []string `json:"plugin_instances"`
+	Types           []string `json:"types"`
+	TypeInstances   []string `json:"type_instances"`
+	// DSTypes and DSNames are the data sources of every series, as many
+	// values as names, in place of a single derive named samples
+	DSTypes []string `json:"dstypes"`
+	DSNames []string `json:"dsnames"`
+	// Ranges bound the values of the series by type name, e.g. plausible
+	// temperatures and fan speeds; -valuerange bounds the other types
+	Ranges map[string]ValueRange `json:"ranges"`
+	// Interval is the seconds between the messages of the plugin, rounded
+	// up to whole intervals of the run, for plugins slower than the rest
+	Interval int `json:"interval"`
+	// Churn is the chance per interval that a plugin instance is replaced
+	// by a new one, which gets a new random suffix as pods do. Plugins
+	// with the same plugin instances and churn replace them together.
+	Churn float64 `json:"churn"`
+}
+
+// apply gives the descriptor of a plugin sending every interval seconds the
+// names of the shape, as many as the counts. r is the plugin's value range
+// for its types without a range of the shape's, and may be nil.
+func (s PluginShape) apply(d *pluginDesc, interval, numTypes, numTypeInstances, numPluginInstances int, r *ValueRange) error {
+	if s.Churn < 0 || s.Churn > 1 {
+		return fmt.Errorf("%s churn %v isn't between 0 and 1", d.name, s.Churn)
+	}
+	if s.Interval < 0 {
+		return fmt.Errorf("%s has a negative interval %d", d.name, s.Interval)
+	}
+	if len(s.DSTypes) != len(s.DSNames) {
+		return fmt.Errorf("%s has %d dstypes for %d dsnames", d.name, len(s.DSTypes), len(s.DSNames))
+	}
+	if len(s.DSNames) > 0 {
+		d.dstypes, d.dsnames = s.DSTypes, s.DSNames
+	}
+	switch {
+	case s.PluginInstance != "":
+		d.pluginInstance = names(nil, s.PluginInstance, numPluginInstances)
+	case len(s.PluginInstances) > 0:
+		d.pluginInstance = names(s.PluginInstances, "", numPluginInstances)
+	}
+	if len(s.Types) > 0 {
+		d.mtype = names(s.Types, "", numTypes)
+	}
+	if len(s.TypeInstances) > 0 {
+		d.typeInstance = names(s.TypeInstances, "", numTypeInstances)
+	}
+	if s.Interval > interval && interval > 0 {
+		d.every = (s.Interval + interval - 1) / interval
+		interval *= d.every
+	}
+	if s.Churn > 0 {
+		d.lifetime = float64(interval) / s.Churn
+	}
+	if len(s.Ranges) > 0 {
+		d.typeRanges = make([]*ValueRange, len(d.mtype))
+		for t, mtype := range d.mtype {
+			d.typeRanges[t] = r
+			if tr, ok := s.Ranges[mtype]; ok {
+				d.typeRanges[t] = &tr
+			}
+		}
+	}
+	return nil
+}
+
+// dictionaries are the built-in dictionaries, by name
+var dictionaries = map[string]Dictionary{
+	// sampled from the collectd write_http output of OpenStack controller
+	// and compute nodes
+	"collectd": {
+		Plugins: []string{
+			"cpu", "memory", "interface", "df", "disk", "load", "processes",
+			"swap", "virt", "ovs_stats", "ovs_events", "hugepages", "intel_rdt",
+			"ipmi", "ceph", "connectivity", "procevent", "contextswitch",
+			"irq", "numa", "tcpconns", "ethstat", "vmem", "turbostat",
+			"pcie_errors", "mcelog", "dpdkstat", "dpdk_telemetry", "netlink",
+			"entropy", "thermal", "users", "sensors", "smart",
+		},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+	},
+	// the collectd names as an OpenStack compute node reports them, virt
+	// first with the instance-%08x domain names nova gives its guests
+	"osp": {
+		Plugins: []string{
+			"virt", "cpu", "memory", "interface", "df", "disk", "load",
+			"processes", "swap", "ovs_stats", "ovs_events", "hugepages",
+			"intel_rdt", "ipmi", "ceph", "connectivity", "procevent",
+			"contextswitch", "irq", "numa", "tcpconns", "ethstat", "vmem",
+			"turbostat", "pcie_errors", "mcelog", "dpdkstat",
+			"dpdk_telemetry", "netlink", "entropy", "thermal", "users",
+			"sensors", "smart",
+		},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+		Shapes: map[string]PluginShape{
+			"virt": {PluginInstance: "instance-%08x"},
+		},
+	},
+	// kubelet and cAdvisor metrics of an OpenShift node, the container
+	// series by namespace_pod and container, with the pods replaced
+	// every 100 intervals on average
+	"kubelet": {
+		Plugins: []string{
+			"container_cpu", "container_memory", "container_network",
+			"container_fs", "kubelet_volume_stats", "cpu", "memory",
+			"interface", "df", "disk", "load", "processes",
+		},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+		Shapes: map[string]PluginShape{
+			"container_cpu": {
+				PluginInstances: kubeletPods,
+				Types:           []string{"usage_seconds_total", "cfs_throttled_seconds_total", "cfs_periods_total", "system_seconds_total"},
+				TypeInstances:   kubeletContainers,
+				Churn:           0.01,
+			},
+			"container_memory": {
+				PluginInstances: kubeletPods,
+				Types:           []string{"working_set_bytes", "rss", "cache", "usage_bytes", "swap"},
+				TypeInstances:   kubeletContainers,
+				Churn:           0.01,
+			},
+			"container_network": {
+				PluginInstances: kubeletPods,
+				Types:           []string{"receive_bytes_total", "transmit_bytes_total", "receive_packets_total", "transmit_packets_total", "receive_errors_total"},
+				TypeInstances:   []string{"eth0", "net1"},
+				Churn:           0.01,
+			},
+			"container_fs": {
+				PluginInstances: kubeletPods,
+				Types:           []string{"usage_bytes", "reads_total", "writes_total", "inodes_free"},
+				TypeInstances:   kubeletContainers,
+				Churn:           0.01,
+			},
+			"kubelet_volume_stats": {
+				PluginInstances: kubeletPods,
+				Types:           []string{"used_bytes", "capacity_bytes", "inodes_used", "available_bytes"},
+				TypeInstances:   []string{"data", "config", "tmp"},
+				Churn:           0.01,
+			},
+		},
+	},
+	// the collectd ceph plugin on a storage node, an osd.N plugin
+	// instance per OSD daemon with its latency, rate and byte counters
+	"ceph": {
+		Plugins:         []string{"ceph", "cpu", "memory", "disk", "interface", "df", "load"},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+		Shapes: map[string]PluginShape{
+			"ceph": {
+				PluginInstance: "osd.%d",
+				Types:          []string{"ceph_latency", "ceph_rate", "ceph_bytes"},
+				TypeInstances:  cephOSDCounters,
+				DSTypes:        []string{"gauge"},
+				DSNames:        []string{"value"},
+			},
+		},
+	},
+	// the ovs_stats and dpdkstat plugins of an NFV compute node, per
+	// bridge port and per receive and transmit queue of the DPDK ports
+	"ovs-dpdk": {
+		Plugins: []string{
+			"ovs_stats", "dpdkstat", "interface", "cpu", "memory", "hugepages",
+			"intel_rdt", "load", "df", "disk",
+		},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+		Shapes: map[string]PluginShape{
+			"ovs_stats": {
+				PluginInstance: "br-link0.dpdk%d",
+				Types:          []string{"if_packets", "if_octets", "if_dropped", "if_errors", "if_rx_errors", "if_collisions"},
+				TypeInstances: []string{"", "1_to_64_packets", "65_to_127_packets", "128_to_255_packets",
+					"256_to_511_packets", "512_to_1023_packets", "1024_to_1522_packets", "1523_to_max_packets"},
+				DSTypes: []string{"derive", "derive"},
+				DSNames: []string{"rx", "tx"},
+			},
+			"dpdkstat": {
+				PluginInstance: "dpdk%d",
+				Types:          []string{"derive", "if_rx_dropped", "if_rx_errors", "if_tx_errors"},
+				TypeInstances:  dpdkQueueCounters(16),
+				DSTypes:        []string{"derive"},
+				DSNames:        []string{"value"},
+			},
+		},
+	},
+	// the collectd snmp plugin polling a switch every minute, which
+	// reports as a host of its own with a type instance per interface
+	"snmp": {
+		Plugins: []string{"snmp"},
+		Shapes: map[string]PluginShape{
+			"snmp": {
+				PluginInstances: []string{""},
+				Types:           []string{"if_octets", "if_packets", "if_errors", "if_dropped"},
+				TypeInstances:   switchInterfaces(),
+				DSTypes:         []string{"derive", "derive"},
+				DSNames:         []string{"rx", "tx"},
+				Interval:        60,
+			},
+		},
+	},
+	// a bare metal node: the ipmi sensors of the BMC every minute and the
+	// lm-sensors chips every 30 seconds, next to the usual fast plugins
+	"ipmi": {
+		Plugins: []string{
+			"ipmi", "sensors", "cpu", "memory", "interface", "disk", "df",
+			"load", "processes",
+		},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+		Shapes: map[string]PluginShape{
+			"ipmi": {
+				PluginInstances: []string{""},
+				Types:           []string{"temperature", "fanspeed", "voltage", "power"},
+				TypeInstances: []string{"processor (3.1)", "processor (3.2)", "system board (7.1)",
+					"power supply (10.1)", "power supply (10.2)", "memory device (32.1)",
+					"disk drive bay (26.1)", "cooling device (29.1)"},
+				DSTypes:  []string{"gauge"},
+				DSNames:  []string{"value"},
+				Interval: 60,
+				Ranges:   sensorRanges,
+			},
+			"sensors": {
+				PluginInstances: []string{"coretemp-isa-0000", "coretemp-isa-0001", "nvme-pci-0100", "acpitz-virtual-0"},
+				Types:           []string{"temperature", "fanspeed", "voltage"},
+				TypeInstances:   []string{"temp1", "temp2", "temp3", "temp4", "temp5", "temp6", "temp7", "temp8"},
+				DSTypes:         []string{"gauge"},
+				DSNames:         []string{"value"},
+				Interval:        30,
+				Ranges:          sensorRanges,
+			},
+		},
+	},
+	// the collectd ceph plugin on a controller running a monitor
+	"ceph-mon": {
+		Plugins:         []string{"ceph", "cpu", "memory", "disk", "interface", "df", "load"},
+		PluginInstances: collectdPluginInstances,
+		Types:           collectdTypes,
+		TypeInstances:   collectdTypeInstances,
+		Shapes: map[string]PluginShape{
+			"ceph": {
+				PluginInstance: "mon.controller-%d",
+				Types:          []string{"ceph_rate", "ceph_latency", "ceph_bytes"},
+				TypeInstances:  cephMonCounters,
+				DSTypes:        []string{"gauge"},
+				DSNames:        []string{"value"},
+			},
+		},
+	},
+}
+
+// the instance and type names of the collectd dictionaries
+var (
+	collectdPluginInstances = []string{
+		"0", "1", "2", "3", "eth0", "eth1", "ens3", "br-ex", "br-int",
+		"br-tun", "vxlan_sys_4789", "tap6e3c1f2b-9d", "qvo5d21a7e0-3c",
+		"vda", "vdb", "sda", "sda1", "sda2", "nvme0n1", "root", "boot",
+		"var-lib-docker", "dev-hugepages", "instance-0000002c",
+		"instance-000001f3", "node0", "node1", "mon.controller-0",
+		"osd.12", "ovs-system", "bond_api", "dpdk0", "dpdk1",
+	}
+	collectdTypes = []string{
+		"percent", "cpu", "memory", "if_octets", "if_packets", "if_errors",
+		"if_dropped", "df_complex", "percent_bytes", "disk_octets",
+		"disk_ops", "disk_time", "disk_merged", "disk_io_time",
+		"pending_operations", "load", "ps_state", "fork_rate", "swap",
+		"swap_io", "virt_cpu_total", "virt_vcpu", "total_requests",
+		"total_time_in_ms", "vmpage_number", "vmpage_io", "vmpage_action",
+		"hugepages", "bytes", "gauge", "derive", "count", "temperature",
+		"voltage", "fanspeed", "irq", "contextswitch", "entropy",
+		"tcp_connections", "ipc", "memory_bandwidth", "ceph_bytes",
+		"ceph_latency", "pkts", "errors",
+	}
+	collectdTypeInstances = []string{
+		"user", "system", "idle", "wait", "nice", "interrupt", "softirq",
+		"steal", "used", "free", "cached", "buffered", "slab_recl",
+		"slab_unrecl", "rx", "tx", "read", "write", "reserved", "running",
+		"sleeping", "zombie", "stopped", "paging", "blocked", "in", "out",
+		"majflt", "minflt", "free_hugepages", "used_hugepages",
+		"rx_good_packets", "tx_good_packets", "rx_missed_errors",
+		"ESTABLISHED", "TIME_WAIT", "LISTEN", "Core 0", "Package id 0",
+		"local_bytes", "remote_bytes", "osd.apply_latency", "",
+	}
+	// the namespace_pod plugin instances of the kubelet dictionary, before
+	// the random suffix
+	kubeletPods = []string{
+		"openshift-monitoring_node-exporter", "openshift-dns_dns-default",
+		"openshift-sdn_sdn", "openshift-multus_multus",
+		"openshift-ingress_router-default-5d8c6b4f7",
+		"openshift-machine-config-operator_machine-config-daemon",
+		"openshift-image-registry_node-ca",
+		"openshift-cluster-node-tuning-operator_tuned",
+		"openshift-monitoring_prometheus-adapter-7f6c9d8b5",
+		"service-telemetry_smart-gateway-5f6d7c8b9",
+		"service-telemetry_interconnect-6b9d7f5c8", "default_frontend-7c9d8f6b5",
+		"default_backend-6b7f9d8c4", "default_redis-84c7d9b6f",
+	}
+	kubeletContainers = []string{
+		"POD", "main", "kube-rbac-proxy", "sidecar", "init", "proxy",
+	}
+	// plausible readings of the hardware sensors, in degrees Celsius,
+	// RPM, volts and watts
+	sensorRanges = map[string]ValueRange{
+		"temperature": {Min: 25, Max: 85, Step: 0.5},
+		"fanspeed":    {Min: 2000, Max: 12000, Step: 120},
+		"voltage":     {Min: 1, Max: 12.5, Step: 0.02},
+		"power":       {Min: 90, Max: 750, Step: 10},
+	}
+	// the perf counters the ceph plugin reports of the daemons
+	cephOSDCounters = []string{
+		"osd.op", "osd.opR", "osd.opW", "osd.opRw", "osd.subop",
+		"osd.opInBytes", "osd.opOutBytes", "osd.recoveryOps",
+		"osd.opBeforeQueueOpLat", "osd.opPrepare", "osd.numpg",
+		"filestore.journal", "bluestore.commit", "bluestore.kvFlush",
+		"bluestore.read", "bluestore.stateAioWait", "throttleMsgrDispatch",
+	}
+	cephMonCounters = []string{
+		"mon.numSessions", "mon.sessionAdd", "mon.electionCall", "paxos.commit",
+		"paxos.collect", "paxos.storeState", "mon.numElections",
+		"throttleMonClientBytes", "throttleMsgrDispatch",
+	}
+)
+
+// dpdkQueueCounters returns the per-queue counters dpdkstat reports of a
+// port with queues receive and transmit queues
+func dpdkQueueCounters(queues int) []string {
+	var counters []string
+	for q := 0; q < queues; q++ {
+		for _, c := range []string{"rx_q%d_packets", "tx_q%d_packets", "rx_q%d_bytes", "tx_q%d_bytes", "rx_q%d_errors"} {
+			counters = append(counters, fmt.Sprintf(c, q))
+		}
+	}
+	return counters
+}
+
+// switchInterfaces returns the ifDescr of the interfaces of a 48 port
+// switch, the type instances of its snmp interface tables
+func switchInterfaces() []string {
+	var interfaces []string
+	for port := 1; port <= 48; port++ {
+		interfaces = append(interfaces, fmt.Sprintf("GigabitEthernet1/0/%d", port))
+	}
+	for port := 1; port <= 4; port++ {
+		interfaces = append(interfaces, fmt.Sprintf("TenGigabitEthernet1/1/%d", port))
+	}
+	return append(interfaces, "Port-channel1", "Port-channel2", "Vlan1", "Vlan100", "Null0")
+}
+
+// Dictionaries returns the names of the built-in dictionaries
+func Dictionaries() []string {
+	names := make([]string, 0, len(dictionaries))
+	for name := range dictionaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadDictionary returns the built-in dictionary called name, or else reads
+// one from the JSON file at path name
+func LoadDictionary(name string) (Dictionary, error) {
+	if d, ok := dictionaries[name]; ok {
+		return d, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return Dictionary{}, fmt.Errorf("no dictionary %q (built in: %v): %v", name, Dictionaries(), err)
+	}
+	var d Dictionary
+	if err := json.Unmarshal(data, &d); err != nil {
+		return Dictionary{}, fmt.Errorf("reading dictionary %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// pick returns the name of index i from names. Past the end of the list
+// the names repeat with a number appended, so they stay unique.
+func pick(names []string, i int) string {
+	name := names[i%len(names)]
+	if round := i / len(names); round > 0 {
+		name += strconv.Itoa(round)
+	}
+	return name
+}
@@ -0,0 +1,342 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// exprPrefix marks a value generator given as an expression rather than
+// by name, e.g. expr:50 + 10*sin(t/300) + noise(2)
+const exprPrefix = "expr:"
+
+// exprVars are the variables of a value expression: t is the time of the
+// sample in seconds since the epoch and phase a number in [0, 1) of its
+// own for every series, to keep them apart. rnd is the state of the random
+// numbers of noise and rand, seeded from the series and t for every sample
+// so the generators don't contend for the global source.
+type exprVars struct {
+	t, phase float64
+	rnd      uint64
+}
+
+// uniform returns the next random number in [0, 1) of v
+func (v *exprVars) uniform() float64 {
+	v.rnd += 0x9e3779b97f4a7c15
+	return float64(mix64(v.rnd)>>11) / (1 << 53)
+}
+
+// expr is a compiled value expression
+type expr func(v *exprVars) float64
+
+// exprFuncs are the functions of the value expressions by name, with their
+// number of arguments, which are passed as x and y
+var exprFuncs = map[string]struct {
+	args int
+	fn   func(v *exprVars, x, y float64) float64
+}{
+	"sin":   {1, func(v *exprVars, x, y float64) float64 { return math.Sin(x) }},
+	"cos":   {1, func(v *exprVars, x, y float64) float64 { return math.Cos(x) }},
+	"abs":   {1, func(v *exprVars, x, y float64) float64 { return math.Abs(x) }},
+	"sqrt":  {1, func(v *exprVars, x, y float64) float64 { return math.Sqrt(x) }},
+	"exp":   {1, func(v *exprVars, x, y float64) float64 { return math.Exp(x) }},
+	"log":   {1, func(v *exprVars, x, y float64) float64 { return math.Log(x) }},
+	"floor": {1, func(v *exprVars, x, y float64) float64 { return math.Floor(x) }},
+	"min":   {2, func(v *exprVars, x, y float64) float64 { return math.Min(x, y) }},
+	"max":   {2, func(v *exprVars, x, y float64) float64 { return math.Max(x, y) }},
+	// noise is normally distributed around 0 with the given deviation,
+	// by the Box-Muller transform
+	"noise": {1, func(v *exprVars, x, y float64) float64 {
+		return math.Sqrt(-2*math.Log(1-v.uniform())) * math.Cos(2*math.Pi*v.uniform()) * x
+	}},
+	// rand is uniform between the two bounds
+	"rand": {2, func(v *exprVars, x, y float64) float64 { return x + v.uniform()*(y-x) }},
+}
+
+// nonFinite counts the expression results that weren't finite numbers
+var nonFinite int64
+
+// NonFiniteValues returns how many value expression results were NaN or
+// infinite, e.g. log(0), and replaced to keep the payloads valid JSON
+func NonFiniteValues() int64 {
+	return atomic.LoadInt64(&nonFinite)
+}
+
+var (
+	exprsLock sync.Mutex
+	// exprs caches the compiled expressions, every data source of every
+	// host gets a generator of its own
+	exprs = map[string]expr{}
+)
+
+// compileExpr parses an expression of the numbers, t and phase, with + - *
+// / % ^, parentheses and the exprFuncs
+func compileExpr(source string) (expr, error) {
+	exprsLock.Lock()
+	defer exprsLock.Unlock()
+	if e, ok := exprs[source]; ok {
+		return e, nil
+	}
+	p := &exprParser{src: source}
+	p.next()
+	e, err := p.parse(0)
+	if err == nil && p.tok != "" {
+		err = fmt.Errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("value expression %q: %v", source, err)
+	}
+	exprs[source] = e
+	return e, nil
+}
+
+// exprParser is a precedence climbing parser over the tokens of src
+type exprParser struct {
+	src string
+	pos int
+	tok string
+}
+
+// next moves to the next token, empty at the end
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || strings.ContainsRune(".eE", rune(p.src[p.pos])) ||
+			(p.pos > start && strings.ContainsRune("eE", rune(p.src[p.pos-1])) && strings.ContainsRune("+-", rune(p.src[p.pos])))) {
+			p.pos++
+		}
+	case unicode.IsLetter(c):
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+// binaryPrecedence returns the precedence of the binary operators, 0 for
+// anything else
+func binaryPrecedence(tok string) int {
+	switch tok {
+	case "+", "-":
+		return 1
+	case "*", "/", "%":
+		return 2
+	case "^":
+		return 3
+	}
+	return 0
+}
+
+// parse parses the operators binding tighter than min
+func (p *exprParser) parse(min int) (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.tok
+		prec := binaryPrecedence(op)
+		if prec == 0 || prec <= min {
+			return left, nil
+		}
+		p.next()
+		// ^ is right associative
+		next := prec
+		if op == "^" {
+			next = prec - 1
+		}
+		right, err := p.parse(next)
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+}
+
+func binary(op string, l, r expr) expr {
+	switch op {
+	case "+":
+		return func(v *exprVars) float64 { return l(v) + r(v) }
+	case "-":
+		return func(v *exprVars) float64 { return l(v) - r(v) }
+	case "*":
+		return func(v *exprVars) float64 { return l(v) * r(v) }
+	case "/":
+		return func(v *exprVars) float64 { return l(v) / r(v) }
+	case "%":
+		return func(v *exprVars) float64 { return math.Mod(l(v), r(v)) }
+	}
+	return func(v *exprVars) float64 { return math.Pow(l(v), r(v)) }
+}
+
+// unary parses a signed operand
+func (p *exprParser) unary() (expr, error) {
+	if p.tok == "-" {
+		p.next()
+		// binds tighter than everything but ^, so -2^2 is -4
+		e, err := p.parse(binaryPrecedence("*"))
+		if err != nil {
+			return nil, err
+		}
+		return func(v *exprVars) float64 { return -e(v) }, nil
+	}
+	return p.operand()
+}
+
+// operand parses a number, variable, function call or parenthesized
+// expression
+func (p *exprParser) operand() (expr, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end")
+	case tok == "(":
+		p.next()
+		e, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.next()
+		return e, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		x, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		p.next()
+		return func(*exprVars) float64 { return x }, nil
+	case tok == "t":
+		p.next()
+		return func(v *exprVars) float64 { return v.t }, nil
+	case tok == "phase":
+		p.next()
+		return func(v *exprVars) float64 { return v.phase }, nil
+	case tok == "pi":
+		p.next()
+		return func(*exprVars) float64 { return math.Pi }, nil
+	}
+
+	f, ok := exprFuncs[tok]
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q", tok)
+	}
+	p.next()
+	if p.tok != "(" {
+		return nil, fmt.Errorf("missing ( after %s", tok)
+	}
+	var args []expr
+	for {
+		p.next()
+		arg, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.tok != "," {
+			break
+		}
+	}
+	if p.tok != ")" {
+		return nil, fmt.Errorf("missing ) after the arguments of %s", tok)
+	}
+	p.next()
+	if len(args) != f.args {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", tok, f.args, len(args))
+	}
+	// the arguments are passed by value, evaluating allocates nothing
+	fn, x := f.fn, args[0]
+	if len(args) == 1 {
+		return func(v *exprVars) float64 { return fn(v, x(v), 0) }, nil
+	}
+	y := args[1]
+	return func(v *exprVars) float64 { return fn(v, x(v), y(v)) }, nil
+}
+
+// exprValue evaluates an expression for every value, clamped to its range
+// if it has one. Results that aren't finite numbers are replaced by the
+// nearest bound of the range, or 0 without one, and counted.
+type exprValue struct {
+	e        expr
+	ranged   bool
+	min, max float64
+	// vars are reused from one value to the next, a generator is only
+	// used by one goroutine at a time
+	vars exprVars
+}
+
+func newExprValue(e expr) *exprValue {
+	return &exprValue{e: e, vars: exprVars{rnd: uint64(time.Now().UnixNano())}}
+}
+
+// Next evaluates the expression now, outside a series
+func (x *exprValue) Next() string {
+	x.vars.t, x.vars.phase = float64(time.Now().UnixNano())/1e9, 0
+	return x.eval()
+}
+
+func (x *exprValue) ValueAt(series uint64, t time.Time) string {
+	x.vars.t, x.vars.phase = float64(t.UnixNano())/1e9, float64(mix64(series)>>11)/(1<<53)
+	x.vars.rnd = mix64(series ^ uint64(t.UnixNano()))
+	return x.eval()
+}
+
+func (x *exprValue) eval() string {
+	value := x.e(&x.vars)
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		atomic.AddInt64(&nonFinite, 1)
+		switch {
+		case !x.ranged:
+			value = 0
+		case math.IsInf(value, 1):
+			value = x.max
+		default:
+			value = x.min
+		}
+	}
+	if x.ranged {
+		value = math.Max(x.min, math.Min(x.max, value))
+	}
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
+
+func (x *exprValue) SetRange(r ValueRange) {
+	x.ranged, x.min, x.max = true, r.Min, r.Max
+}
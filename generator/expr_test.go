@@ -0,0 +1,152 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExprPrecedence checks the operators bind as documented, ^ tighter
+// than unary minus and right associative
+func TestExprPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		want   float64
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-4-3", 3},
+		{"2^3^2", 512},
+		{"-2^2", -4},
+		{"(-2)^2", 4},
+		{"2*-3", -6},
+		{"10%4", 2},
+		{"8/2/2", 2},
+		{"min(1, 2) + max(1, 2)", 3},
+		{"floor(2.5) + abs(-1)", 3},
+	} {
+		e, err := compileExpr(tc.source)
+		if err != nil {
+			t.Errorf("%s: %v", tc.source, err)
+			continue
+		}
+		if got := e(&exprVars{}); got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.source, got, tc.want)
+		}
+	}
+}
+
+// TestExprErrors checks malformed expressions are rejected at compile time
+func TestExprErrors(t *testing.T) {
+	for _, tc := range []struct {
+		source, want string
+	}{
+		{"sin(1, 2)", "sin takes 1 arguments, not 2"},
+		{"max(1)", "max takes 2 arguments, not 1"},
+		{"1 2", `unexpected "2"`},
+		{"1+", ""},
+		{"(1+2", ""},
+		{"foo(1)", ""},
+		{"x", ""},
+	} {
+		_, err := compileExpr(tc.source)
+		if err == nil {
+			t.Errorf("%s compiled", tc.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", tc.source, err, tc.want)
+		}
+	}
+}
+
+// TestExprNonFinite checks results that aren't finite numbers still render
+// as valid JSON numbers, within the range if there is one, and are counted
+func TestExprNonFinite(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		r      *ValueRange
+		want   string
+	}{
+		{"log(0)", nil, "0.0000"},
+		{"sqrt(-1)", nil, "0.0000"},
+		{"1/0", nil, "0.0000"},
+		{"1/0", &ValueRange{Min: 1, Max: 9}, "9.0000"},
+		{"log(0)", &ValueRange{Min: 1, Max: 9}, "1.0000"},
+		{"sqrt(-1)", &ValueRange{Min: 1, Max: 9}, "1.0000"},
+	} {
+		before := NonFiniteValues()
+		v, err := newRangedValue(exprPrefix+tc.source, tc.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := v.(SeriesValueGenerator).ValueAt(1, time.Unix(1600000000, 0))
+		var f float64
+		if err := json.Unmarshal([]byte(got), &f); err != nil {
+			t.Errorf("%s rendered %q: %v", tc.source, got, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s (range %v) = %s, want %s", tc.source, tc.r, got, tc.want)
+		}
+		if NonFiniteValues() != before+1 {
+			t.Errorf("%s wasn't counted", tc.source)
+		}
+	}
+}
+
+// TestExprRandom checks noise and rand are reproducible for a series and
+// time, and rand stays within its bounds
+func TestExprRandom(t *testing.T) {
+	v, err := NewValue(exprPrefix + "rand(10, 20) + noise(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := NewValue(exprPrefix + "rand(10, 20) + noise(1)")
+	at := time.Unix(1600000000, 0)
+	for series := uint64(0); series < 100; series++ {
+		a := v.(SeriesValueGenerator).ValueAt(series, at)
+		if b := w.(SeriesValueGenerator).ValueAt(series, at); a != b {
+			t.Fatalf("series %d: %s and %s", series, a, b)
+		}
+	}
+	r, _ := NewValue(exprPrefix + "rand(10, 20)")
+	for i := 0; i < 1000; i++ {
+		var f float64
+		json.Unmarshal([]byte(r.Next()), &f)
+		if f < 10 || f >= 20 {
+			t.Fatalf("rand(10, 20) = %v", f)
+		}
+	}
+}
+
+// TestExprAllocs checks evaluating an expression allocates nothing
+func TestExprAllocs(t *testing.T) {
+	e, err := compileExpr("50 + 10*sin(2*pi*(t/1800 + phase)) + max(noise(2), rand(0, 1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &exprVars{t: 1600000000, phase: 0.5}
+	if allocs := testing.AllocsPerRun(100, func() { e(v) }); allocs != 0 {
+		t.Errorf("%v allocations per evaluation", allocs)
+	}
+}
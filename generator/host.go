@@ -0,0 +1,309 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package generator builds the simulated collectd topology and renders the
+// metric and event payloads sent by the bench.
+package generator
+
+import (
+	"fmt"
+)
+
+//[{"values":[11035,219350],"dstypes":["derive","derive"],"dsnames":["read","write"],"time":1536615315.346,"interval":5.000,"host":"nfvha-compute1-lab-node","plugin":"virt","plugin_instance":"instance-0000002c","type":"disk_ops","type_instance":"vda"}]
+
+// pluginDesc describes a simulated collectd plugin. Descriptors are shared
+// by the plugins of every host with the same configuration, so the names,
+// data sources and payload template exist once however many hosts there are.
+// They are never modified after the topology is built.
+type pluginDesc struct {
+	name           string
+	interval       int
+	dstypes        []string
+	dsnames        []string
+	mtype          []string
+	typeInstance   []string
+	pluginInstance []string
+	// lifetime is how many seconds a plugin instance lasts before it is
+	// replaced, none are when 0
+	lifetime float64
+	// every is how many intervals of the run go by from one interval of
+	// the plugin to the next, every one when 0 or 1
+	every int
+	// typeRanges bounds the values by type, the plugin then having a
+	// value per data source of each type. It is nil when one value per
+	// data source serves all the types.
+	typeRanges []*ValueRange
+	timeFormat string
+	// severities is the cumulative severity distribution of the events and
+	// severityFlip the chance of a change per interval, drawn from the
+	// series and the timestamp when deterministic
+	severities    []float64
+	severityFlip  float64
+	deterministic bool
+	template      *metricTemplate
+}
+
+// valueSets returns how many sets of data source values the plugins of the
+// descriptor have: one per type when ranged by type, or else one
+func (d *pluginDesc) valueSets() int {
+	if d.typeRanges != nil {
+		return len(d.mtype)
+	}
+	return 1
+}
+
+// newPluginDesc returns a descriptor with its template rendered
+func newPluginDesc(d pluginDesc) *pluginDesc {
+	d.template = newTemplate(&d)
+	return &d
+}
+
+// Plugin is a simulated collectd plugin on a host, producing one message per
+// type, plugin instance and type instance combination. Only the host name
+// and the value state are per host.
+type Plugin struct {
+	*pluginDesc
+	hostname *string
+	values   []ValueGenerator
+	// severity holds the current event severity of every series, made on
+	// the first event with a severity distribution
+	severity []uint8
+}
+
+// Host is a simulated collectd agent
+type Host struct {
+	Name    string
+	Plugins []Plugin
+}
+
+// names returns the first n names of a dictionary or template, e.g. type0,
+// type1...
+func names(dictionary []string, template string, n int) []string {
+	s := make([]string, n)
+	for i := range s {
+		s[i] = name(dictionary, template, i)
+	}
+	return s
+}
+
+// Topology describes the simulated hosts. Hosts are numbered from
+// HostOffset, so several bench instances can simulate disjoint hosts.
+type Topology struct {
+	// HostPrefix goes before the name of every host from Naming.Host
+	HostPrefix string
+	Hosts      int
+	HostOffset int
+	// Plugins is the number of plugins of every host, each with Types
+	// types of TypeInstances type instances and PluginInstances plugin
+	// instances, at least one of each
+	Plugins         int
+	Types           int
+	TypeInstances   int
+	PluginInstances int
+	// IntervalSec is the interval of the plugins in seconds
+	IntervalSec int
+	// Variance is the percentage of hosts with one plugin instance more or
+	// fewer of each plugin, so the hosts aren't all alike; which hosts do
+	// depends on their names only
+	Variance float64
+	// Uptime adds the uptime plugin to every host
+	Uptime bool
+	// ValueGenerator names the registered ValueGenerator used for the
+	// plugin data sources, "random" when empty
+	ValueGenerator string
+	// Naming holds the templates of the host, plugin and type names
+	Naming Naming
+	// Ranges bounds the values of the plugins by plugin name, with "*" for
+	// the plugins not listed; it may be nil
+	Ranges map[string]ValueRange
+	// Format sets the payload encoding
+	Format Format
+}
+
+// GenerateHosts builds the simulated hosts of topo
+func GenerateHosts(topo Topology) ([]Host, error) {
+	// every plugin renders at least one series, so -plugins N yields
+	// exactly N plugins with messages per host
+	if topo.Hosts < 0 || topo.Plugins < 0 {
+		return nil, fmt.Errorf("invalid topology: %d hosts of %d plugins", topo.Hosts, topo.Plugins)
+	}
+	if topo.Types < 1 || topo.TypeInstances < 1 || topo.PluginInstances < 1 {
+		return nil, fmt.Errorf("invalid topology: plugins need at least one type, type instance and plugin instance (got %d, %d, %d)",
+			topo.Types, topo.TypeInstances, topo.PluginInstances)
+	}
+	if topo.Variance < 0 || topo.Variance > 100 {
+		return nil, fmt.Errorf("invalid topology: variance %v isn't between 0 and 100", topo.Variance)
+	}
+	variance := topo.Variance / 100
+	if topo.ValueGenerator == "" {
+		topo.ValueGenerator = "random"
+	}
+	naming, err := topo.Naming.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	format, err := topo.Format.check()
+	if err != nil {
+		return nil, err
+	}
+
+	// every host has the same plugins, so they share the descriptors
+	severities := format.severityDist()
+	dict := naming.Dictionary
+	mtypes := names(dict.Types, naming.Type, topo.Types)
+	typeInstances := names(dict.TypeInstances, naming.TypeInstance, topo.TypeInstances)
+	descRanges := make([]*ValueRange, topo.Plugins)
+	// describe returns the descriptor of plugin j with instances plugin
+	// instances
+	describe := func(j, instances int) (*pluginDesc, error) {
+		pluginName := name(dict.Plugins, naming.Plugin, j)
+		desc := pluginDesc{
+			name:           pluginName,
+			interval:       format.declared(topo.IntervalSec),
+			mtype:          mtypes,
+			typeInstance:   typeInstances,
+			pluginInstance: names(dict.PluginInstances, naming.PluginInstance, instances),
+			dstypes:        []string{"derive"},
+			dsnames:        []string{"samples"},
+			timeFormat:     format.Time,
+			severities:     severities,
+			severityFlip:   format.SeverityFlip,
+			deterministic:  format.Deterministic,
+		}
+		if shape, ok := dict.Shapes[pluginName]; ok {
+			if err := shape.apply(&desc, topo.IntervalSec, topo.Types, topo.TypeInstances, instances, descRanges[j]); err != nil {
+				return nil, err
+			}
+			if desc.every > 1 {
+				desc.interval = format.declared(desc.every * topo.IntervalSec)
+			}
+		}
+		return newPluginDesc(desc), nil
+	}
+	descs := make([]*pluginDesc, topo.Plugins)
+	// the varied hosts have the descriptors with one instance fewer or
+	// more, fewer only while there is more than one
+	var fewer, more []*pluginDesc
+	if variance > 0 {
+		fewer, more = make([]*pluginDesc, topo.Plugins), make([]*pluginDesc, topo.Plugins)
+	}
+	for j := range descs {
+		pluginName := name(dict.Plugins, naming.Plugin, j)
+		if r, ok := topo.Ranges[pluginName]; ok {
+			descRanges[j] = &r
+		} else if r, ok := topo.Ranges["*"]; ok {
+			descRanges[j] = &r
+		}
+		if descs[j], err = describe(j, topo.PluginInstances); err != nil {
+			return nil, err
+		}
+		if variance == 0 {
+			continue
+		}
+		if more[j], err = describe(j, topo.PluginInstances+1); err != nil {
+			return nil, err
+		}
+		fewer[j] = more[j]
+		if topo.PluginInstances > 1 {
+			if fewer[j], err = describe(j, topo.PluginInstances-1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	uptimeDesc := newPluginDesc(pluginDesc{
+		name:           "uptime",
+		dstypes:        []string{"gauge"},
+		dsnames:        []string{"value"},
+		interval:       format.declared(5),
+		pluginInstance: []string{""},
+		mtype:          []string{"uptime"},
+		typeInstance:   []string{""},
+		timeFormat:     format.Time,
+	})
+
+	numHostPlugins := topo.Plugins
+	if topo.Uptime {
+		numHostPlugins++
+	}
+	// a value per data source of every plugin, or of every type of the
+	// plugins ranged by type, the uptime last
+	numValues := 0
+	for _, d := range descs {
+		numValues += len(d.dsnames) * d.valueSets()
+	}
+	uptimeValue := numValues
+	if topo.Uptime {
+		numValues++
+	}
+
+	hosts := make([]Host, topo.Hosts)
+	for i := 0; i < topo.Hosts; i++ {
+		hosts[i].Name = topo.HostPrefix + formatName(naming.Host, topo.HostOffset+i)
+		hosts[i].Plugins = make([]Plugin, 0, numHostPlugins)
+		// one backing array for the values of all plugins of the host
+		values := make([]ValueGenerator, numValues)
+
+		if topo.Uptime {
+			//
+			// Prepend uptime plugin simulation for each host if requested
+			//
+			values[uptimeValue] = newUptime()
+			hosts[i].Plugins = append(hosts[i].Plugins, Plugin{
+				pluginDesc: uptimeDesc,
+				hostname:   &hosts[i].Name,
+				values:     values[uptimeValue : uptimeValue+1],
+			})
+		}
+
+		next := 0
+		for j := 0; j < topo.Plugins; j++ {
+			first := next
+			for set := 0; set < descs[j].valueSets(); set++ {
+				r := descRanges[j]
+				if descs[j].typeRanges != nil {
+					r = descs[j].typeRanges[set]
+				}
+				for range descs[j].dsnames {
+					value, err := newRangedValue(topo.ValueGenerator, r)
+					if err != nil {
+						return nil, err
+					}
+					values[next] = value
+					next++
+				}
+			}
+			desc := descs[j]
+			if variance > 0 {
+				r := mix64(hashNames(hosts[i].Name, "/", desc.name))
+				if float64(r>>11)/(1<<53) < variance {
+					desc = more[j]
+					if r&1 == 0 {
+						desc = fewer[j]
+					}
+				}
+			}
+			hosts[i].Plugins = append(hosts[i].Plugins, Plugin{
+				pluginDesc: desc,
+				hostname:   &hosts[i].Name,
+				values:     values[first:next],
+			})
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,322 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bufferPool recycles the scratch buffers payloads are rendered into, which
+// saves growing a fresh builder for every message
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, 1024))
+	},
+}
+
+// EachMetricMessage renders the plugin's metric payloads one at a time and
+// calls fn with each, stopping early if fn returns false. The payload is
+// rendered into a reused buffer and is only valid until fn returns, so
+// callers copy it out (e.g. into a pooled message body) rather than keep it.
+func (m *Plugin) EachMetricMessage(fn func(payload []byte) bool) {
+	m.EachMetricMessageAt(time.Now(), fn)
+}
+
+// EachMetricMessageAt renders the metric payloads with the timestamp t
+func (m *Plugin) EachMetricMessageAt(t time.Time, fn func(payload []byte) bool) {
+	tmpl := m.template
+
+	var scratch [32]byte
+	now := appendTime(scratch[:0], t, m.timeFormat)
+	var incarnation [8]byte
+
+	sb := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(sb)
+	for series, suffix := range tmpl.suffixes {
+		sb.Reset()
+
+		sb.Write(tmpl.prefix)
+		values := m.seriesValues(series)
+		for i := 0; i < len(values); i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			if v, ok := values[i].(SeriesValueGenerator); ok {
+				sb.WriteString(v.ValueAt(m.seriesKey(series, i), t))
+				continue
+			}
+			sb.WriteString(values[i].Next())
+		}
+		sb.Write(tmpl.middle)
+		sb.Write(now)
+		sb.Write(tmpl.host)
+		sb.WriteString(*m.hostname)
+		if m.lifetime > 0 {
+			end := tmpl.instanceEnd[series]
+			sb.Write(suffix[:end])
+			sb.Write(m.appendIncarnation(incarnation[:0], series/len(m.typeInstance)%len(m.pluginInstance), t))
+			sb.Write(suffix[end:])
+		} else {
+			sb.Write(suffix)
+		}
+
+		if !fn(sb.Bytes()) {
+			return
+		}
+	}
+}
+
+// GetMetricMessage generate mock collectd metric messages. It allocates a
+// string per message; prefer EachMetricMessage in the send path.
+func (m *Plugin) GetMetricMessage() (msgs []string) {
+	msgs = make([]string, 0, m.Series())
+	m.EachMetricMessage(func(payload []byte) bool {
+		msgs = append(msgs, string(payload))
+		return true
+	})
+	return msgs
+}
+
+// Series returns the number of messages the plugin renders per interval
+func (m *Plugin) Series() int {
+	return len(m.mtype) * len(m.typeInstance) * len(m.pluginInstance)
+}
+
+// seriesValues returns the data source values of a series, those of its
+// type when the plugin is ranged by type
+func (m *Plugin) seriesValues(series int) []ValueGenerator {
+	if m.typeRanges == nil {
+		return m.values
+	}
+	n := len(m.dsnames)
+	t := series / (len(m.pluginInstance) * len(m.typeInstance))
+	return m.values[t*n : (t+1)*n]
+}
+
+// Every returns how many intervals of the run go by from one interval of
+// the plugin to the next, 1 for the plugins sending every interval
+func (m *Plugin) Every() int {
+	if m.every > 1 {
+		return m.every
+	}
+	return 1
+}
+
+// Due reports whether the plugin sends in interval i of the run. The slow
+// plugins of the hosts take turns, at a phase of their own.
+func (m *Plugin) Due(i int) bool {
+	if m.every <= 1 {
+		return true
+	}
+	phase := int(hashNames(*m.hostname, "/", m.name) % uint64(m.every))
+	return (i+phase)%m.every == 0
+}
+
+// seriesKey identifies data source ds of a series of the plugin on its host,
+// the same in every run. It hashes the names with 64 bit FNV-1a.
+func (m *Plugin) seriesKey(series, ds int) uint64 {
+	return mix64(hashNames(*m.hostname, "/", m.name) ^ uint64(series)<<16 ^ uint64(ds))
+}
+
+// hashNames hashes the concatenated names with 64 bit FNV-1a
+func hashNames(names ...string) uint64 {
+	h := uint64(14695981039346656037)
+	for _, s := range names {
+		for i := 0; i < len(s); i++ {
+			h ^= uint64(s[i])
+			h *= 1099511628211
+		}
+	}
+	return h
+}
+
+// podAlphabet holds the characters of the random pod name suffixes
+const podAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+// instanceName returns the name of plugin instance i at t
+func (m *Plugin) instanceName(i int, t time.Time) string {
+	if m.lifetime == 0 {
+		return m.pluginInstance[i]
+	}
+	var scratch [8]byte
+	return m.pluginInstance[i] + string(m.appendIncarnation(scratch[:0], i, t))
+}
+
+// appendIncarnation appends the suffix of the incarnation of plugin instance
+// i alive at t, e.g. -x7k2p. Each instance is replaced every lifetime
+// seconds at a phase of its own. The suffixes derive from the host and
+// instance names only, so the plugins of a host replace an instance
+// together.
+func (m *Plugin) appendIncarnation(b []byte, i int, t time.Time) []byte {
+	h := hashNames(*m.hostname, "/", m.pluginInstance[i])
+	phase := float64(h>>11) / (1 << 53)
+	incarnation := uint64(math.Floor(float64(t.UnixNano())/1e9/m.lifetime + phase))
+	r := mix64(h ^ incarnation)
+	b = append(b, '-')
+	for k := 0; k < 5; k++ {
+		b = append(b, podAlphabet[r%uint64(len(podAlphabet))])
+		r /= uint64(len(podAlphabet))
+	}
+	return b
+}
+
+// mix64 scrambles the bits of x (the splitmix64 finalizer)
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// appendTime appends t to b in a metric time format
+func appendTime(b []byte, t time.Time, format string) []byte {
+	switch format {
+	case "seconds":
+		return strconv.AppendInt(b, t.Unix(), 10)
+	case "millis":
+		return strconv.AppendInt(b, t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return strconv.AppendFloat(b, float64((t.UnixNano()))/1000000000, 'f', 4, 64)
+}
+
+// appendInt appends the decimal form of i to b
+func appendInt(b []byte, i int) []byte {
+	return strconv.AppendInt(b, int64(i), 10)
+}
+
+// EachEventMessage renders the plugin's event payloads the same way
+// EachMetricMessage renders its metrics
+func (m *Plugin) EachEventMessage(fn func(payload []byte) bool) {
+	m.EachEventMessageAt(time.Now(), fn)
+}
+
+// EachEventMessageAt renders the event payloads starting at t
+func (m *Plugin) EachEventMessageAt(t time.Time, fn func(payload []byte) bool) {
+	m.eachEvent(t, "", fn)
+}
+
+// EachEventMessageSeverity renders the event payloads starting at t, all
+// with the given severity rather than the distribution's
+func (m *Plugin) EachEventMessageSeverity(t time.Time, severity string, fn func(payload []byte) bool) {
+	m.eachEvent(t, severity, fn)
+}
+
+// eachEvent renders the events with severity, or if empty with the
+// current severities of the series
+func (m *Plugin) eachEvent(t time.Time, severity string, fn func(payload []byte) bool) {
+	startsAt := t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+
+	sb := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(sb)
+	series := 0
+	for typeIter := 0; typeIter < len(m.mtype)*len(m.typeInstance); typeIter++ {
+		for pInstance := 0; pInstance < len(m.pluginInstance); pInstance++ {
+			instance := m.instanceName(pInstance, t)
+			sev := severity
+			if sev == "" {
+				sev = m.nextSeverity(series, t)
+			}
+			series++
+			sb.Reset()
+			sb.WriteString(`[
+				{
+					"labels":{
+						"alertname":"event_interface_if_octets",
+						"instance":"` + *m.hostname + `",
+						"` + m.name + `":"` + instance + `",
+						"severity":"` + sev + `",
+						"service":"collectd"
+					},
+					"annotations":{
+						"summary":"Host ` + *m.hostname + `, plugin ` + m.name + ` (instance ` + instance + `) type if octets: Everything around you that you call life was made up by people that were no smarter than you.",
+						"DataSource":"rx",
+						"FailureMin":"nan",
+						"FailureMax":"nan"
+					},
+					"startsAt":"` + startsAt + `"
+				}
+			]`)
+
+			if !fn(sb.Bytes()) {
+				return
+			}
+		}
+	}
+}
+
+// chanceDraw and chanceFlip tell the chances of the severities of a
+// series apart from its data sources in the series keys
+const (
+	chanceDraw = 1<<8 + iota
+	chanceFlip
+)
+
+// nextSeverity returns the severity of the next event of a series at t
+func (m *Plugin) nextSeverity(series int, t time.Time) string {
+	if m.severities == nil {
+		return Severities[0]
+	}
+	if m.severity == nil {
+		m.severity = make([]uint8, m.Series())
+		for i := range m.severity {
+			m.severity[i] = m.drawSeverity(m.chance(i, chanceDraw, t))
+		}
+	} else if m.severityFlip >= 1 || m.chance(series, chanceFlip, t) < m.severityFlip {
+		m.severity[series] = m.drawSeverity(m.chance(series, chanceDraw, t))
+	}
+	return Severities[m.severity[series]]
+}
+
+// chance returns a number in [0, 1): random, or when deterministic hashed
+// from the series, what the number is for and t as hashValue does
+func (m *Plugin) chance(series, what int, t time.Time) float64 {
+	if !m.deterministic {
+		return rand.Float64()
+	}
+	x := mix64(m.seriesKey(series, what) ^ uint64(t.UnixNano()))
+	return float64(x>>11) / (1 << 53)
+}
+
+// drawSeverity picks the severity of the distribution r falls into
+func (m *Plugin) drawSeverity(r float64) uint8 {
+	for i, p := range m.severities {
+		if r < p {
+			return uint8(i)
+		}
+	}
+	return uint8(len(m.severities) - 1)
+}
+
+// GetEventMessage generate mock collectd event messages
+func (m *Plugin) GetEventMessage() (msgs []string) {
+	msgs = make([]string, 0, m.Series())
+	m.EachEventMessage(func(payload []byte) bool {
+		msgs = append(msgs, string(payload))
+		return true
+	})
+	return msgs
+}
@@ -0,0 +1,164 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// benchPlugin returns the first plugin of a single host with the given
+// number of series
+func benchPlugin(b *testing.B, types, typeInstances, pluginInstances int) *Plugin {
+	hosts, err := GenerateHosts(Topology{
+		HostPrefix: "bench", Hosts: 1, Plugins: 1, Types: types, TypeInstances: typeInstances, PluginInstances: pluginInstances, IntervalSec: 10, Naming: DefaultNaming,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &hosts[0].Plugins[0]
+}
+
+// seriesCounts are the plugin sizes the message benchmarks run with
+var seriesCounts = []int{1, 10, 100}
+
+func BenchmarkGetMetricMessage(b *testing.B) {
+	for _, n := range seriesCounts {
+		b.Run(fmt.Sprintf("series=%d", n), func(b *testing.B) {
+			p := benchPlugin(b, 1, n, 1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.GetMetricMessage()
+			}
+		})
+	}
+}
+
+func BenchmarkEachMetricMessage(b *testing.B) {
+	for _, n := range seriesCounts {
+		b.Run(fmt.Sprintf("series=%d", n), func(b *testing.B) {
+			p := benchPlugin(b, 1, n, 1)
+			body := make([]byte, 0, 1024)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.EachMetricMessage(func(payload []byte) bool {
+					body = append(body[:0], payload...)
+					return true
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkGetEventMessage(b *testing.B) {
+	for _, n := range seriesCounts {
+		b.Run(fmt.Sprintf("series=%d", n), func(b *testing.B) {
+			p := benchPlugin(b, 1, n, 1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.GetEventMessage()
+			}
+		})
+	}
+}
+
+// BenchmarkNewTemplate measures rendering the constant parts of a plugin's
+// payloads, which happens once per plugin descriptor
+func BenchmarkNewTemplate(b *testing.B) {
+	for _, n := range seriesCounts {
+		b.Run(fmt.Sprintf("series=%d", n), func(b *testing.B) {
+			p := benchPlugin(b, 1, n, 1)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				newTemplate(p.pluginDesc)
+			}
+		})
+	}
+}
+
+// collectdMetric is the collectd write_http JSON format, used to compare
+// the templates against a plain encoding/json implementation
+type collectdMetric struct {
+	Values         []float64 `json:"values"`
+	DSTypes        []string  `json:"dstypes"`
+	DSNames        []string  `json:"dsnames"`
+	Time           float64   `json:"time"`
+	Interval       int       `json:"interval"`
+	Host           string    `json:"host"`
+	Plugin         string    `json:"plugin"`
+	PluginInstance string    `json:"plugin_instance"`
+	Type           string    `json:"type"`
+	TypeInstance   string    `json:"type_instance"`
+}
+
+// BenchmarkMarshalJSON is the baseline for BenchmarkGetMetricMessage,
+// marshaling the same payload with encoding/json
+func BenchmarkMarshalJSON(b *testing.B) {
+	m := []collectdMetric{{
+		Values:         []float64{42},
+		DSTypes:        []string{"derive"},
+		DSNames:        []string{"samples"},
+		Interval:       10,
+		Host:           "benchhostname000",
+		Plugin:         "metrics000",
+		PluginInstance: "pluginInst0",
+		Type:           "type0",
+		TypeInstance:   "typInst0",
+	}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m[0].Time = float64(time.Now().UnixNano()) / 1000000000
+		if _, err := json.Marshal(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValues(b *testing.B) {
+	for _, name := range Values() {
+		b.Run(name, func(b *testing.B) {
+			v, err := NewValue(name)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				v.Next()
+			}
+		})
+	}
+}
+
+func BenchmarkGenerateHosts(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := GenerateHosts(Topology{
+			HostPrefix: "bench", Hosts: 100, Plugins: 10, Types: 1, TypeInstances: 1, PluginInstances: 1, IntervalSec: 10, Uptime: true, Naming: DefaultNaming,
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
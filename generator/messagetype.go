@@ -0,0 +1,71 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Renderer renders the payloads of a plugin with the timestamp t, calling
+// fn with each until it returns false, like EachMetricMessageAt. A custom
+// message type can render the metrics and rewrite them into a format of
+// its own.
+type Renderer func(p *Plugin, t time.Time, fn func(payload []byte) bool)
+
+var (
+	messageTypesLock sync.RWMutex
+	messageTypes     = map[string]Renderer{}
+)
+
+// RegisterMessageType makes a custom message type available under name,
+// next to the built-in metrics, events and ceilometer
+func RegisterMessageType(name string, r Renderer) {
+	messageTypesLock.Lock()
+	defer messageTypesLock.Unlock()
+
+	if _, dup := messageTypes[name]; dup {
+		panic("generator: RegisterMessageType called twice for " + name)
+	}
+	messageTypes[name] = r
+}
+
+// MessageTypes returns the custom message types by name
+func MessageTypes() map[string]Renderer {
+	messageTypesLock.RLock()
+	defer messageTypesLock.RUnlock()
+
+	types := make(map[string]Renderer, len(messageTypes))
+	for name, r := range messageTypes {
+		types[name] = r
+	}
+	return types
+}
+
+// MessageTypeNames returns the names of the custom message types
+func MessageTypeNames() []string {
+	var names []string
+	for name := range MessageTypes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
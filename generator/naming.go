@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Naming holds the fmt templates the generated identities are built from.
+// Each template is formatted with the index of the host, plugin, type or
+// instance, e.g. metrics%03d gives metrics000, metrics001... A template
+// without a verb is used as is, for constant names. Empty templates fall
+// back to DefaultNaming. Names in the Dictionary take the place of the
+// templates.
+type Naming struct {
+	Host           string
+	Plugin         string
+	Type           string
+	TypeInstance   string
+	PluginInstance string
+	Dictionary     Dictionary
+}
+
+// DefaultNaming is the naming scheme of the bench
+var DefaultNaming = Naming{
+	Host:           "hostname%03d",
+	Plugin:         "metrics%03d",
+	Type:           "type%d",
+	TypeInstance:   "typInst%d",
+	PluginInstance: "pluginInst%d",
+}
+
+// withDefaults fills in the empty templates and checks that each formats
+// its index cleanly, and that the dictionary names can be written as is
+func (n Naming) withDefaults() (Naming, error) {
+	type namingTemplate struct {
+		kind     string
+		template *string
+		def      string
+	}
+	templates := []namingTemplate{
+		{"host", &n.Host, DefaultNaming.Host},
+		{"plugin", &n.Plugin, DefaultNaming.Plugin},
+		{"type", &n.Type, DefaultNaming.Type},
+		{"type instance", &n.TypeInstance, DefaultNaming.TypeInstance},
+		{"plugin instance", &n.PluginInstance, DefaultNaming.PluginInstance},
+	}
+	for plugin, shape := range n.Dictionary.Shapes {
+		if shape.PluginInstance != "" {
+			template := shape.PluginInstance
+			templates = append(templates, namingTemplate{plugin + " plugin instance", &template, DefaultNaming.PluginInstance})
+		}
+	}
+	for _, t := range templates {
+		if *t.template == "" {
+			*t.template = t.def
+		}
+		// fmt reports a bad or extra verb inline as %!
+		if strings.Contains(formatName(*t.template, 0), "%!") {
+			return n, fmt.Errorf("%s name template %q must format at most one integer, e.g. %s", t.kind, *t.template, t.def)
+		}
+		// the names are written into the payloads unescaped
+		if strings.ContainsAny(*t.template, `"\`) {
+			return n, fmt.Errorf("%s name template %q can't contain quotes or backslashes", t.kind, *t.template)
+		}
+	}
+	d := n.Dictionary
+	lists := [][]string{d.Plugins, d.PluginInstances, d.Types, d.TypeInstances}
+	for _, shape := range d.Shapes {
+		lists = append(lists, shape.PluginInstances, shape.Types, shape.TypeInstances, shape.DSTypes, shape.DSNames)
+	}
+	for _, names := range lists {
+		for _, name := range names {
+			if strings.ContainsAny(name, `"\`) {
+				return n, fmt.Errorf("dictionary name %q can't contain quotes or backslashes", name)
+			}
+		}
+	}
+	return n, nil
+}
+
+// name returns the name of index i, picked from the dictionary names if
+// there are any or else formatted from the template
+func name(dictionary []string, template string, i int) string {
+	if len(dictionary) > 0 {
+		return pick(dictionary, i)
+	}
+	return formatName(template, i)
+}
+
+// formatName returns the name of index i from a naming template
+func formatName(template string, i int) string {
+	if !strings.Contains(template, "%") {
+		return template
+	}
+	return fmt.Sprintf(template, i)
+}
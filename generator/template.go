@@ -0,0 +1,184 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Format holds the payload encoding and content options
+type Format struct {
+	// Time is the encoding of the metric "time" field, one of TimeFormats
+	Time string
+	// Interval is the interval the metrics declare in seconds, regardless
+	// of how often they are sent, when non-zero
+	Interval int
+	// Severities weighs the Severities of the events; all are OKAY when
+	// empty
+	Severities []float64
+	// SeverityFlip is the chance an event series changes severity from one
+	// interval to the next, so alerts stay raised for a while and flap.
+	// At 1 every event draws its severity afresh.
+	SeverityFlip float64
+	// Deterministic draws the severities and their changes from the series
+	// and the timestamp instead of the global random source, so runs with
+	// the same timestamps render the same events
+	Deterministic bool
+}
+
+// Severities are the collectd notification severities
+var Severities = []string{"OKAY", "WARNING", "FAILURE"}
+
+// declared returns the interval a plugin sending every interval seconds
+// declares
+func (f Format) declared(interval int) int {
+	if f.Interval > 0 {
+		return f.Interval
+	}
+	return interval
+}
+
+// TimeFormats are the encodings of the metric "time" field: seconds with
+// fractions as collectd sends them, whole seconds, or milliseconds
+var TimeFormats = []string{"float", "seconds", "millis"}
+
+// check returns an error for unknown options and fills in the defaults
+func (f Format) check() (Format, error) {
+	switch f.Time {
+	case "":
+		f.Time = "float"
+	case "float", "seconds", "millis":
+	default:
+		return f, fmt.Errorf("unknown time format %q (available: %v)", f.Time, TimeFormats)
+	}
+	if f.Interval < 0 {
+		return f, fmt.Errorf("invalid declared interval %d", f.Interval)
+	}
+	if len(f.Severities) > len(Severities) {
+		return f, fmt.Errorf("%d severity weights for %d severities", len(f.Severities), len(Severities))
+	}
+	if f.SeverityFlip < 0 || f.SeverityFlip > 1 {
+		return f, fmt.Errorf("severity flip chance %v isn't between 0 and 1", f.SeverityFlip)
+	}
+	return f, nil
+}
+
+// severityDist returns the cumulative severity distribution, nil when every
+// event is OKAY
+func (f Format) severityDist() []float64 {
+	var total float64
+	for _, w := range f.Severities {
+		total += w
+	}
+	if total <= 0 {
+		return nil
+	}
+	dist := make([]float64, len(f.Severities))
+	var sum float64
+	for i, w := range f.Severities {
+		sum += w
+		dist[i] = sum / total
+	}
+	return dist
+}
+
+// metricTemplate holds the parts of a plugin's metric payloads that don't
+// change between intervals or hosts, rendered once per plugin descriptor.
+// Only the values, the timestamp and the host name are rendered for each
+// message.
+type metricTemplate struct {
+	// prefix opens the payload up to the first value
+	prefix []byte
+	// middle runs from the end of the values to the time value
+	middle []byte
+	// host runs from after the time value to the host name
+	host []byte
+	// suffixes run from after the host name to the end of the payload,
+	// one per series in the order the messages are generated
+	suffixes [][]byte
+	// instanceEnd holds the offsets in the suffixes just past the plugin
+	// instance name, where the suffix of a churning instance goes. It is
+	// only set when the instances churn.
+	instanceEnd []int
+}
+
+// newTemplate renders the metric template of a plugin descriptor
+func newTemplate(d *pluginDesc) *metricTemplate {
+	var sb bytes.Buffer
+	sb.WriteString("], \"dstypes\": [")
+	for i := 0; i < len(d.dstypes); i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\"")
+		sb.WriteString(d.dstypes[i])
+		sb.WriteString("\"")
+	}
+
+	sb.WriteString("], \"dsnames\": [")
+	for i := 0; i < len(d.dsnames); i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("\"")
+		sb.WriteString(d.dsnames[i])
+		sb.WriteString("\"")
+	}
+	sb.WriteString("], \"time\": ")
+
+	host := []byte(", \"interval\": ")
+	host = appendInt(host, d.interval)
+	host = append(host, ", \"host\": \""...)
+
+	t := &metricTemplate{
+		prefix:   []byte("[{\"values\": ["),
+		middle:   append([]byte(nil), sb.Bytes()...),
+		host:     host,
+		suffixes: make([][]byte, 0, len(d.mtype)*len(d.typeInstance)*len(d.pluginInstance)),
+	}
+
+	for typeOffset := 0; typeOffset < len(d.mtype); typeOffset++ {
+		for pluginInstOffset := 0; pluginInstOffset < len(d.pluginInstance); pluginInstOffset++ {
+			for typeInstOffset := 0; typeInstOffset < len(d.typeInstance); typeInstOffset++ {
+				sb.Reset()
+				sb.WriteString("\", \"plugin\": \"")
+				sb.WriteString(d.name)
+
+				sb.WriteString("\",\"plugin_instance\": \"")
+				sb.WriteString(d.pluginInstance[pluginInstOffset])
+				if d.lifetime > 0 {
+					t.instanceEnd = append(t.instanceEnd, sb.Len())
+				}
+
+				sb.WriteString("\",\"type\": \"")
+				sb.WriteString(d.mtype[typeOffset])
+
+				sb.WriteString("\",\"type_instance\": \"")
+				sb.WriteString(d.typeInstance[typeInstOffset])
+
+				sb.WriteString("\"}]")
+
+				t.suffixes = append(t.suffixes, append([]byte(nil), sb.Bytes()...))
+			}
+		}
+	}
+	return t
+}
@@ -0,0 +1,261 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValueGenerator produces the successive values of one data source. Each
+// plugin data source gets its own ValueGenerator, so implementations may keep
+// state between calls; the state is shared by all series of the plugin.
+type ValueGenerator interface {
+	Next() string
+}
+
+// SeriesValueGenerator is a ValueGenerator whose values are a function of
+// the series and the timestamp rather than of the global random source.
+// The series key identifies one data source of a host's plugin series and
+// is the same in every run, so separate runs and replicas render identical
+// values. Counters also add up their earlier values, which are the same
+// given the same series and timestamps.
+type SeriesValueGenerator interface {
+	ValueGenerator
+	ValueAt(series uint64, t time.Time) string
+}
+
+// DeterministicValues are the value generators rendering the same payloads
+// in every run given the same series and timestamps
+var DeterministicValues = []string{"hash", "counter"}
+
+// IsDeterministic reports whether the value generator name is one of the
+// DeterministicValues
+func IsDeterministic(name string) bool {
+	for _, d := range DeterministicValues {
+		if name == d {
+			return true
+		}
+	}
+	return false
+}
+
+// ValueRange bounds the values of a data source. Gauges stay within [Min,
+// Max] and random walks move by at most Step per value; counters start at
+// Min and grow by at most Step per value. A zero Step leaves the generator
+// its default.
+type ValueRange struct {
+	Min, Max, Step float64
+}
+
+// RangedValueGenerator is a ValueGenerator whose values can be bounded, so
+// they look like e.g. CPU percentages or temperatures
+type RangedValueGenerator interface {
+	ValueGenerator
+	SetRange(r ValueRange)
+}
+
+// ValueFunc adapts a stateless function to the ValueGenerator interface
+type ValueFunc func() string
+
+// Next calls f()
+func (f ValueFunc) Next() string {
+	return f()
+}
+
+// ValueFactory creates a new, independent ValueGenerator
+type ValueFactory func() ValueGenerator
+
+var (
+	valuesLock sync.RWMutex
+	values     = map[string]ValueFactory{}
+)
+
+func init() {
+	RegisterValue("random", func() ValueGenerator { return &uniform{min: 0, max: 1} })
+	RegisterValue("uptime", func() ValueGenerator { return newUptime() })
+	RegisterValue("counter", func() ValueGenerator { return &counter{step: 100} })
+	RegisterValue("randomwalk", func() ValueGenerator { return &randomWalk{value: 50, step: 1, min: 0, max: 100} })
+	RegisterValue("hash", func() ValueGenerator { return &hashValue{min: 0, max: 1} })
+}
+
+// RegisterValue makes a ValueGenerator available under name
+func RegisterValue(name string, factory ValueFactory) {
+	valuesLock.Lock()
+	defer valuesLock.Unlock()
+
+	if _, dup := values[name]; dup {
+		panic("generator: RegisterValue called twice for " + name)
+	}
+	values[name] = factory
+}
+
+// Values returns the names of the registered value generators
+func Values() []string {
+	valuesLock.RLock()
+	defer valuesLock.RUnlock()
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewValue creates a ValueGenerator registered under name, or evaluating
+// the expression after expr: in name, e.g. expr:50 + 10*sin(t/300) + noise(2)
+func NewValue(name string) (ValueGenerator, error) {
+	if strings.HasPrefix(name, exprPrefix) {
+		e, err := compileExpr(strings.TrimPrefix(name, exprPrefix))
+		if err != nil {
+			return nil, err
+		}
+		return newExprValue(e), nil
+	}
+	valuesLock.RLock()
+	factory, ok := values[name]
+	valuesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown value generator %q (available: %v)", name, Values())
+	}
+	return factory(), nil
+}
+
+// newRangedValue creates a ValueGenerator registered under name and bounds
+// it by r, if given
+func newRangedValue(name string, r *ValueRange) (ValueGenerator, error) {
+	v, err := NewValue(name)
+	if err != nil || r == nil {
+		return v, err
+	}
+	ranged, ok := v.(RangedValueGenerator)
+	if !ok {
+		return nil, fmt.Errorf("value generator %s doesn't take a value range", name)
+	}
+	ranged.SetRange(*r)
+	return v, nil
+}
+
+// uniform is a random value within [min, max]
+type uniform struct {
+	min, max float64
+}
+
+func (u *uniform) Next() string {
+	return strconv.FormatFloat(u.min+rand.Float64()*(u.max-u.min), 'f', 4, 64)
+}
+
+func (u *uniform) SetRange(r ValueRange) {
+	u.min, u.max = r.Min, r.Max
+}
+
+// hashValue hashes the series and the timestamp into a value within [min,
+// max], so it looks random but is a pure function of both
+type hashValue struct {
+	min, max float64
+}
+
+// Next is only used outside a series, e.g. by direct callers
+func (h *hashValue) Next() string {
+	return strconv.FormatFloat(h.min+rand.Float64()*(h.max-h.min), 'f', 4, 64)
+}
+
+func (h *hashValue) ValueAt(series uint64, t time.Time) string {
+	x := mix64(series ^ uint64(t.UnixNano()))
+	return strconv.FormatFloat(h.min+float64(x>>11)/(1<<53)*(h.max-h.min), 'f', 4, 64)
+}
+
+func (h *hashValue) SetRange(r ValueRange) {
+	h.min, h.max = r.Min, r.Max
+}
+
+// uptime reports the seconds elapsed since it was created
+type uptime struct {
+	start time.Time
+}
+
+func newUptime() *uptime {
+	return &uptime{start: time.Now()}
+}
+
+func (u *uptime) Next() string {
+	uptime := time.Now().Sub(u.start)
+
+	return strconv.Itoa(int(uptime.Seconds()))
+}
+
+// counter is a monotonically increasing value, suitable for derive and
+// counter data sources
+type counter struct {
+	value uint64
+	step  int
+}
+
+func (c *counter) Next() string {
+	c.value += uint64(rand.Intn(c.step))
+	return strconv.FormatUint(c.value, 10)
+}
+
+// ValueAt grows the counter by a step hashed from the series and the
+// timestamp, rather than a random one
+func (c *counter) ValueAt(series uint64, t time.Time) string {
+	c.value += mix64(series^uint64(t.UnixNano())) % uint64(c.step)
+	return strconv.FormatUint(c.value, 10)
+}
+
+// SetRange starts the counter at Min and grows it by less than Step
+func (c *counter) SetRange(r ValueRange) {
+	c.value = uint64(r.Min)
+	if r.Step >= 1 {
+		c.step = int(r.Step)
+	}
+}
+
+// randomWalk moves up or down by at most step on each call, staying within
+// [min, max]
+type randomWalk struct {
+	value, step, min, max float64
+}
+
+func (w *randomWalk) Next() string {
+	w.value += (rand.Float64()*2 - 1) * w.step
+	if w.value < w.min {
+		w.value = w.min
+	} else if w.value > w.max {
+		w.value = w.max
+	}
+	return strconv.FormatFloat(w.value, 'f', 4, 64)
+}
+
+// SetRange starts the walk in the middle of the range, stepping by 1% of
+// the range unless told otherwise
+func (w *randomWalk) SetRange(r ValueRange) {
+	w.min, w.max, w.step = r.Min, r.Max, r.Step
+	if w.step == 0 {
+		w.step = (r.Max - r.Min) / 100
+	}
+	w.value = (r.Min + r.Max) / 2
+}
@@ -0,0 +1,54 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// EWMA is an exponentially weighted moving average of a rate over a time
+// window, smoothing the per interval rates so the trend of a long run
+// shows. The weight of a sample follows the time it covers, so irregular
+// intervals average correctly. It isn't safe for concurrent use.
+type EWMA struct {
+	// Window is the time constant: samples older than it weigh 1/e in
+	// the average
+	Window  time.Duration
+	value   float64
+	started bool
+}
+
+// Add averages in the rate measured over the last elapsed and returns the
+// new average. The first rate is taken as is.
+func (e *EWMA) Add(rate float64, elapsed time.Duration) float64 {
+	if !e.started || e.Window <= 0 {
+		e.value, e.started = rate, true
+		return e.value
+	}
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(e.Window))
+	e.value += alpha * (rate - e.value)
+	return e.value
+}
+
+// Value returns the current average, 0 before any rate is added
+func (e *EWMA) Value() float64 {
+	return e.value
+}
@@ -0,0 +1,104 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Latencies is a histogram of latencies, or any other durations, in
+// microseconds, with the buckets of the message sizes, for the percentiles
+// of a whole run in constant memory. Any goroutine may add to it.
+type Latencies struct {
+	count   int64
+	total   int64
+	max     int64
+	buckets [sizeBuckets]int64
+}
+
+// Add counts a latency of d
+func (l *Latencies) Add(d time.Duration) {
+	us := int64(d / time.Microsecond)
+	if us < 0 {
+		us = 0
+	}
+	atomic.AddInt64(&l.buckets[sizeBucket(int(us))], 1)
+	atomic.AddInt64(&l.count, 1)
+	atomic.AddInt64(&l.total, us)
+	for {
+		max := atomic.LoadInt64(&l.max)
+		if us <= max || atomic.CompareAndSwapInt64(&l.max, max, us) {
+			return
+		}
+	}
+}
+
+// Count returns the number of latencies added
+func (l *Latencies) Count() int64 {
+	return atomic.LoadInt64(&l.count)
+}
+
+// Mean returns the average latency, 0 without latencies
+func (l *Latencies) Mean() time.Duration {
+	count := l.Count()
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(float64(atomic.LoadInt64(&l.total)) / float64(count) * float64(time.Microsecond))
+}
+
+// Merge adds the latencies of o, e.g. to total those of several threads
+func (l *Latencies) Merge(o *Latencies) {
+	for b := range o.buckets {
+		atomic.AddInt64(&l.buckets[b], atomic.LoadInt64(&o.buckets[b]))
+	}
+	atomic.AddInt64(&l.count, o.Count())
+	atomic.AddInt64(&l.total, atomic.LoadInt64(&o.total))
+	us := atomic.LoadInt64(&o.max)
+	for {
+		max := atomic.LoadInt64(&l.max)
+		if us <= max || atomic.CompareAndSwapInt64(&l.max, max, us) {
+			return
+		}
+	}
+}
+
+// Quantile returns the latency under which fraction q of the latencies
+// fall, the upper bound of its bucket, 0 without latencies
+func (l *Latencies) Quantile(q float64) time.Duration {
+	count := l.Count()
+	if count == 0 {
+		return 0
+	}
+	max := atomic.LoadInt64(&l.max)
+	rank := int64(q * float64(count))
+	var seen int64
+	for b := range l.buckets {
+		seen += atomic.LoadInt64(&l.buckets[b])
+		if seen > rank {
+			if us := bucketSize(b); us < max {
+				return time.Duration(us) * time.Microsecond
+			}
+			break
+		}
+	}
+	return time.Duration(max) * time.Microsecond
+}
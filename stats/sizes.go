@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package stats
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+)
+
+// sizeBuckets is the number of message size buckets: exact below 8 bytes,
+// then eight per power of two up to 2GB, which keeps the percentiles within
+// 12.5% of the real sizes
+const sizeBuckets = 232
+
+// sizeBucket returns the bucket of a message of n bytes
+func sizeBucket(n int) int {
+	if n < 8 {
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+	e := bits.Len(uint(n)) - 1
+	if e > 30 {
+		return sizeBuckets - 1
+	}
+	return (e-2)*8 + (n>>uint(e-3))&7
+}
+
+// bucketSize returns the largest size counted in bucket i
+func bucketSize(i int) int64 {
+	if i < 8 {
+		return int64(i)
+	}
+	e := uint(i/8 + 2)
+	return int64(8+i%8+1)<<(e-3) - 1
+}
+
+// sizes is the size histogram of the messages of one send thread
+type sizes struct {
+	bytes   int64
+	min     int64
+	max     int64
+	buckets [sizeBuckets]int64
+}
+
+// SizeSummary is the distribution of the sizes of the sent messages.
+// The percentiles are the upper bounds of their histogram buckets.
+type SizeSummary struct {
+	Count         int64
+	Bytes         int64
+	Min, Max      int64
+	P50, P90, P99 int64
+}
+
+// Size counts a message of n bytes sent by a send thread. Only that thread
+// writes its histogram, so min and max need no compare and swap.
+func (s *Stats) Size(thread, n int) {
+	h := &s.threads[thread].sizes
+	atomic.AddInt64(&h.bytes, int64(n))
+	atomic.AddInt64(&h.buckets[sizeBucket(n)], 1)
+	if size := int64(n); size > atomic.LoadInt64(&h.max) {
+		atomic.StoreInt64(&h.max, size)
+	}
+	if size := int64(n) + 1; atomic.LoadInt64(&h.min) == 0 || size < atomic.LoadInt64(&h.min) {
+		// min holds the size plus one, so zero means none yet
+		atomic.StoreInt64(&h.min, size)
+	}
+}
+
+// Sizes merges the size histograms of all the send threads
+func (s *Stats) Sizes() SizeSummary {
+	var sum SizeSummary
+	var buckets [sizeBuckets]int64
+	sum.Min = -1
+	for i := range s.threads {
+		h := &s.threads[i].sizes
+		sum.Bytes += atomic.LoadInt64(&h.bytes)
+		if max := atomic.LoadInt64(&h.max); max > sum.Max {
+			sum.Max = max
+		}
+		if min := atomic.LoadInt64(&h.min) - 1; min >= 0 && (sum.Min < 0 || min < sum.Min) {
+			sum.Min = min
+		}
+		for b := range buckets {
+			n := atomic.LoadInt64(&h.buckets[b])
+			buckets[b] += n
+			sum.Count += n
+		}
+	}
+
+	at := func(q float64) int64 {
+		rank := int64(q * float64(sum.Count))
+		var seen int64
+		for b, n := range buckets {
+			seen += n
+			if seen > rank {
+				if size := bucketSize(b); size < sum.Max {
+					return size
+				}
+				return sum.Max
+			}
+		}
+		return sum.Max
+	}
+	if sum.Min < 0 {
+		sum.Min = 0
+	}
+	if sum.Count > 0 {
+		sum.P50, sum.P90, sum.P99 = at(0.5), at(0.9), at(0.99)
+	}
+	return sum
+}
+
+// Mean returns the average message size
+func (s SizeSummary) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.Count)
+}
+
+func (s SizeSummary) String() string {
+	return fmt.Sprintf("%d messages, %d bytes, min %d, mean %.0f, p50 %d, p90 %d, p99 %d, max %d",
+		s.Count, s.Bytes, s.Min, s.Mean(), s.P50, s.P90, s.P99, s.Max)
+}
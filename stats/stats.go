@@ -0,0 +1,172 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package stats collects the counters of a bench run. Counters are updated
+// atomically from any goroutine, and all reporting reads them through a
+// consistent Snapshot.
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// threadCounter is padded to its own cache lines so send threads don't
+// contend on each other's counters
+type threadCounter struct {
+	sent  int64
+	sizes sizes
+	_     [56]byte
+}
+
+// Stats holds the counters of one run
+type Stats struct {
+	start     time.Time
+	threads   []threadCounter
+	generated int64
+	failed    int64
+	acked     int64
+	received  int64
+	intervals int64
+}
+
+// New returns Stats for a run with the given number of send threads
+func New(threads int) *Stats {
+	return &Stats{
+		start:   time.Now(),
+		threads: make([]threadCounter, threads),
+	}
+}
+
+// Resume carries the counters of an earlier run over, from a checkpoint,
+// so that the totals and the elapsed time cover both. The sends of threads
+// beyond the current ones are added to the last thread.
+func (s *Stats) Resume(prev Snapshot) {
+	s.start = s.start.Add(-prev.Elapsed)
+	atomic.AddInt64(&s.generated, prev.Generated)
+	atomic.AddInt64(&s.failed, prev.Failed)
+	atomic.AddInt64(&s.acked, prev.Acked)
+	atomic.AddInt64(&s.received, prev.Received)
+	atomic.AddInt64(&s.intervals, prev.Intervals)
+	for i, sent := range prev.ThreadSent {
+		if len(s.threads) == 0 {
+			break
+		}
+		if i >= len(s.threads) {
+			i = len(s.threads) - 1
+		}
+		atomic.AddInt64(&s.threads[i].sent, sent)
+	}
+}
+
+// Sent counts a message sent by a send thread
+func (s *Stats) Sent(thread int) {
+	atomic.AddInt64(&s.threads[thread].sent, 1)
+}
+
+// Failed counts a message that couldn't be sent
+func (s *Stats) Failed() {
+	atomic.AddInt64(&s.failed, 1)
+}
+
+// Acked counts an acknowledged message
+func (s *Stats) Acked() {
+	atomic.AddInt64(&s.acked, 1)
+}
+
+// Generated counts n generated messages
+func (s *Stats) Generated(n int) {
+	atomic.AddInt64(&s.generated, int64(n))
+}
+
+// Received counts a message consumed in receive mode
+func (s *Stats) Received() {
+	atomic.AddInt64(&s.received, 1)
+}
+
+// StartInterval counts the start of a generation interval
+func (s *Stats) StartInterval() {
+	atomic.AddInt64(&s.intervals, 1)
+}
+
+// Intervals returns the number of intervals started so far
+func (s *Stats) Intervals() int64 {
+	return atomic.LoadInt64(&s.intervals)
+}
+
+// Snapshot is a point in time copy of the counters
+type Snapshot struct {
+	Time       time.Time
+	Elapsed    time.Duration
+	ThreadSent []int64
+	Sent       int64
+	Generated  int64
+	Failed     int64
+	Acked      int64
+	Received   int64
+	Intervals  int64
+}
+
+// Snapshot returns the current value of every counter
+func (s *Stats) Snapshot() Snapshot {
+	now := time.Now()
+	snap := Snapshot{
+		Time:       now,
+		Elapsed:    now.Sub(s.start),
+		ThreadSent: make([]int64, len(s.threads)),
+		Generated:  atomic.LoadInt64(&s.generated),
+		Failed:     atomic.LoadInt64(&s.failed),
+		Acked:      atomic.LoadInt64(&s.acked),
+		Received:   atomic.LoadInt64(&s.received),
+		Intervals:  atomic.LoadInt64(&s.intervals),
+	}
+	for i := range s.threads {
+		snap.ThreadSent[i] = atomic.LoadInt64(&s.threads[i].sent)
+		snap.Sent += snap.ThreadSent[i]
+	}
+	return snap
+}
+
+// rate returns the per second rate of a counter between two snapshots
+func rate(now, prev int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(now-prev) / d.Seconds()
+}
+
+// SendRate returns messages sent per second since prev
+func (s Snapshot) SendRate(prev Snapshot) float64 {
+	return rate(s.Sent, prev.Sent, s.Time.Sub(prev.Time))
+}
+
+// GenerateRate returns messages generated per second since prev
+func (s Snapshot) GenerateRate(prev Snapshot) float64 {
+	return rate(s.Generated, prev.Generated, s.Time.Sub(prev.Time))
+}
+
+// AckRate returns messages acknowledged per second since prev
+func (s Snapshot) AckRate(prev Snapshot) float64 {
+	return rate(s.Acked, prev.Acked, s.Time.Sub(prev.Time))
+}
+
+// ReceiveRate returns messages received per second since prev
+func (s Snapshot) ReceiveRate(prev Snapshot) float64 {
+	return rate(s.Received, prev.Received, s.Time.Sub(prev.Time))
+}
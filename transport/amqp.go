@@ -0,0 +1,423 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pack.ag/amqp"
+)
+
+func init() {
+	Register("amqp", newAMQP)
+	Register("amqps", newAMQP)
+}
+
+// amqpMessage is an amqp.Message wrapper with room for its properties
+type amqpMessage struct {
+	amqp.Message
+	properties amqp.MessageProperties
+}
+
+// amqpMessagePool recycles the amqp.Message wrappers, the sender marshals the
+// message into its own buffer so they can be reused as soon as Send returns
+var amqpMessagePool = sync.Pool{
+	New: func() interface{} {
+		return &amqpMessage{Message: amqp.Message{Data: make([][]byte, 1)}}
+	},
+}
+
+// amqpTransport holds the AMQP client and sender links so they can be torn
+// down and re-established while the send threads keep running
+type amqpTransport struct {
+	// next counts the sends to spread them round robin over the sessions,
+	// first in the struct to keep it 64 bit aligned for atomic
+	next uint64
+	sync.RWMutex
+	scheme   string
+	host     string
+	amqpAddr string
+	// dialAddress, serverName and virtualHost override the host of the
+	// URL for the TCP connect, TLS and AMQP open when set
+	dialAddress string
+	serverName  string
+	virtualHost string
+	client      *amqp.Client
+	sessions    []*amqpSession
+	acks        chan Outcome
+	// window holds a slot for each unsettled message in flight, nil to
+	// send them synchronously
+	window chan struct{}
+
+	// errLock guards the error counts and the recovery, recovered is
+	// closed once the connection is back after a failure
+	errLock   sync.Mutex
+	errors    ErrorCounts
+	recovered chan struct{}
+	// timings of the connection setups, guarded by the RWMutex
+	timings []ConnectTiming
+}
+
+// amqpSession is one session of the connection with its sender links, each
+// session has its own flow control window
+type amqpSession struct {
+	client  *amqp.Client
+	session *amqp.Session
+	sender  *amqp.Sender
+	// senders are the links to the Message.Address overrides, opened on
+	// the first message to each
+	senders map[string]*amqp.Sender
+}
+
+func newAMQP(cfg Config) (Transport, error) {
+	sessions := cfg.Sessions
+	if sessions < 1 {
+		sessions = 1
+	}
+	t := &amqpTransport{
+		scheme:      cfg.URL.Scheme,
+		host:        cfg.URL.Host,
+		dialAddress: cfg.DialAddress,
+		serverName:  cfg.ServerName,
+		virtualHost: cfg.VirtualHost,
+		amqpAddr:    cfg.URL.Path,
+		sessions:    make([]*amqpSession, sessions),
+		acks:        make(chan Outcome, cfg.AckBuffer),
+	}
+	if cfg.Window > 0 {
+		t.window = make(chan struct{}, cfg.Window)
+	}
+	return t, nil
+}
+
+func (t *amqpTransport) Connect(ctx context.Context) error {
+	client, timing, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessions := make([]*amqpSession, len(t.sessions))
+	for i := range sessions {
+		session, err := client.NewSession()
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("Creating AMQP session: %v", err)
+		}
+
+		sender, err := session.NewSender(
+			amqp.LinkTargetAddress(t.amqpAddr),
+		)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("Creating sender link: %v", err)
+		}
+		sessions[i] = &amqpSession{
+			client:  client,
+			session: session,
+			sender:  sender,
+			senders: map[string]*amqp.Sender{},
+		}
+	}
+
+	t.Lock()
+	old := t.client
+	t.client = client
+	t.sessions = sessions
+	t.timings = append(t.timings, timing)
+	t.Unlock()
+	// a recovery may have raced another reconnection
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// dial sets up a connection the way amqp.Dial does, timing each phase
+func (t *amqpTransport) dial(ctx context.Context) (*amqp.Client, ConnectTiming, error) {
+	var timing ConnectTiming
+	dialHost, port := t.hostPort(t.host)
+	serverName, virtualHost := dialHost, dialHost
+	if t.serverName != "" {
+		serverName = t.serverName
+	}
+	if t.virtualHost != "" {
+		virtualHost = t.virtualHost
+	}
+	if t.dialAddress != "" {
+		dialHost, port = t.hostPort(t.dialAddress)
+	}
+
+	start := time.Now()
+	ip := dialHost
+	if net.ParseIP(dialHost) == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, dialHost)
+		if err != nil {
+			return nil, timing, fmt.Errorf("Resolving AMQP server: %v", err)
+		}
+		ip = addrs[0].IP.String()
+	}
+	timing.DNS = time.Since(start)
+
+	start = time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return nil, timing, fmt.Errorf("Dialing AMQP server: %v", err)
+	}
+	timing.TCP = time.Since(start)
+
+	if t.scheme == "amqps" {
+		start = time.Now()
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, timing, fmt.Errorf("TLS handshake with AMQP server: %v", err)
+		}
+		conn = tlsConn
+		timing.TLS = time.Since(start)
+	}
+
+	start = time.Now()
+	client, err := amqp.New(conn, amqp.ConnServerHostname(virtualHost))
+	if err != nil {
+		conn.Close()
+		return nil, timing, fmt.Errorf("Opening AMQP connection: %v", err)
+	}
+	timing.Open = time.Since(start)
+	return client, timing, nil
+}
+
+// hostPort splits address into its host and port, the default port of the
+// scheme if it has none
+func (t *amqpTransport) hostPort(address string) (string, string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "5672"
+		if t.scheme == "amqps" {
+			port = "5671"
+		}
+	}
+	return host, port
+}
+
+// ConnectTimings returns the timings of the connection setups so far
+func (t *amqpTransport) ConnectTimings() []ConnectTiming {
+	t.RLock()
+	defer t.RUnlock()
+	return append([]ConnectTiming(nil), t.timings...)
+}
+
+// failed counts the error of a send on client and, if the link or the
+// connection is gone, reconnects in the background. The sends wait for the
+// recovery rather than fail one after the other in the meantime.
+func (t *amqpTransport) failed(ctx context.Context, client *amqp.Client, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	t.errLock.Lock()
+	defer t.errLock.Unlock()
+	switch err.(type) {
+	case *amqp.Error:
+		t.errors.Rejections++
+		return
+	case *amqp.DetachError:
+		t.errors.LinkDetaches++
+	default:
+		if err == amqp.ErrLinkClosed {
+			t.errors.LinkDetaches++
+		} else {
+			t.errors.ConnectionErrors++
+		}
+	}
+	if t.recovered != nil {
+		return
+	}
+	t.recovered = make(chan struct{})
+	go t.recover(ctx, client, err)
+}
+
+// recover reconnects until it succeeds or ctx is done, unless client has
+// been replaced already
+func (t *amqpTransport) recover(ctx context.Context, client *amqp.Client, cause error) {
+	start := time.Now()
+	log.Printf("AMQP send failed, reconnecting: %v", cause)
+	for ctx.Err() == nil {
+		t.RLock()
+		replaced := t.client != client
+		t.RUnlock()
+		if replaced {
+			break
+		}
+		err := t.Connect(ctx)
+		if err == nil {
+			break
+		}
+		log.Println("Reconnecting:", err)
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+		}
+	}
+	d := time.Since(start)
+
+	t.errLock.Lock()
+	defer t.errLock.Unlock()
+	if ctx.Err() == nil {
+		log.Printf("AMQP reconnected after %v", d)
+		t.errors.Recoveries++
+		t.errors.Recovery += d
+		if d > t.errors.LongestRecovery {
+			t.errors.LongestRecovery = d
+		}
+	}
+	close(t.recovered)
+	t.recovered = nil
+}
+
+// awaitRecovery waits for an ongoing recovery to finish
+func (t *amqpTransport) awaitRecovery(ctx context.Context) error {
+	t.errLock.Lock()
+	recovered := t.recovered
+	t.errLock.Unlock()
+	if recovered == nil {
+		return nil
+	}
+	select {
+	case <-recovered:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Errors returns the error counts so far
+func (t *amqpTransport) Errors() ErrorCounts {
+	t.errLock.Lock()
+	defer t.errLock.Unlock()
+	return t.errors
+}
+
+// addressSender returns the sender link of s to address, opening it if needed
+func (t *amqpTransport) addressSender(s *amqpSession, address string) (*amqp.Sender, error) {
+	t.RLock()
+	sender, ok := s.senders[address]
+	t.RUnlock()
+	if ok {
+		return sender, nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if sender, ok := s.senders[address]; ok {
+		return sender, nil
+	}
+	sender, err := s.session.NewSender(
+		amqp.LinkTargetAddress(address),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Creating sender link to %s: %v", address, err)
+	}
+	s.senders[address] = sender
+	return sender, nil
+}
+
+// Send blocks until the message is transferred. For unsettled messages it
+// also waits for the disposition, which is reported on the ack channel,
+// unless there is a window: then it only waits for a free slot and the
+// disposition is awaited in the background.
+func (t *amqpTransport) Send(ctx context.Context, msg *Message) error {
+	if err := t.awaitRecovery(ctx); err != nil {
+		return err
+	}
+	n := atomic.AddUint64(&t.next, 1)
+	t.RLock()
+	s := t.sessions[n%uint64(len(t.sessions))]
+	t.RUnlock()
+	sender := s.sender
+	if msg.Address != "" {
+		var err error
+		if sender, err = t.addressSender(s, msg.Address); err != nil {
+			t.failed(ctx, s.client, err)
+			return err
+		}
+	}
+
+	if msg.Settled || t.window == nil {
+		return t.send(ctx, s, sender, msg)
+	}
+	select {
+	case t.window <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	go func() {
+		t.send(ctx, s, sender, msg)
+		<-t.window
+	}()
+	return nil
+}
+
+// send transfers msg on sender of s and reports the outcome if it's
+// unsettled
+func (t *amqpTransport) send(ctx context.Context, s *amqpSession, sender *amqp.Sender, msg *Message) error {
+	m := amqpMessagePool.Get().(*amqpMessage)
+	m.Data[0] = msg.Body
+	m.SendSettled = msg.Settled
+	m.ApplicationProperties = msg.Properties
+	if !msg.Created.IsZero() {
+		m.properties.CreationTime = msg.Created
+		m.Properties = &m.properties
+	}
+	err := sender.Send(ctx, &m.Message)
+	m.Data[0] = nil
+	m.ApplicationProperties = nil
+	m.Properties = nil
+	amqpMessagePool.Put(m)
+	if err != nil {
+		t.failed(ctx, s.client, err)
+	}
+	if msg.Settled {
+		return err
+	}
+	select {
+	case t.acks <- Outcome{Message: msg, Error: err}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *amqpTransport) Acks() <-chan Outcome {
+	return t.acks
+}
+
+func (t *amqpTransport) Close() error {
+	t.RLock()
+	client := t.client
+	t.RUnlock()
+	return client.Close()
+}
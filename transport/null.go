@@ -0,0 +1,64 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package transport
+
+import (
+	"context"
+)
+
+func init() {
+	Register("null", newNull)
+}
+
+// nullTransport discards every message. It measures the generation side of
+// the bench on its own, e.g. send -hosts 1000 null://
+type nullTransport struct {
+	acks chan Outcome
+}
+
+func newNull(cfg Config) (Transport, error) {
+	return &nullTransport{
+		acks: make(chan Outcome, cfg.AckBuffer),
+	}, nil
+}
+
+func (t *nullTransport) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (t *nullTransport) Send(ctx context.Context, msg *Message) error {
+	if msg.Settled {
+		return nil
+	}
+	select {
+	case t.acks <- Outcome{Message: msg}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *nullTransport) Acks() <-chan Outcome {
+	return t.acks
+}
+
+func (t *nullTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package transport defines the interface the send loop uses to deliver
+// generated messages, and a registry of implementations keyed by URL scheme.
+//
+// The built-in transports are pure Go. One that needs cgo, such as a binding
+// of Qpid Proton, registers itself from a file behind a build tag named after
+// it (e.g. //go:build proton), so the default build stays static and cross
+// compiles without a C toolchain.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Message is a generated payload handed to a Transport
+type Message struct {
+	Body []byte
+	// Settled messages are sent at-most-once and produce no Outcome
+	Settled bool
+	// Address overrides the target address of the URL when set, so one
+	// connection can feed several addresses
+	Address string
+	// Properties are sent as AMQP application properties when set
+	Properties map[string]interface{}
+	// Created is sent as the AMQP creation-time when set
+	Created time.Time
+}
+
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return &Message{Body: make([]byte, 0, 1024)}
+	},
+}
+
+// NewMessage returns a Message from the pool. Its Body is empty but keeps the
+// capacity of earlier uses, so appending a payload usually doesn't allocate.
+func NewMessage() *Message {
+	msg := messagePool.Get().(*Message)
+	msg.Body = msg.Body[:0]
+	msg.Settled = false
+	msg.Address = ""
+	msg.Properties = nil
+	msg.Created = time.Time{}
+	return msg
+}
+
+// Release returns msg to the pool once neither the transport nor the ack
+// handling need it anymore. The Message must not be used afterwards.
+func (m *Message) Release() {
+	messagePool.Put(m)
+}
+
+// Outcome reports the acknowledgement of an unsettled Message
+type Outcome struct {
+	Message *Message
+	Error   error
+}
+
+// Transport delivers messages to a single endpoint. Send must be safe for
+// concurrent use by several send threads, and must keep working across a
+// Close followed by a new Connect.
+type Transport interface {
+	Connect(ctx context.Context) error
+	Send(ctx context.Context, msg *Message) error
+	// Acks returns the channel on which outcomes of unsettled messages are
+	// delivered. It must be drained by the caller.
+	Acks() <-chan Outcome
+	Close() error
+}
+
+// ErrorCounts are the transport errors of a connection and its recoveries
+type ErrorCounts struct {
+	// LinkDetaches counts the sender links detached by the peer
+	LinkDetaches int64
+	// ConnectionErrors counts the connection and session failures
+	ConnectionErrors int64
+	// Rejections counts the unsettled messages the peer rejected
+	Rejections int64
+	// Recoveries counts the reconnections after a failure, which took
+	// Recovery in total and LongestRecovery at most
+	Recoveries      int64
+	Recovery        time.Duration
+	LongestRecovery time.Duration
+}
+
+// Add returns the sum of the counts of c and o
+func (c ErrorCounts) Add(o ErrorCounts) ErrorCounts {
+	c.LinkDetaches += o.LinkDetaches
+	c.ConnectionErrors += o.ConnectionErrors
+	c.Rejections += o.Rejections
+	c.Recoveries += o.Recoveries
+	c.Recovery += o.Recovery
+	if o.LongestRecovery > c.LongestRecovery {
+		c.LongestRecovery = o.LongestRecovery
+	}
+	return c
+}
+
+// ErrorCounter is implemented by the transports that count their errors
+type ErrorCounter interface {
+	Errors() ErrorCounts
+}
+
+// ConnectTiming is how long the phases of setting up a connection took
+type ConnectTiming struct {
+	DNS time.Duration
+	TCP time.Duration
+	// TLS is zero without TLS
+	TLS time.Duration
+	// Open is the AMQP protocol header and open exchange
+	Open time.Duration
+}
+
+// ConnectTimer is implemented by the transports that time their connection
+// setups
+type ConnectTimer interface {
+	// ConnectTimings returns the timings of every Connect so far
+	ConnectTimings() []ConnectTiming
+}
+
+// Config is handed to a Factory when a Transport is created
+type Config struct {
+	// URL of the endpoint, the path selects the target address
+	URL *url.URL
+	// AckBuffer is the size of the channel returned by Acks
+	AckBuffer int
+	// Sessions is the number of sessions to open on the connection and
+	// spread the sends across, for transports that have them (0 means 1)
+	Sessions int
+	// Window is the number of unsettled messages a transport keeps in
+	// flight before Send blocks. With 0 every Send waits for its own
+	// disposition, so the window is the number of concurrent senders.
+	Window int
+	// DialAddress is the host:port to connect to instead of the URL's,
+	// e.g. the IP of a load balancer in front of the router
+	DialAddress string
+	// ServerName is the TLS SNI name and VirtualHost the AMQP hostname
+	// sent instead of the host of the URL, when set
+	ServerName  string
+	VirtualHost string
+}
+
+// Factory creates a Transport for the given configuration
+type Factory func(cfg Config) (Transport, error)
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Factory{}
+)
+
+// Register makes a Transport available for URLs with the given scheme
+func Register(scheme string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, dup := registry[scheme]; dup {
+		panic("transport: Register called twice for scheme " + scheme)
+	}
+	registry[scheme] = factory
+}
+
+// Schemes returns the registered URL schemes
+func Schemes() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// New creates a Transport for rawurl using the implementation registered for
+// its scheme. The returned Transport is not connected yet.
+func New(rawurl string, cfg Config) (Transport, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	registryLock.RLock()
+	factory, ok := registry[u.Scheme]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported transport %q (supported: %v)", u.Scheme, Schemes())
+	}
+
+	cfg.URL = u
+	return factory(cfg)
+}